@@ -0,0 +1,258 @@
+package cfg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"arp242.net/sconfig"
+)
+
+// AddedName is the file name of the file where the hosts and regexps added or
+// removed with "trackwall host" and "trackwall regexp", or always allowed from
+// the blocked page, are saved. This file is managed by trackwall and replaced
+// on every change; the configuration needs to source it from the chroot, for
+// example with "source /var/trackwall/config.managed".
+const AddedName = "config.managed"
+
+// AddedPath is the path of the sourced AddedName file; this is "" if the
+// configuration doesn't source it, in which case changes can't be saved.
+var AddedPath string
+
+// The rules in AddedPath. They're removed from the Config and added after all
+// the other rules, so they always have the final say.
+//
+// The file is read when the configuration is loaded; path is where it's
+// written to after the chroot, as set by ChrootAdded().
+var added struct {
+	sync.Mutex
+	rules rulesT
+	path  string
+}
+
+// Get the AddedName file sourced in the configuration file at path, or "" if
+// it's not sourced.
+func findAdded(path string) (string, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close() // nolint: errcheck
+
+	// sconfig only reads "source " at the start of the line.
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "source ") {
+			continue
+		}
+		src := strings.TrimSpace(line[7:])
+		if filepath.Base(src) == AddedName {
+			return src, nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// Read the rules from the sourced AddedName file, and remove them from the
+// Config.
+func loadAdded(path string) error {
+	added.Lock()
+	defer added.Unlock()
+
+	AddedPath, added.rules, added.path = path, rulesT{}, ""
+	if path == "" {
+		return nil
+	}
+
+	rules, err := parseAdded(path)
+	if err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	added.rules = rules
+	for _, h := range rules.Hosts {
+		Config.Hosts = withoutLast(Config.Hosts, h)
+	}
+	for _, h := range rules.Unhosts {
+		Config.Unhosts = withoutLast(Config.Unhosts, h)
+	}
+	for _, re := range rules.Regexps {
+		Config.Regexps = withoutLast(Config.Regexps, re)
+	}
+	for _, re := range rules.Unregexps {
+		Config.Unregexps = withoutLast(Config.Unregexps, re)
+	}
+	return nil
+}
+
+// ChrootAdded gets the path of AddedPath in the chroot, so it can be written
+// to after the chroot; this needs to be done before the chroot.
+//
+// The file is replaced with a rename, which needs a directory that the user
+// trackwall runs as can write to.
+func ChrootAdded() error {
+	added.Lock()
+	defer added.Unlock()
+
+	if AddedPath == "" {
+		return nil
+	}
+	rel, err := filepath.Rel(Config.Chroot, AddedPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("%v isn't in the chroot %v; changes can't be saved", AddedPath, Config.Chroot)
+	}
+	added.path = "/" + rel
+	return nil
+}
+
+// Add the rules from AddedPath.
+func readAdded() {
+	added.Lock()
+	rules := added.rules
+	added.Unlock()
+
+	o := Origin{Source: AddedPath}
+	reading.origins.Set(OriginHost, o, rules.Hosts...)
+	reading.origins.Set(OriginUnhost, o, rules.Unhosts...)
+	reading.origins.Set(OriginRegexp, o, rules.Regexps...)
+	reading.origins.Set(OriginUnregexp, o, rules.Unregexps...)
+
+	reading.hosts.Add(rules.Hosts...)
+	reading.hosts.Remove(rules.Unhosts...)
+	for _, re := range rules.Regexps {
+		if !reading.regexps.Has(re) {
+			reading.regexps.Add(re)
+		}
+	}
+	reading.regexps.Remove(rules.Unregexps...)
+}
+
+func parseAdded(path string) (rulesT, error) {
+	var rules rulesT
+	err := sconfig.Parse(&rules, path, nil)
+	return rules, err
+}
+
+// SaveAdded saves rules added (or removed, if add is false) with the control
+// socket to AddedPath, so they're loaded again on the next start. The kind is
+// OriginHost or OriginRegexp.
+func SaveAdded(kind string, add bool, rules ...string) error {
+	return saveAdded(kind, add, false, rules...)
+}
+
+// UndoAdded undoes SaveAdded() by removing the rules, without adding the
+// opposite entry; the rules from the lists apply again.
+func UndoAdded(kind string, add bool, rules ...string) error {
+	return saveAdded(kind, add, true, rules...)
+}
+
+func saveAdded(kind string, add, undo bool, rules ...string) error {
+	added.Lock()
+	defer added.Unlock()
+
+	if AddedPath == "" {
+		return fmt.Errorf("the configuration doesn't source %v", AddedName)
+	}
+	if added.path == "" {
+		return fmt.Errorf("%v isn't in the chroot %v", AddedPath, Config.Chroot)
+	}
+
+	// Copy the slices, so that nothing is changed if the write fails.
+	r := rulesT{
+		Hosts:     append([]string(nil), added.rules.Hosts...),
+		Unhosts:   append([]string(nil), added.rules.Unhosts...),
+		Regexps:   append([]string(nil), added.rules.Regexps...),
+		Unregexps: append([]string(nil), added.rules.Unregexps...),
+	}
+
+	// Removing something that was added earlier isn't enough, as it may also be
+	// in a list; always write an unhost or unregexp.
+	var list, unlist *[]string
+	switch kind {
+	case OriginHost:
+		list, unlist = &r.Hosts, &r.Unhosts
+	case OriginRegexp:
+		list, unlist = &r.Regexps, &r.Unregexps
+	default:
+		return fmt.Errorf("unknown kind: %#v", kind)
+	}
+	if !add {
+		list, unlist = unlist, list
+	}
+	for _, rule := range rules {
+		*unlist = without(*unlist, rule)
+		if undo {
+			*list = without(*list, rule)
+		} else if !contains(*list, rule) {
+			*list = append(*list, rule)
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString("# This file is managed by trackwall; the hosts and regexps added with\n")
+	b.WriteString("# \"trackwall host\" and \"trackwall regexp\", and the hosts that are always\n")
+	b.WriteString("# allowed from the blocked page, are saved here.\n")
+	for _, section := range []struct {
+		key   string
+		rules []string
+	}{
+		{"host", r.Hosts},
+		{"unhost", r.Unhosts},
+		{"regexp", r.Regexps},
+		{"unregexp", r.Unregexps},
+	} {
+		if len(section.rules) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%v\n", section.key)
+		for _, rule := range section.rules {
+			fmt.Fprintf(&b, "\t%v\n", escapeValue(rule))
+		}
+	}
+
+	if err := WriteAtomic(added.path, b.Bytes()); err != nil {
+		return err
+	}
+	added.rules = r
+	return nil
+}
+
+// Escape a value for sconfig, which removes backslashes and comments.
+func escapeValue(v string) string {
+	v = strings.Replace(v, `\`, `\\`, -1)
+	return strings.Replace(v, "#", `\#`, -1)
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove the last occurrence of s from l; the sourced file is read last, so
+// that's the one that came from it.
+func withoutLast(l []string, s string) []string {
+	for i := len(l) - 1; i >= 0; i-- {
+		if l[i] == s {
+			return append(l[:i:i], l[i+1:]...)
+		}
+	}
+	return l
+}
+
+func without(l []string, s string) []string {
+	n := l[:0]
+	for _, v := range l {
+		if v != s {
+			n = append(n, v)
+		}
+	}
+	return n
+}
@@ -0,0 +1,113 @@
+package cfg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"arp242.net/trackwall/tt"
+)
+
+func TestSaveAdded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackwall-added")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	defer closeAdded()
+	path := filepath.Join(dir, AddedName)
+	tt.Err(t, ioutil.WriteFile(path, nil, 0644))
+	conf := filepath.Join(dir, "config")
+	tt.Err(t, ioutil.WriteFile(conf, []byte("host example.com\nsource "+path+"\n"), 0644))
+
+	if SaveAdded(OriginHost, true, "example.com") == nil {
+		t.Error("no error if the file isn't sourced")
+	}
+
+	src, err := findAdded(conf)
+	tt.Err(t, err)
+	tt.Eq(t, "sourced", path, src)
+	tt.Err(t, loadAdded(src))
+	if ChrootAdded() == nil {
+		t.Error("no error if the file isn't in the chroot")
+	}
+	Config.Chroot = dir
+	defer func() { Config.Chroot = "" }()
+	tt.Err(t, ChrootAdded())
+	tt.Eq(t, "path in chroot", "/"+AddedName, added.path)
+
+	// Not actually chrooted here.
+	added.path = path
+
+	steps := []struct {
+		kind     string
+		add      bool
+		undo     bool
+		rules    []string
+		expected rulesT
+	}{
+		{OriginHost, true, false, []string{"a.example.com", "b.example.com"},
+			rulesT{Hosts: []string{"a.example.com", "b.example.com"}}},
+		{OriginHost, true, false, []string{"a.example.com"},
+			rulesT{Hosts: []string{"a.example.com", "b.example.com"}}},
+		{OriginHost, false, false, []string{"a.example.com", "c.example.com"},
+			rulesT{Hosts: []string{"b.example.com"}, Unhosts: []string{"a.example.com", "c.example.com"}}},
+		{OriginRegexp, true, false, []string{`^ads?\.`, `x#y`},
+			rulesT{Hosts: []string{"b.example.com"}, Unhosts: []string{"a.example.com", "c.example.com"},
+				Regexps: []string{`^ads?\.`, `x#y`}}},
+		{OriginHost, true, false, []string{"c.example.com"},
+			rulesT{Hosts: []string{"b.example.com", "c.example.com"}, Unhosts: []string{"a.example.com"},
+				Regexps: []string{`^ads?\.`, `x#y`}}},
+		{OriginHost, false, true, []string{"a.example.com"},
+			rulesT{Hosts: []string{"b.example.com", "c.example.com"},
+				Regexps: []string{`^ads?\.`, `x#y`}}},
+	}
+
+	for _, s := range steps {
+		tt.Err(t, saveAdded(s.kind, s.add, s.undo, s.rules...))
+		out, err := parseAdded(path)
+		tt.Err(t, err)
+		tt.Eq(t, "rules", s.expected, out)
+	}
+
+	// The saved rules are applied on reload.
+	defer Hosts.Purge()
+	defer Origins.Purge()
+	Hosts.Add("a.example.com")
+	readAdded()
+	tt.Eq(t, "applied", []string{"a.example.com", "b.example.com", "c.example.com"}, Hosts.List(""))
+
+	if saveAdded(OriginUnhost, true, false, "x") == nil {
+		t.Error("no error for unknown kind")
+	}
+}
+
+func TestLoadAdded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackwall-added")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	defer closeAdded()
+	defer Hosts.Purge()
+	defer Origins.Purge()
+	defer func() { Config.Hosts, Config.Unhosts = nil, nil }()
+
+	path := filepath.Join(dir, AddedName)
+	tt.Err(t, ioutil.WriteFile(path, []byte("host\n\ta.example.com\nunhost\n\tb.example.com\n"), 0644))
+
+	// The rules from the sourced file are removed from the config, so that
+	// removing them from the file works for a reload as well.
+	Config.Hosts = []string{"a.example.com", "c.example.com", "a.example.com"}
+	Config.Unhosts = []string{"b.example.com"}
+	tt.Err(t, loadAdded(path))
+	tt.Eq(t, "config hosts", []string{"a.example.com", "c.example.com"}, Config.Hosts)
+	tt.Eq(t, "config unhosts", []string{}, Config.Unhosts)
+
+	Hosts.Add("b.example.com")
+	readAdded()
+	tt.Eq(t, "hosts", []string{"a.example.com"}, Hosts.List(""))
+	o, _ := Origins.Get(OriginUnhost, "b.example.com")
+	tt.Eq(t, "origin", Origin{Source: path}, o)
+}
+
+func closeAdded() {
+	_ = loadAdded("")
+}
@@ -88,7 +88,8 @@ func Load(path string) error {
 			return u, nil
 		})
 
-	return sconfig.Parse(&Config, path, sconfig.Handlers{
+	controlToken = ""
+	err := sconfig.Parse(&Config, path, sconfig.Handlers{
 		"CacheDNS": func(l []string) error {
 			Config.CacheDNS, _ = msg.DurationToSeconds(l[0])
 			return nil
@@ -98,28 +99,29 @@ func Load(path string) error {
 			return nil
 		},
 		"Hostlists": func(l []string) error {
-			for _, v := range l[1:] {
-				Config.Hostlists = append(Config.Hostlists, []string{l[0], v})
-			}
-			return nil
+			lists, err := listArgs(l)
+			Config.Hostlists = append(Config.Hostlists, lists...)
+			return err
 		},
 		"Unhostlists": func(l []string) error {
-			for _, v := range l[1:] {
-				Config.Unhostlists = append(Config.Unhostlists, []string{l[0], v})
+			// Exceptions and such are added to the filters, so it can't be
+			// used to remove hosts.
+			if l[0] == "adblock" {
+				return fmt.Errorf("the adblock format can't be used with unhostlist; use @@ exceptions in a hostlist")
 			}
-			return nil
+			lists, err := listArgs(l)
+			Config.Unhostlists = append(Config.Unhostlists, lists...)
+			return err
 		},
 		"Regexplists": func(l []string) error {
-			for _, v := range l[1:] {
-				Config.Regexplists = append(Config.Regexplists, []string{l[0], v})
-			}
-			return nil
+			lists, err := listArgs(l)
+			Config.Regexplists = append(Config.Regexplists, lists...)
+			return err
 		},
 		"Unregexplists": func(l []string) error {
-			for _, v := range l[1:] {
-				Config.Regexplists = append(Config.Regexplists, []string{l[0], v})
-			}
-			return nil
+			lists, err := listArgs(l)
+			Config.Unregexplists = append(Config.Unregexplists, lists...)
+			return err
 		},
 		"Hosts": func(l []string) error {
 			Config.Hosts = append(Config.Hosts, l...)
@@ -137,11 +139,164 @@ func Load(path string) error {
 			Config.Unregexps = append(Config.Unregexps, l...)
 			return nil
 		},
+		"Rpz": func(l []string) error {
+			if len(l) != 2 {
+				return fmt.Errorf("need an origin and source")
+			}
+			if !strings.HasPrefix(l[1], "file://") && !strings.HasPrefix(l[1], "axfr://") {
+				return fmt.Errorf("source must be file:// or axfr://: %v", l[1])
+			}
+			Config.Rpz = append(Config.Rpz, l)
+			return nil
+		},
+		"RpzServe": func(l []string) error {
+			if len(l) != 1 {
+				return fmt.Errorf("need exactly one origin")
+			}
+			Config.RpzServe = strings.ToLower(strings.TrimRight(l[0], "."))
+			return nil
+		},
+		"RpzNotify": func(l []string) error {
+			for _, v := range l {
+				a := &AddrT{}
+				a.set(v)
+				Config.RpzNotify = append(Config.RpzNotify, a.String())
+			}
+			return nil
+		},
+		"RpzAllowTransfer": func(l []string) error {
+			for _, v := range l {
+				n, err := parseNet(v)
+				if err != nil {
+					return err
+				}
+				Config.RpzAllowTransfer = append(Config.RpzAllowTransfer, n)
+			}
+			return nil
+		},
+		"ClientGroups": func(l []string) error {
+			if len(l) < 2 {
+				return fmt.Errorf("need a name and at least one address")
+			}
+			if net.ParseIP(l[0]) != nil || l[0] == "all" {
+				return fmt.Errorf("invalid group name: %v", l[0])
+			}
+			if Config.ClientGroups == nil {
+				Config.ClientGroups = make(map[string][]*net.IPNet)
+			}
+			for _, v := range l[1:] {
+				n, err := parseNet(v)
+				if err != nil {
+					return err
+				}
+				Config.ClientGroups[l[0]] = append(Config.ClientGroups[l[0]], n)
+			}
+			return nil
+		},
+		"IncludeRules": func(l []string) error {
+			lists, err := urlArgs(l)
+			Config.IncludeRules = append(Config.IncludeRules, lists...)
+			return err
+		},
 		"Surrogates": func(l []string) error {
 			Config.Surrogates = append(Config.Surrogates, []string{l[0], strings.Join(l[1:], " ")})
 			return nil
 		},
+		"ControlAllowUsers": func(l []string) error {
+			for _, name := range l {
+				if err := Config.allowUser(name); err != nil {
+					return err
+				}
+			}
+			Config.ControlAllowUsers = append(Config.ControlAllowUsers, l...)
+			return nil
+		},
+		"ControlAllowGroups": func(l []string) error {
+			for _, name := range l {
+				if err := Config.allowGroup(name, "/etc/group"); err != nil {
+					return err
+				}
+			}
+			Config.ControlAllowGroups = append(Config.ControlAllowGroups, l...)
+			return nil
+		},
+		"ControlTokenFile": func(l []string) error {
+			if len(l) != 1 {
+				return fmt.Errorf("need exactly one path")
+			}
+			token, err := readToken(l[0])
+			if err != nil {
+				return err
+			}
+			Config.ControlTokenFile = l[0]
+			controlToken = token
+			return nil
+		},
 	})
+	if err != nil {
+		return err
+	}
+
+	src, err := findAdded(path)
+	if err != nil {
+		return err
+	}
+	return loadAdded(src)
+}
+
+// Parse the arguments for the *list options: the format followed by one or more
+// URLs (see urlArgs()).
+func listArgs(l []string) ([][]string, error) {
+	if len(l) < 2 {
+		return nil, fmt.Errorf("need a format and at least one URL")
+	}
+	if _, ok := formats[l[0]]; !ok {
+		return nil, fmt.Errorf("unknown format: %v", l[0])
+	}
+
+	lists, err := urlArgs(l[1:])
+	for i := range lists {
+		lists[i] = append([]string{l[0]}, lists[i]...)
+	}
+	return lists, err
+}
+
+// Parse a list of URLs, which can be followed by a verification option (e.g.
+// sha256:<hex>).
+func urlArgs(l []string) ([][]string, error) {
+	if len(l) == 0 {
+		return nil, fmt.Errorf("need at least one URL")
+	}
+
+	var lists [][]string
+	for _, v := range l {
+		if !isVerify(v) {
+			lists = append(lists, []string{v})
+			continue
+		}
+
+		if len(lists) == 0 || len(lists[len(lists)-1]) > 1 {
+			return nil, fmt.Errorf("%v must follow an URL", v)
+		}
+		if err := parseVerify(v); err != nil {
+			return nil, err
+		}
+		lists[len(lists)-1] = append(lists[len(lists)-1], v)
+	}
+	return lists, nil
+}
+
+// Parse an IP address or CIDR range.
+func parseNet(v string) (*net.IPNet, error) {
+	if !strings.Contains(v, "/") {
+		if strings.Contains(v, ":") {
+			v += "/128"
+		} else {
+			v += "/32"
+		}
+	}
+	_, n, err := net.ParseCIDR(v)
+	return n, err
 }
 
 // nolint: megacheck
@@ -1,8 +1,14 @@
 package cfg
 
 import (
+	"io/ioutil"
+	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"arp242.net/trackwall/tt"
 )
 
 func TestAddrT(t *testing.T) {
@@ -52,3 +58,59 @@ func TestUserT(t *testing.T) {
 		}
 	}
 }
+
+func TestListArgs(t *testing.T) {
+	sum := "sha256:391196688aa55d3321deffa736f8d103b4813470952b748e9c2c9deb17fa60f5"
+	cases := []struct {
+		in          []string
+		expected    [][]string
+		expectedErr bool
+	}{
+		{[]string{"hosts", "http://a"}, [][]string{{"hosts", "http://a"}}, false},
+		{[]string{"hosts", "http://a", sum, "http://b"},
+			[][]string{{"hosts", "http://a", sum}, {"hosts", "http://b"}}, false},
+
+		{[]string{"hosts"}, nil, true},
+		{[]string{"nope", "http://a"}, nil, true},
+		{[]string{"hosts", sum}, nil, true},
+		{[]string{"hosts", "http://a", sum, sum}, nil, true},
+		{[]string{"hosts", "http://a", "sha256:xx"}, nil, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(strings.Join(tc.in, " "), func(t *testing.T) {
+			out, err := listArgs(tc.in)
+			tt.Eq(t, "err", tc.expectedErr, err != nil)
+			if err == nil {
+				tt.Eq(t, "lists", tc.expected, out)
+			}
+		})
+	}
+}
+
+// unregexplist used to be added to the regexplists.
+func TestLoadUnregexplist(t *testing.T) {
+	defer func(c ConfigT) { Config = c }(Config)
+	defer Regexps.Purge()
+	defer Origins.Purge()
+
+	dir, err := ioutil.TempDir("", "trackwall-cfg")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	re := filepath.Join(dir, "regexps")
+	tt.Err(t, ioutil.WriteFile(re, []byte("^ads?\\.\n^track\\.\n"), 0644))
+	unre := filepath.Join(dir, "unregexps")
+	tt.Err(t, ioutil.WriteFile(unre, []byte("^track\\.\n"), 0644))
+	conf := filepath.Join(dir, "config")
+	tt.Err(t, ioutil.WriteFile(conf, []byte(
+		"regexplist plain file://"+re+"\nunregexplist plain file://"+unre+"\n"), 0644))
+
+	Config = ConfigT{}
+	tt.Err(t, Load(conf))
+	tt.Eq(t, "regexplists", [][]string{{"plain", "file://" + re}}, Config.Regexplists)
+	tt.Eq(t, "unregexplists", [][]string{{"plain", "file://" + unre}}, Config.Unregexplists)
+
+	tt.Err(t, Config.Reload())
+	tt.Eq(t, "regexp", true, Regexps.Match("ads.example.com"))
+	tt.Eq(t, "unregexp", false, Regexps.Match("track.example.com"))
+}
@@ -0,0 +1,137 @@
+package cfg
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+// Compression formats for lists.
+const (
+	compressNone  = ""
+	compressGzip  = "gzip"
+	compressBzip2 = "bzip2"
+	compressXz    = "xz"
+	compressZip   = "zip"
+)
+
+// Magic bytes at the start of compressed files.
+var compressMagic = []struct {
+	magic  []byte
+	format string
+}{
+	{[]byte{0x1f, 0x8b}, compressGzip},
+	{[]byte("BZh"), compressBzip2},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, compressXz},
+	{[]byte("PK\x03\x04"), compressZip},
+}
+
+// Detect the compression from the magic bytes in head, falling back to the
+// extension of name.
+func detectCompression(name string, head []byte) string {
+	for _, m := range compressMagic {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.format
+		}
+	}
+
+	if i := strings.IndexAny(name, "?#"); i > -1 {
+		name = name[:i]
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".gz":
+		return compressGzip
+	case ".bz2":
+		return compressBzip2
+	case ".xz":
+		return compressXz
+	case ".zip":
+		return compressZip
+	}
+	return compressNone
+}
+
+// readCloser closes the underlying file of a decompressing reader.
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error { return r.close() }
+
+// Decompress the file fp if it's compressed; name is used to detect the format
+// if the magic bytes don't match anything.
+//
+// All files in a zip archive are read, in order.
+func decompress(fp *os.File, name string) (io.ReadCloser, error) {
+	br := bufio.NewReader(fp)
+	head, _ := br.Peek(6)
+
+	format := detectCompression(name, head)
+	var r io.Reader
+	closers := []io.Closer{fp}
+	switch format {
+	case compressNone:
+		r = br
+	case compressGzip:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %v", err)
+		}
+		r = gz
+	case compressBzip2:
+		r = bzip2.NewReader(br)
+	case compressXz:
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("xz: %v", err)
+		}
+		r = xr
+	case compressZip:
+		stat, err := fp.Stat()
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(fp, stat.Size())
+		if err != nil {
+			return nil, fmt.Errorf("zip: %v", err)
+		}
+
+		var files []io.Reader
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("zip: %v: %v", f.Name, err)
+			}
+			// Make sure the last line of one file doesn't run in to the first
+			// line of the next one.
+			files = append(files, rc, strings.NewReader("\n"))
+			closers = append(closers, rc)
+		}
+		r = io.MultiReader(files...)
+	default:
+		return nil, fmt.Errorf("%v compression is not supported", format)
+	}
+
+	return readCloser{r, func() error {
+		var err error
+		for _, c := range closers {
+			if cerr := c.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}}, nil
+}
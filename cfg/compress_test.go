@@ -0,0 +1,83 @@
+package cfg
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"arp242.net/trackwall/tt"
+)
+
+func TestDecompress(t *testing.T) {
+	list := "example.com\nexample.net\n"
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, _ = w.Write([]byte(list))
+	tt.Err(t, w.Close())
+
+	// bzip2 -c
+	bz, err := base64.StdEncoding.DecodeString("QlpoOTFBWSZTWVnCDK8AAAVRgAAQAAEqB8RAIAAxA0DQEqBibIqkZHy6GNEtC1gu5IpwoSCzhBle")
+	tt.Err(t, err)
+
+	// xz -c
+	xzed, err := base64.StdEncoding.DecodeString("/Td6WFoAAATm1rRGBMAcGCEBFgAAAAAAAAAAABrtH3YBABdleGFtcGxlLmNvbQpleGFtcGxlLm5ldAoAqYCrk9/JdvkAATgYhpF1JB+2830BAAAAAARZWg==")
+	tt.Err(t, err)
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for _, f := range []string{"a.txt", "b.txt"} {
+		fw, err := zw.Create(f)
+		tt.Err(t, err)
+		_, _ = fw.Write([]byte("example.org"))
+	}
+	tt.Err(t, zw.Close())
+
+	cases := []struct {
+		name        string
+		in          []byte
+		expected    string
+		expectedErr bool
+	}{
+		{"list", []byte(list), list, false},
+		{"list.gz", gz.Bytes(), list, false},
+		{"list", gz.Bytes(), list, false},
+		{"list.bz2?x=y", bz, list, false},
+		{"list.zip", zipped.Bytes(), "example.org\nexample.org\n", false},
+		{"list.xz", xzed, list, false},
+		{"list", xzed, list, false},
+		{"list.xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00}, "", true},
+		{"list.gz", []byte(list), "", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fp, err := ioutil.TempFile("", "trackwall-compress")
+			tt.Err(t, err)
+			defer os.Remove(fp.Name()) // nolint: errcheck
+			_, err = fp.Write(tc.in)
+			tt.Err(t, err)
+			_, err = fp.Seek(0, 0)
+			tt.Err(t, err)
+
+			r, err := decompress(fp, "http://example.com/"+tc.name)
+			if tc.expectedErr {
+				if err == nil {
+					t.Error("no error")
+				}
+				_ = fp.Close()
+				return
+			}
+			tt.Err(t, err)
+
+			out, err := ioutil.ReadAll(r)
+			tt.Err(t, err)
+			tt.Err(t, r.Close())
+			tt.Eq(t, "list", tc.expected, string(out))
+		})
+	}
+}
@@ -1,9 +1,10 @@
 package cfg
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -17,18 +18,31 @@ import (
 // ConfigT holds the configuration.
 type ConfigT struct {
 	ControlListen *AddrT
-	DNSListen     *AddrT
-	DNSForward    *AddrT
-	HTTPListen    *AddrT
-	HTTPSListen   *AddrT
-	RootCert      string
-	RootKey       string
-	User          *UserT
-	Chroot        string
-	CacheHosts    int64
-	CacheDNS      int64
-	Color         bool
-	Verbose       int
+	ControlSocket string
+	ControlTLS    bool
+
+	// Users and groups allowed to use the control socket, and the bearer token
+	// for control-listen.
+	ControlAllowUsers  []string
+	ControlAllowGroups []string
+	ControlTokenFile   string
+	controlUIDs        map[int]bool
+	controlGIDs        map[int]bool
+
+	DNSListen   *AddrT
+	DNSForward  *AddrT
+	HTTPListen  *AddrT
+	HTTPSListen *AddrT
+	RootCert    string
+	RootKey     string
+	TemplateDir string
+	User        *UserT
+	Chroot      string
+	CacheHosts  int64
+	CacheDNS    int64
+	StateCache  bool
+	Color       bool
+	Verbose     int
 
 	// A list of the various sources; this only contains the hosts defined with
 	// the "host" keyword in the config.
@@ -41,6 +55,25 @@ type ConfigT struct {
 	Regexps       []string
 	Unregexps     []string
 	Surrogates    [][]string
+
+	// Rule files loaded with include-rules; the URL and verification.
+	IncludeRules [][]string
+	included     rulesT
+
+	// Lists disabled with DisableList().
+	disabled map[string]bool
+
+	// Response policy zones; the origin and source.
+	Rpz [][]string
+
+	// Serve the blocklist as a response policy zone with this origin, and
+	// send NOTIFY messages to these secondaries.
+	RpzServe         string
+	RpzNotify        []string
+	RpzAllowTransfer []*net.IPNet
+
+	// Groups of clients that overrides can be made for, by name.
+	ClientGroups map[string][]*net.IPNet
 }
 
 // Config of the application.
@@ -54,208 +87,319 @@ func (c ConfigT) ChrootDir(path string) string {
 // ReadHosts the hosts information in to the various variables (Hosts, Regexps,
 // etc.)
 func (c *ConfigT) ReadHosts() {
-	c.ReadHostsLists()
-
-	Hosts.Add(c.Hosts...)
-	Hosts.Remove(c.Unhosts...)
-	Regexps.Add(c.Regexps...)
-	Regexps.Remove(c.Unregexps...)
-	Surrogates.Add(c.Surrogates...)
+	reloadMu.Lock()
+	msg.Fatal(c.readRules())
+	reloadMu.Unlock()
+	RPZ.Load(c.Rpz...)
 }
 
-// ReadHostsLists reads the hosts lists.
-func (c *ConfigT) ReadHostsLists() {
-	// Try to use cached file
-	if stat, err := os.Stat("/cache/compiled"); err == nil {
-		expires := stat.ModTime().Add(time.Duration(Config.CacheHosts) * time.Second)
-		if expires.Unix() > time.Now().Unix() {
-			c.readHostsCache()
-			return
-		}
+// Read all the rules, except the response policy zones.
+func (c *ConfigT) readRules() error {
+	loadedSources = nil
+	if len(c.disabled) == 0 && c.loadSnapshot() {
+		readAdded()
+		return nil
+	}
+	if err := c.readLists(); err != nil {
+		return err
+	}
+	readAdded()
+	return nil
+}
 
-		msg.Warn(fmt.Errorf("the compiled list has expired, not using it"))
+// Read the rules from the configuration and lists; this doesn't include the
+// rules from AddedPath, which are added last by readAdded().
+func (c *ConfigT) readLists() error {
+	included, err := c.readIncludes()
+	if err != nil {
+		return err
+	}
+	c.included = included
+	if err := c.ReadHostsLists(); err != nil {
+		return err
 	}
 
-	c.loadList(Hosts.Add, c.Hostlists...)
-	c.loadList(Hosts.Remove, c.Unhostlists...)
-	c.loadList(Regexps.Add, c.Regexplists...)
-	c.loadList(Regexps.Remove, c.Unregexplists...)
+	config := Origin{Source: "config"}
+	reading.origins.Set(OriginHost, config, c.Hosts...)
+	reading.origins.Set(OriginUnhost, config, c.Unhosts...)
+	reading.origins.Set(OriginRegexp, config, c.Regexps...)
+	reading.origins.Set(OriginUnregexp, config, c.Unregexps...)
+
+	reading.hosts.Add(c.Hosts...)
+	reading.hosts.Add(c.included.Hosts...)
+	reading.hosts.Remove(c.Unhosts...)
+	reading.hosts.Remove(c.included.Unhosts...)
+	reading.regexps.Add(c.Regexps...)
+	reading.regexps.Add(c.included.Regexps...)
+	reading.regexps.Remove(c.Unregexps...)
+	reading.regexps.Remove(c.included.Unregexps...)
+	reading.surrogates.Add(c.Surrogates...)
+	reading.surrogates.Add(c.included.Surrogates...)
+	return nil
 }
 
-func (c *ConfigT) readHostsCache() {
-	msg.Info("reading compiled list from /cache/compiled", Config.Verbose)
-	fp, err := os.Open("/cache/compiled")
-	msg.Fatal(err)
-	defer func() { _ = fp.Close() }()
-
-	scanner := bufio.NewScanner(fp)
-	for scanner.Scan() {
-		Hosts.Add(scanner.Text())
+// ReadHostsLists reads the hosts lists.
+func (c *ConfigT) ReadHostsLists() error {
+	for _, l := range []struct {
+		kind  string
+		cb    func(...string)
+		lists [][]string
+	}{
+		{OriginHost, reading.hosts.Add, c.Hostlists},
+		{OriginHost, reading.hosts.Add, c.included.Hostlists},
+		{OriginUnhost, reading.hosts.Remove, c.Unhostlists},
+		{OriginRegexp, reading.regexps.Add, c.Regexplists},
+		{OriginUnregexp, reading.regexps.Remove, c.Unregexplists},
+	} {
+		if err := c.loadList(l.kind, l.cb, l.lists...); err != nil {
+			return err
+		}
 	}
+	reading.filters.ApplyBad()
+	return nil
 }
 
-// Load a list and execute cb() on every item we find.
+// Load a list and execute cb() on every item we find; the origin is recorded as
+// kind.
 // TODO: Add option to restrict format (e.g. regexplist hosts ... shouldn't be
 // allowed).
-// TODO: Allow loading remote config files in the trackwall format (which only
-// parses host, hostlist, etc. and *not* dns-listen and such).
-func (c *ConfigT) loadList(cb func(line ...string), lists ...[]string) {
+func (c *ConfigT) loadList(kind string, cb func(line ...string), lists ...[]string) error {
 	for _, list := range lists {
 		format := list[0]
 		url := list[1]
-
-		fp, err := c.loadCachedURL(url)
-		msg.Fatal(err)
-		defer func() { _ = fp.Close() }()
-		scanner := bufio.NewScanner(fp)
-
-		for scanner.Scan() {
-			line := c.readLine(scanner, format)
-			if line != "" {
-				cb(line)
-			}
+		if c.disabled[url] {
+			continue
+		}
+		verify := ""
+		if len(list) > 2 {
+			verify = list[2]
 		}
-	}
-}
-
-func (c *ConfigT) readLine(scanner *bufio.Scanner, format string) string {
-	line := strings.TrimSpace(scanner.Text())
-	if line == "" {
-		return ""
-	}
-
-	switch format {
-	case "plain":
-		// Nothing needed
 
-	// /etc/hosts format
-	case "hosts":
-		if line[0] == '#' {
-			return ""
+		parse, ok := formats[format]
+		if !ok {
+			return fmt.Errorf("unknown format: %v", format)
 		}
 
-		// Remove everything before the first space and after the first #
-		line = strings.Join(strings.Split(line, " ")[1:], " ")
-		line = strings.Split(line, "#")[0]
-		line = strings.TrimSpace(line)
+		fp, err := c.loadCachedURL(url, verify)
+		if err != nil {
+			return err
+		}
 
-		// Some sites also add this to the hosts file they offer.
-		if line == "localhost" || line == "localhost.localdomain" || line == "broadcasthost" || line == "local" {
-			return ""
+		skip := make(skipped)
+		loading = url
+		err = parse(fp,
+			func(rule string, line int) {
+				k, add := kind, cb
+				if len(rule) > 2 && rule[0] == '/' && rule[len(rule)-1] == '/' {
+					k, add = regexpKind(kind)
+					rule = rule[1 : len(rule)-1]
+				}
+				if k == OriginHost || k == OriginRegexp {
+					reading.filters.track(k, rule, format == "adblock")
+				}
+				add(rule)
+				reading.origins.Set(k, Origin{Source: url, Line: line}, rule)
+			},
+			func(reason string) { skip[reason]++ })
+		_ = fp.Close()
+		if err != nil {
+			return fmt.Errorf("%v: %v", url, err)
 		}
 
-	default:
-		msg.Fatal(fmt.Errorf("unknown format: %v", format))
+		if n := skip.total(); n > 0 {
+			msg.Info(fmt.Sprintf("skipped %d lines in %v: %v", n, url, skip), Config.Verbose)
+		}
 	}
+	return nil
+}
 
-	return line
+// Get the kind and callback for the regexps in a list of kind, for formats
+// which have both hosts and regexps.
+func regexpKind(kind string) (string, func(...string)) {
+	if kind == OriginUnhost || kind == OriginUnregexp {
+		return OriginUnregexp, reading.regexps.Remove
+	}
+	return OriginRegexp, reading.regexps.Add
 }
 
-// Load URL with cache.
-func (c *ConfigT) loadCachedURL(url string) (*os.File, error) {
+// Load URL with cache. If verify is set the list is verified (see
+// verifyList()); if downloading or verifying a new version of the list fails
+// then the last good copy from the cache is used.
+func (c *ConfigT) loadCachedURL(url, verify string) (io.ReadCloser, error) {
+	loadedSources = append(loadedSources, url)
+
 	// Load from filesystem
 	if strings.HasPrefix(url, "file://") {
-		return os.Open(url[7:])
+		path := url[7:]
+		if verify != "" {
+			err := verifyFile(path, verify, sigURL(verify, path))
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", url, err)
+			}
+		}
+		return openList(path, url)
 	}
 
-	// TODO: Check error (e.g. perm. denied)
-	err := os.MkdirAll("/cache/hosts", 0755)
-	msg.Fatal(err)
-	cachename := "/cache/hosts/" + regexp.MustCompile(`\W+`).ReplaceAllString(url, "-")
+	if err := os.MkdirAll("/cache/hosts", 0755); err != nil {
+		return nil, err
+	}
+	cachename := cachePath(url)
 
 	stat, err := os.Stat(cachename)
 	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
 
-	// Check if cache expired
-	if stat != nil {
-		expires := stat.ModTime().Add(time.Duration(Config.CacheHosts) * time.Second)
-		if time.Now().Unix() > expires.Unix() {
-			stat = nil
-			err := os.Remove(cachename)
-			if err != nil {
+	// Download if there is no cache or if it expired.
+	if stat == nil || time.Now().After(stat.ModTime().Add(time.Duration(Config.CacheHosts)*time.Second)) {
+		err := download(url, cachename, verify)
+		if err != nil {
+			if stat == nil {
 				return nil, err
 			}
+			msg.Warn(fmt.Errorf("%v; using the last good copy from %v", err,
+				stat.ModTime().Format("2006-01-02 15:04")))
 		}
 	}
 
-	// Download
-	if stat == nil {
-		msg.Info("downloading "+url, Config.Verbose)
-		resp, err := http.Get(url)
+	// Always verify the cache as well, as the verification may have changed
+	// since it was downloaded.
+	if verify != "" {
+		err := verifyFile(cachename, verify, sigURL(verify, cachename))
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%v: cached copy: %v", url, err)
 		}
-		defer func() { _ = resp.Body.Close() }()
+	}
 
-		fp, err := os.Create(cachename)
-		if err != nil {
-			return nil, err
-		}
+	return openList(cachename, url)
+}
+
+// Open the list at path, decompressing it if needed.
+func openList(path, url string) (io.ReadCloser, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := decompress(fp, url)
+	if err != nil {
+		_ = fp.Close()
+		return nil, fmt.Errorf("%v: %v", url, err)
+	}
+	return r, nil
+}
+
+// Get the path of the cached copy of url.
+func cachePath(url string) string {
+	return "/cache/hosts/" + regexp.MustCompile(`\W+`).ReplaceAllString(url, "-")
+}
 
-		data, err := ioutil.ReadAll(resp.Body)
+// Download url (and the signature, if any) to the cache; nothing is written if
+// the verification fails.
+func download(url, cachename, verify string) error {
+	msg.Info("downloading "+url, Config.Verbose)
+	data, err := fetch(url)
+	if err != nil {
+		return err
+	}
+
+	var sig []byte
+	if u := sigURL(verify, url); u != "" {
+		sig, err = fetch(u)
 		if err != nil {
-			return nil, err
+			return err
 		}
+	}
+
+	err = verifyList(verify, data, sig)
+	if err != nil {
+		return fmt.Errorf("%v: %v", url, err)
+	}
 
-		_, err = fp.Write(data)
+	if sig != nil {
+		err = WriteAtomic(sigURL(verify, cachename), sig)
 		if err != nil {
-			return nil, err
+			return err
 		}
+	}
+	return WriteAtomic(cachename, data)
+}
 
-		_ = fp.Close()
+// Get the contents of url.
+//
+// Compressed responses are not decompressed here, so the cache stores the
+// compressed form; a gzip Content-Encoding is detected from the magic bytes
+// when the list is opened.
+func fetch(url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
 	}
+	// Setting this prevents the transport from transparently decompressing.
+	req.Header.Set("Accept-Encoding", "identity")
 
-	return os.Open(cachename)
-}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
 
-// Compile all the sources in one file, saves some memory and makes lookups a
-// bit faster
-func (c *ConfigT) Compile() {
-	newHosts := make(map[string]string)
-
-	Hosts.Lock()
-	defer Hosts.Unlock()
-
-outer:
-	for name := range Hosts.m {
-		labels := strings.Split(name, ".")
-
-		// This catches adding "s8.addthis.com" while "addthis.com" is in the list
-		c := ""
-		l := len(labels)
-		for i := 0; i < l; i++ {
-			if c == "" {
-				c = labels[l-i-1]
-			} else {
-				c = labels[l-i-1] + "." + c
-			}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %v", url, resp.Status)
+	}
+	switch ce := strings.ToLower(resp.Header.Get("Content-Encoding")); ce {
+	case "", "identity", "gzip", "x-gzip":
+	default:
+		return nil, fmt.Errorf("%v: unsupported Content-Encoding: %v", url, ce)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
 
-			_, have := newHosts[c]
-			if have {
-				continue outer
-			}
-		}
+// WriteAtomic writes data to a temporary file and renames it to path, so path
+// always has either the old or the new contents, even after a crash.
+func WriteAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	fp, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fp.Write(data)
+	if err == nil {
+		err = fp.Sync()
+	}
+	if err2 := fp.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
 
-		// This catches adding "addthis.com" while "s7.addthis.com" is in the list;
-		// in which case we want to remove the former.
-		for host := range newHosts {
-			if strings.HasSuffix(host, name) {
-				delete(newHosts, name)
-			}
-		}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
 
-		newHosts[name] = ""
+	// Make sure the rename is on disk as well; not all systems can sync a
+	// directory, so errors are ignored.
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
 	}
+	return nil
+}
 
-	fp, err := os.Create("/cache/compiled")
-	msg.Fatal(err)
-	defer func() { _ = fp.Close() }()
-	for k := range newHosts {
-		_, err = fp.WriteString(fmt.Sprintf("%v\n", k))
-		msg.Fatal(err)
+// Verify the file at path with the signature at sigpath.
+func verifyFile(path, verify, sigpath string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
 	}
 
-	fmt.Printf("Compiled %v hosts to %v entries\n", len(Hosts.m), len(newHosts))
+	var sig []byte
+	if sigpath != "" {
+		sig, err = ioutil.ReadFile(sigpath)
+		if err != nil {
+			return err
+		}
+	}
+	return verifyList(verify, data, sig)
 }
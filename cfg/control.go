@@ -0,0 +1,114 @@
+package cfg
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// The bearer token for control-listen, read from control-token-file. This isn't
+// in the ConfigT so that it's not shown with "status config".
+var controlToken string
+
+// ControlToken gets the bearer token for control-listen, or "" if there is
+// none.
+func (c *ConfigT) ControlToken() string {
+	return controlToken
+}
+
+// ControlAllowed reports if the user uid with the primary group gid may use the
+// control socket. If there is no control-allow-users or control-allow-groups
+// then only root and the user we run as are allowed.
+func (c *ConfigT) ControlAllowed(uid, gid int) bool {
+	if c.controlUIDs == nil && c.controlGIDs == nil {
+		return uid == 0 || (c.User != nil && uid == c.User.UID)
+	}
+	return c.controlUIDs[uid] || c.controlGIDs[gid]
+}
+
+// Allow the user name to use the control socket.
+func (c *ConfigT) allowUser(name string) error {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return err
+	}
+
+	if c.controlUIDs == nil {
+		c.controlUIDs = make(map[int]bool)
+	}
+	c.controlUIDs[uid] = true
+	return nil
+}
+
+// Allow all members of the group name to use the control socket. The members
+// are read from the group file, as the socket only tells us the primary group
+// of the peer.
+func (c *ConfigT) allowGroup(name, groupFile string) error {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return err
+	}
+
+	if c.controlGIDs == nil {
+		c.controlGIDs = make(map[int]bool)
+	}
+	c.controlGIDs[gid] = true
+
+	members, err := groupMembers(groupFile, name)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if err := c.allowUser(m); err != nil {
+			return fmt.Errorf("member of %v: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Get the members of the group name from a group(5) file.
+func groupMembers(path, name string) ([]string, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = fp.Close() }()
+
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		f := strings.Split(scanner.Text(), ":")
+		if len(f) < 4 || f[0] != name {
+			continue
+		}
+		if f[3] == "" {
+			return nil, nil
+		}
+		return strings.Split(f[3], ","), nil
+	}
+	return nil, scanner.Err()
+}
+
+// Read the bearer token from path.
+func readToken(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if len(token) < 16 {
+		return "", fmt.Errorf("%v: the token must be at least 16 characters", path)
+	}
+	return token, nil
+}
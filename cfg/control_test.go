@@ -0,0 +1,64 @@
+package cfg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"arp242.net/trackwall/tt"
+)
+
+func TestControlAllowed(t *testing.T) {
+	c := ConfigT{User: &UserT{UID: 1000, GID: 1000}}
+	tt.Eq(t, "root", true, c.ControlAllowed(0, 0))
+	tt.Eq(t, "user", true, c.ControlAllowed(1000, 100))
+	tt.Eq(t, "other", false, c.ControlAllowed(1001, 1000))
+
+	c.controlUIDs = map[int]bool{1001: true}
+	c.controlGIDs = map[int]bool{50: true}
+	tt.Eq(t, "root", false, c.ControlAllowed(0, 0))
+	tt.Eq(t, "allowed user", true, c.ControlAllowed(1001, 100))
+	tt.Eq(t, "allowed group", true, c.ControlAllowed(1002, 50))
+}
+
+func TestGroupMembers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackwall-group")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "group")
+	tt.Err(t, ioutil.WriteFile(path, []byte("root:x:0:\nwheel:x:10:root,martin\nbad\n"), 0644))
+
+	cases := []struct {
+		in       string
+		expected []string
+	}{
+		{"root", nil},
+		{"wheel", []string{"root", "martin"}},
+		{"nope", nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			out, err := groupMembers(path, tc.in)
+			tt.Err(t, err)
+			tt.Eq(t, "members", tc.expected, out)
+		})
+	}
+}
+
+func TestReadToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackwall-token")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "token")
+
+	tt.Err(t, ioutil.WriteFile(path, []byte("short\n"), 0600))
+	if _, err := readToken(path); err == nil {
+		t.Error("no error for short token")
+	}
+
+	tt.Err(t, ioutil.WriteFile(path, []byte(" 0123456789abcdef\n"), 0600))
+	token, err := readToken(path)
+	tt.Err(t, err)
+	tt.Eq(t, "token", "0123456789abcdef", token)
+}
@@ -0,0 +1,611 @@
+package cfg
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// FilterList are the adblock-style filters that can't be expressed as a plain
+// host or regexp, such as exceptions and rules with modifiers.
+type FilterList struct {
+	sync.RWMutex
+
+	// ||example.com^ filters; the key is the domain, or "" for filters that
+	// match every domain (*$client=...).
+	names map[string][]*Filter
+
+	// /regexp/ filters.
+	regexps []*Filter
+
+	// Rules that were disabled with $badfilter; these are removed once all the
+	// lists are loaded.
+	bad map[string]struct{}
+
+	// Plain hosts and regexps added by the filters, so that $badfilter doesn't
+	// remove them if they were also added by another list. The key is the kind
+	// and rule; the value is true if another list added it as well.
+	plain map[string]bool
+
+	// Number of filters with $client=; the DNS cache only needs to store the
+	// client if there are any.
+	clients int
+}
+
+// Filter is a single adblock-style rule.
+type Filter struct {
+	Text      string         // Original rule text.
+	Name      string         // Domain for ||example.com^ rules.
+	Exact     bool           // Don't match subdomains (|example.com^).
+	Regexp    *regexp.Regexp // For /regexp/ rules.
+	Exception bool           // @@ rule.
+	Important bool           // $important
+	Clients   []*net.IPNet   // $client=
+	NoClients []*net.IPNet   // $client=~..
+	Types     []uint16       // $dnstype=
+	NoTypes   []uint16       // $dnstype=~..
+	DenyAllow []string       // $denyallow=
+	Rewrite   *Rewrite       // $dnsrewrite=
+	Origin    Origin         // List the rule was loaded from.
+}
+
+// Rewrite is the response for a $dnsrewrite= rule.
+type Rewrite struct {
+	Rcode int    // Response code.
+	Type  uint16 // Record type; 0 if there is no answer.
+	Value string // Record value.
+}
+
+var (
+	// Filters are all the loaded adblock filters.
+	Filters FilterList
+)
+
+func init() {
+	Filters = FilterList{}
+	Filters.Purge()
+}
+
+// Len returns the number of filters.
+func (l *FilterList) Len() int {
+	l.RLock()
+	defer l.RUnlock()
+
+	n := len(l.regexps)
+	for _, f := range l.names {
+		n += len(f)
+	}
+	return n
+}
+
+// Add filters.
+func (l *FilterList) Add(filters ...*Filter) {
+	l.Lock()
+	defer l.Unlock()
+
+	for _, f := range filters {
+		if f.Regexp != nil {
+			l.regexps = append(l.regexps, f)
+		} else {
+			l.names[f.Name] = append(l.names[f.Name], f)
+		}
+		if f.forClient() {
+			l.clients++
+		}
+	}
+}
+
+// ForClient reports if any filter depends on the client.
+func (l *FilterList) ForClient() bool {
+	l.RLock()
+	defer l.RUnlock()
+	return l.clients > 0
+}
+
+// Disable the rule text as if it was never added. This is what $badfilter
+// does. It's not applied until ApplyBad() is called.
+func (l *FilterList) Disable(text string) {
+	l.Lock()
+	l.bad[text] = struct{}{}
+	l.Unlock()
+}
+
+// Record that a host or regexp is about to be added by a list; filter is set
+// if it's a plain adblock rule. This must be called before it's added.
+func (l *FilterList) track(kind, rule string, filter bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	k := kind + " " + rule
+	shared, tracked := l.plain[k]
+	switch {
+	case filter && !tracked:
+		var exists bool
+		if kind == OriginRegexp {
+			exists = reading.regexps.Has(rule)
+		} else {
+			_, exists = reading.hosts.Get(rule)
+		}
+		l.plain[k] = exists
+	case !filter && tracked && !shared:
+		l.plain[k] = true
+	}
+}
+
+// ApplyBad removes all the rules disabled with Disable() from the filters, as
+// well as from the Hosts and Regexps for plain rules that weren't also added by
+// another list.
+func (l *FilterList) ApplyBad() {
+	l.Lock()
+	defer l.Unlock()
+
+	for text := range l.bad {
+		f, _, reason := parseFilter(text)
+		if reason != "" {
+			continue
+		}
+
+		if plain, ok := f.plain(); ok {
+			kind, remove := OriginHost, reading.hosts.Remove
+			if f.Regexp != nil {
+				kind, remove = OriginRegexp, reading.regexps.Remove
+			}
+			if shared, ok := l.plain[kind+" "+plain]; ok && !shared {
+				remove(plain)
+			}
+		}
+
+		if f.Regexp != nil {
+			l.regexps = removeFilter(l.regexps, text)
+		} else {
+			l.names[f.Name] = removeFilter(l.names[f.Name], text)
+			if len(l.names[f.Name]) == 0 {
+				delete(l.names, f.Name)
+			}
+		}
+	}
+
+	l.bad = make(map[string]struct{})
+	l.plain = make(map[string]bool)
+
+	l.clients = 0
+	for _, filters := range l.names {
+		for _, f := range filters {
+			if f.forClient() {
+				l.clients++
+			}
+		}
+	}
+	for _, f := range l.regexps {
+		if f.forClient() {
+			l.clients++
+		}
+	}
+}
+
+func removeFilter(filters []*Filter, text string) []*Filter {
+	n := filters[:0]
+	for _, f := range filters {
+		if f.Text != text {
+			n = append(n, f)
+		}
+	}
+	return n
+}
+
+// Match the filters against name for a query of type qtype from client.
+//
+// This returns the filter which decides the response, or nil if no filter
+// applies. If multiple filters match the one with the highest priority is
+// used:
+//
+//	@@..$important  exception
+//	..$important    block or rewrite
+//	@@..            exception
+//	..$dnsrewrite=  rewrite
+//	..              block
+func (l *FilterList) Match(name string, qtype uint16, client net.IP) *Filter {
+	l.RLock()
+	defer l.RUnlock()
+
+	var best *Filter
+	consider := func(f *Filter) {
+		if f.applies(name, qtype, client) && (best == nil || f.priority() > best.priority()) {
+			best = f
+		}
+	}
+
+	for _, f := range l.names[""] {
+		consider(f)
+	}
+
+	labels := strings.Split(name, ".")
+	c := ""
+	n := len(labels)
+	for i := 0; i < n; i++ {
+		if c == "" {
+			c = labels[n-i-1]
+		} else {
+			c = labels[n-i-1] + "." + c
+		}
+
+		for _, f := range l.names[c] {
+			if f.Exact && c != name {
+				continue
+			}
+			consider(f)
+		}
+	}
+
+	for _, f := range l.regexps {
+		if f.Regexp.MatchString(name) {
+			consider(f)
+		}
+	}
+
+	return best
+}
+
+// Dump all filters to the writer.
+func (l *FilterList) Dump(w io.Writer) {
+	l.RLock()
+	defer l.RUnlock()
+
+	for _, filters := range l.names {
+		for _, f := range filters {
+			fmt.Fprintf(w, "%v\n", f.Text)
+		}
+	}
+	for _, f := range l.regexps {
+		fmt.Fprintf(w, "%v\n", f.Text)
+	}
+}
+
+// Purge the entire list
+func (l *FilterList) Purge() {
+	l.Lock()
+	l.names = make(map[string][]*Filter)
+	l.regexps = []*Filter{}
+	l.bad = make(map[string]struct{})
+	l.plain = make(map[string]bool)
+	l.clients = 0
+	l.Unlock()
+}
+
+func (f *Filter) priority() int {
+	p := 0
+	if f.Important {
+		p += 4
+	}
+	if f.Exception {
+		p += 2
+	} else if f.Rewrite != nil {
+		p++
+	}
+	return p
+}
+
+// forClient reports if the filter only applies to some clients.
+func (f *Filter) forClient() bool {
+	return len(f.Clients) > 0 || len(f.NoClients) > 0
+}
+
+// applies reports if the modifiers allow the filter to be used.
+func (f *Filter) applies(name string, qtype uint16, client net.IP) bool {
+	if len(f.Clients) > 0 && !inNets(client, f.Clients) {
+		return false
+	}
+	if inNets(client, f.NoClients) {
+		return false
+	}
+
+	if len(f.Types) > 0 && !inTypes(qtype, f.Types) {
+		return false
+	}
+	if inTypes(qtype, f.NoTypes) {
+		return false
+	}
+
+	for _, d := range f.DenyAllow {
+		if name == d || strings.HasSuffix(name, "."+d) {
+			return false
+		}
+	}
+	return true
+}
+
+// plain gets the host or regexp for filters which can be expressed as a plain
+// host or regexp.
+func (f *Filter) plain() (string, bool) {
+	if f.Exception || f.Important || f.Exact || f.Rewrite != nil ||
+		len(f.Clients) > 0 || len(f.NoClients) > 0 || len(f.Types) > 0 ||
+		len(f.NoTypes) > 0 || len(f.DenyAllow) > 0 {
+		return "", false
+	}
+
+	if f.Regexp != nil {
+		return f.Regexp.String(), true
+	}
+	if f.Name == "" {
+		return "", false
+	}
+	return f.Name, true
+}
+
+func inNets(ip net.IP, nets []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func inTypes(t uint16, types []uint16) bool {
+	for _, typ := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Reasons for skipping a filter.
+const (
+	skipUnsupportedModifier = "unsupported modifier"
+	skipClientName          = "$client with a name"
+	skipBadModifier         = "invalid modifier value"
+	skipBadRegexp           = "unsupported regexp"
+)
+
+// parseFilter parses a single adblock-style filter.
+//
+// This returns the filter, if it was marked with $badfilter, or the reason it
+// was skipped. The Text of the filter never includes $badfilter.
+func parseFilter(line string) (f *Filter, bad bool, reason string) {
+	f = &Filter{}
+	rule := line
+	if strings.HasPrefix(rule, "@@") {
+		f.Exception = true
+		rule = rule[2:]
+	}
+
+	// Split off the modifiers; regexps may contain $ so look for it after the
+	// closing /.
+	var modifiers string
+	start := 0
+	if strings.HasPrefix(rule, "/") {
+		start = strings.LastIndex(rule, "/")
+	}
+	if i := strings.LastIndex(rule[start:], "$"); i > -1 && (start == 0 || i == 1) {
+		modifiers = rule[start+i+1:]
+		rule = rule[:start+i]
+	}
+
+	switch {
+	case len(rule) > 1 && rule[0] == '/' && rule[len(rule)-1] == '/':
+		re, err := regexp.Compile(rule[1 : len(rule)-1])
+		if err != nil {
+			return nil, false, skipBadRegexp
+		}
+		f.Regexp = re
+	case rule == "*" || rule == "":
+		if modifiers == "" {
+			return nil, false, skipInvalid
+		}
+	default:
+		if strings.HasPrefix(rule, "||") {
+			rule = rule[2:]
+		} else if strings.HasPrefix(rule, "|") {
+			f.Exact = true
+			rule = rule[1:]
+		}
+		rule = strings.TrimSuffix(strings.TrimSuffix(rule, "|"), "^")
+		if strings.ContainsAny(rule, "/|") {
+			return nil, false, skipPath
+		}
+		if strings.ContainsAny(rule, "*^") {
+			return nil, false, skipWildcard
+		}
+
+		h, reason := host(rule)
+		if reason != "" {
+			return nil, false, reason
+		}
+		f.Name = h[0]
+	}
+
+	var keep []string
+	for _, m := range strings.Split(modifiers, ",") {
+		if m == "" {
+			continue
+		}
+		if m == "badfilter" {
+			bad = true
+			continue
+		}
+		keep = append(keep, m)
+
+		reason := f.modifier(m)
+		if reason != "" {
+			return nil, false, reason
+		}
+	}
+
+	// $denyallow and $client on * are the only ways to match all domains.
+	if f.Name == "" && f.Regexp == nil && len(f.DenyAllow) == 0 &&
+		len(f.Clients) == 0 && len(f.NoClients) == 0 {
+		return nil, false, skipInvalid
+	}
+
+	f.Text = line[:len(line)-len(modifiers)]
+	if len(keep) > 0 {
+		f.Text += strings.Join(keep, ",")
+	} else {
+		f.Text = strings.TrimSuffix(f.Text, "$")
+	}
+	return f, bad, ""
+}
+
+// Set a single modifier; returns the reason if it can't be used.
+func (f *Filter) modifier(m string) string {
+	var value string
+	if i := strings.Index(m, "="); i > -1 {
+		m, value = m[:i], m[i+1:]
+	}
+
+	switch m {
+	case "important":
+		f.Important = true
+
+	case "client":
+		for _, c := range strings.Split(value, "|") {
+			not := strings.HasPrefix(c, "~")
+			c = strings.TrimPrefix(c, "~")
+			if !strings.Contains(c, "/") {
+				ip := net.ParseIP(c)
+				if ip == nil {
+					return skipClientName
+				}
+				if ip.To4() != nil {
+					c += "/32"
+				} else {
+					c += "/128"
+				}
+			}
+			_, n, err := net.ParseCIDR(c)
+			if err != nil {
+				return skipClientName
+			}
+			if not {
+				f.NoClients = append(f.NoClients, n)
+			} else {
+				f.Clients = append(f.Clients, n)
+			}
+		}
+
+	case "dnstype":
+		for _, t := range strings.Split(value, "|") {
+			not := strings.HasPrefix(t, "~")
+			typ, ok := dns.StringToType[strings.ToUpper(strings.TrimPrefix(t, "~"))]
+			if !ok {
+				return skipBadModifier
+			}
+			if not {
+				f.NoTypes = append(f.NoTypes, typ)
+			} else {
+				f.Types = append(f.Types, typ)
+			}
+		}
+
+	case "denyallow":
+		for _, d := range strings.Split(value, "|") {
+			h, reason := host(d)
+			if reason != "" {
+				return skipBadModifier
+			}
+			f.DenyAllow = append(f.DenyAllow, h[0])
+		}
+
+	case "dnsrewrite":
+		r, ok := parseRewrite(value)
+		if !ok {
+			return skipBadModifier
+		}
+		f.Rewrite = r
+
+	default:
+		return skipUnsupportedModifier
+	}
+	return ""
+}
+
+// parseRewrite parses the $dnsrewrite= value; this is either a response code
+// (NXDOMAIN), an address or hostname (shorthand for A, AAAA, or CNAME), or the
+// full RCODE;TYPE;VALUE form.
+func parseRewrite(v string) (*Rewrite, bool) {
+	if v == "" {
+		return nil, false
+	}
+
+	parts := strings.Split(v, ";")
+	switch len(parts) {
+	case 1:
+		if rcode, ok := dns.StringToRcode[strings.ToUpper(v)]; ok {
+			return &Rewrite{Rcode: rcode}, true
+		}
+		if ip := net.ParseIP(v); ip != nil {
+			if ip.To4() != nil {
+				return &Rewrite{Rcode: dns.RcodeSuccess, Type: dns.TypeA, Value: v}, true
+			}
+			return &Rewrite{Rcode: dns.RcodeSuccess, Type: dns.TypeAAAA, Value: v}, true
+		}
+		h, reason := host(v)
+		if reason != "" {
+			return nil, false
+		}
+		return &Rewrite{Rcode: dns.RcodeSuccess, Type: dns.TypeCNAME, Value: h[0]}, true
+
+	case 3:
+		rcode, ok := dns.StringToRcode[strings.ToUpper(parts[0])]
+		if !ok {
+			return nil, false
+		}
+		r := &Rewrite{Rcode: rcode}
+		if parts[1] == "" {
+			return r, parts[2] == ""
+		}
+
+		r.Type, ok = dns.StringToType[strings.ToUpper(parts[1])]
+		if !ok {
+			return nil, false
+		}
+		r.Value = parts[2]
+
+		switch r.Type {
+		case dns.TypeA:
+			ok = net.ParseIP(r.Value) != nil && net.ParseIP(r.Value).To4() != nil
+		case dns.TypeAAAA:
+			ok = net.ParseIP(r.Value) != nil && net.ParseIP(r.Value).To4() == nil
+		case dns.TypeCNAME:
+			ok = ValidHost(strings.TrimSuffix(strings.ToLower(r.Value), "."))
+		case dns.TypeTXT:
+			ok = true
+		default:
+			ok = false
+		}
+		return r, ok
+	}
+
+	return nil, false
+}
+
+// RR makes a resource record for the answer section, or nil if there is no
+// answer for qtype.
+func (r *Rewrite) RR(name string, qtype uint16) dns.RR {
+	if r.Type == 0 || (r.Type != qtype && r.Type != dns.TypeCNAME) {
+		return nil
+	}
+
+	value := r.Value
+	switch r.Type {
+	case dns.TypeCNAME:
+		value = dns.Fqdn(value)
+	case dns.TypeTXT:
+		value = fmt.Sprintf("%q", value)
+	}
+
+	rr, err := dns.NewRR(fmt.Sprintf("%s 1 IN %s %s", dns.Fqdn(name), dns.TypeToString[r.Type], value))
+	if err != nil {
+		return nil
+	}
+	return rr
+}
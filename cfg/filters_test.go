@@ -0,0 +1,160 @@
+package cfg
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"arp242.net/trackwall/tt"
+	"github.com/miekg/dns"
+)
+
+func TestParseFilter(t *testing.T) {
+	cases := []struct {
+		in             string
+		expectedText   string
+		expectedPlain  string
+		expectedBad    bool
+		expectedReason string
+	}{
+		{"||example.com^", "||example.com^", "example.com", false, ""},
+		{"||example.com^$badfilter", "||example.com^", "example.com", true, ""},
+		{"||example.com^$important,badfilter", "||example.com^$important", "", true, ""},
+		{"@@||example.com^", "@@||example.com^", "", false, ""},
+		{"|example.com^", "|example.com^", "", false, ""},
+		{"/^ads?[0-9]+\\./", "/^ads?[0-9]+\\./", "^ads?[0-9]+\\.", false, ""},
+		{"/ads$/$dnstype=A", "/ads$/$dnstype=A", "", false, ""},
+		{"||example.com^$client=192.168.1.0/24|~192.168.1.2", "||example.com^$client=192.168.1.0/24|~192.168.1.2", "", false, ""},
+		{"*$denyallow=example.com", "*$denyallow=example.com", "", false, ""},
+
+		{"*", "", "", false, skipInvalid},
+		{"/(?!x)/", "", "", false, skipBadRegexp},
+		{"||example.com^$third-party", "", "", false, skipUnsupportedModifier},
+		{"||example.com^$client='laptop'", "", "", false, skipClientName},
+		{"||example.com^$dnstype=NOPE", "", "", false, skipBadModifier},
+		{"||example.com^$dnsrewrite=NOERROR;MX;10 mail", "", "", false, skipBadModifier},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			f, bad, reason := parseFilter(tc.in)
+			tt.Eq(t, "reason", tc.expectedReason, reason)
+			if reason != "" {
+				return
+			}
+			tt.Eq(t, "text", tc.expectedText, f.Text)
+			tt.Eq(t, "bad", tc.expectedBad, bad)
+			plain, _ := f.plain()
+			tt.Eq(t, "plain", tc.expectedPlain, plain)
+		})
+	}
+}
+
+func TestParseRewrite(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected *Rewrite
+	}{
+		{"NXDOMAIN", &Rewrite{Rcode: dns.RcodeNameError}},
+		{"refused", &Rewrite{Rcode: dns.RcodeRefused}},
+		{"1.2.3.4", &Rewrite{Rcode: dns.RcodeSuccess, Type: dns.TypeA, Value: "1.2.3.4"}},
+		{"::1", &Rewrite{Rcode: dns.RcodeSuccess, Type: dns.TypeAAAA, Value: "::1"}},
+		{"example.net", &Rewrite{Rcode: dns.RcodeSuccess, Type: dns.TypeCNAME, Value: "example.net"}},
+		{"NOERROR;A;1.2.3.4", &Rewrite{Rcode: dns.RcodeSuccess, Type: dns.TypeA, Value: "1.2.3.4"}},
+		{"NOERROR;TXT;hello", &Rewrite{Rcode: dns.RcodeSuccess, Type: dns.TypeTXT, Value: "hello"}},
+		{"NOERROR;;", &Rewrite{Rcode: dns.RcodeSuccess}},
+		{"NOERROR;A;::1", nil},
+		{"NOPE;A;1.2.3.4", nil},
+		{"", nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			out, ok := parseRewrite(tc.in)
+			if tc.expected == nil {
+				tt.Eq(t, "ok", false, ok)
+				return
+			}
+			tt.Eq(t, "ok", true, ok)
+			tt.Eq(t, "rewrite", tc.expected, out)
+		})
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	defer Hosts.Purge()
+	defer Regexps.Purge()
+	defer Filters.Purge()
+	defer Origins.Purge()
+
+	list := strings.Join([]string{
+		"||ads.example.com^",
+		"||tracker.example.com^",
+		"||tracker.example.com^$badfilter",
+		"||disabled.example.com^",
+		"||disabled.example.com^$badfilter",
+		"@@||ok.ads.example.com^",
+		"||important.example.com^$important",
+		"@@||important.example.com^",
+		"||client.example.com^$client=10.0.0.0/8",
+		"||mx.example.com^$dnstype=MX",
+		"||rewrite.example.com^$dnsrewrite=1.2.3.4",
+		"*$denyallow=example.com|example.org,client=192.168.1.66",
+		"@@/^allowed[0-9]/",
+	}, "\n")
+
+	dir, err := ioutil.TempDir("", "trackwall-filters")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	tt.Err(t, ioutil.WriteFile(filepath.Join(dir, "adblock"), []byte(list), 0644))
+	tt.Err(t, ioutil.WriteFile(filepath.Join(dir, "hosts"), []byte("disabled.example.com\n"), 0644))
+
+	c := ConfigT{Hostlists: [][]string{
+		{"adblock", "file://" + filepath.Join(dir, "adblock")},
+		{"plain", "file://" + filepath.Join(dir, "hosts")},
+	}}
+	tt.Err(t, c.Reload())
+
+	_, has := Hosts.Get("ads.example.com")
+	tt.Eq(t, "plain host", true, has)
+	_, has = Hosts.Get("tracker.example.com")
+	tt.Eq(t, "badfilter", false, has)
+	_, has = Hosts.Get("disabled.example.com")
+	tt.Eq(t, "badfilter for host in other list", true, has)
+	tt.Eq(t, "for client", true, Filters.ForClient())
+
+	local := net.ParseIP("127.0.0.1")
+	cases := []struct {
+		name     string
+		qtype    uint16
+		client   net.IP
+		expected string
+	}{
+		{"ads.example.com", dns.TypeA, local, ""},
+		{"ok.ads.example.com", dns.TypeA, local, "@@||ok.ads.example.com^"},
+		{"x.ok.ads.example.com", dns.TypeA, local, "@@||ok.ads.example.com^"},
+		{"important.example.com", dns.TypeA, local, "||important.example.com^$important"},
+		{"client.example.com", dns.TypeA, local, ""},
+		{"client.example.com", dns.TypeA, net.ParseIP("10.1.2.3"), "||client.example.com^$client=10.0.0.0/8"},
+		{"mx.example.com", dns.TypeA, local, ""},
+		{"mx.example.com", dns.TypeMX, local, "||mx.example.com^$dnstype=MX"},
+		{"rewrite.example.com", dns.TypeA, local, "||rewrite.example.com^$dnsrewrite=1.2.3.4"},
+		{"example.net", dns.TypeA, net.ParseIP("192.168.1.66"), "*$denyallow=example.com|example.org,client=192.168.1.66"},
+		{"www.example.org", dns.TypeA, net.ParseIP("192.168.1.66"), ""},
+		{"allowed1.example.com", dns.TypeA, local, "@@/^allowed[0-9]/"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := Filters.Match(tc.name, tc.qtype, tc.client)
+			out := ""
+			if f != nil {
+				out = f.Text
+			}
+			tt.Eq(t, "filter", tc.expected, out)
+		})
+	}
+}
@@ -0,0 +1,407 @@
+package cfg
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+// FormatFunc parses a hostlist read from fp. It calls add() for every host it
+// finds (with the line number, or 0 if the format has no lines) and skip() for
+// every line that can't be expressed as a DNS rule.
+//
+// Formats which can also have regexps pass them to add() as "/regexp/".
+type FormatFunc func(fp io.Reader, add func(host string, line int), skip func(reason string)) error
+
+// formats are all the registered hostlist formats; the key is the name as used
+// in the config file.
+var formats = make(map[string]FormatFunc)
+
+// RegisterFormat sets the parser for a hostlist format. Existing formats are
+// always overridden.
+func RegisterFormat(name string, f FormatFunc) {
+	formats[name] = f
+}
+
+func init() {
+	RegisterFormat("plain", lineFormat(parsePlain))
+	RegisterFormat("hosts", parseHosts)
+	RegisterFormat("adblock", parseAdblock)
+	RegisterFormat("dnsmasq", lineFormat(parseDnsmasq))
+	RegisterFormat("unbound", lineFormat(parseUnbound))
+	RegisterFormat("wildcard", lineFormat(parseWildcard))
+	RegisterFormat("json", parseJSON)
+}
+
+// lineFormat makes a FormatFunc from a function which parses a single line.
+//
+// The line is passed with leading and trailing whitespace removed, and empty
+// lines are never passed. The function returns either the hosts on the line, or
+// the reason it was skipped. Returning neither means the line is silently
+// ignored (e.g. for comments).
+func lineFormat(parse func(line string) (hosts []string, reason string)) FormatFunc {
+	return func(fp io.Reader, add func(string, int), skip func(string)) error {
+		scanner := bufio.NewScanner(fp)
+		n := 0
+		for scanner.Scan() {
+			n++
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+
+			hosts, reason := parse(line)
+			if reason != "" {
+				skip(reason)
+				continue
+			}
+			for _, h := range hosts {
+				add(h, n)
+			}
+		}
+		return scanner.Err()
+	}
+}
+
+// skipped counts the number of skipped lines (or names) for every reason.
+type skipped map[string]int
+
+func (s skipped) total() (n int) {
+	for _, v := range s {
+		n += v
+	}
+	return n
+}
+
+// String lists all reasons, most common first.
+func (s skipped) String() string {
+	reasons := make([]string, 0, len(s))
+	for r := range s {
+		reasons = append(reasons, r)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if s[reasons[i]] == s[reasons[j]] {
+			return reasons[i] < reasons[j]
+		}
+		return s[reasons[i]] > s[reasons[j]]
+	})
+
+	out := make([]string, len(reasons))
+	for i, r := range reasons {
+		out[i] = fmt.Sprintf("%d %s", s[r], r)
+	}
+	return strings.Join(out, ", ")
+}
+
+// Reasons for skipping a line.
+const (
+	skipInvalid   = "not a valid hostname"
+	skipLocalhost = "localhost entry"
+	skipRedirect  = "redirects to an address"
+	skipNotBlock  = "not a blocking rule"
+	skipUnknown   = "unknown syntax"
+	skipCosmetic  = "cosmetic rule"
+	skipPath      = "rule with a path"
+	skipWildcard  = "wildcard not at the start"
+	skipNoDomain  = "no domain field"
+)
+
+// ValidHost reports if h looks like a hostname we can block. IP addresses are
+// not valid hostnames.
+func ValidHost(h string) bool {
+	if len(h) == 0 || len(h) > 253 || net.ParseIP(h) != nil {
+		return false
+	}
+
+	for _, label := range strings.Split(h, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// nullAddr reports if addr is one of the addresses that blocklists commonly use
+// to mean "doesn't exist".
+func nullAddr(addr string) bool {
+	switch addr {
+	case "", "0.0.0.0", "127.0.0.1", "::", "::1", "#":
+		return true
+	}
+	return false
+}
+
+// host normalizes h and checks if it's valid.
+func host(h string) ([]string, string) {
+	h = strings.TrimSuffix(strings.ToLower(h), ".")
+	if !ValidHost(h) {
+		return nil, skipInvalid
+	}
+	return []string{h}, ""
+}
+
+// One host per line. No comments.
+func parsePlain(line string) ([]string, string) {
+	return []string{line}, ""
+}
+
+// /etc/hosts format; comments and the destination address are ignored.
+//
+// Invalid names on a line with several hosts are skipped on their own, rather
+// than skipping the entire line.
+func parseHosts(fp io.Reader, add func(string, int), skip func(string)) error {
+	return lineFormat(func(line string) ([]string, string) {
+		return parseHostsLine(line, skip)
+	})(fp, add, skip)
+}
+
+func parseHostsLine(line string, skip func(string)) ([]string, string) {
+	if line[0] == '#' {
+		return nil, ""
+	}
+
+	fields := strings.Fields(strings.Split(line, "#")[0])
+	if len(fields) < 2 {
+		return nil, skipInvalid
+	}
+	if !nullAddr(fields[0]) {
+		return nil, skipRedirect
+	}
+
+	var (
+		hosts   []string
+		invalid bool
+	)
+	for _, f := range fields[1:] {
+		// Some sites also add this to the hosts file they offer.
+		switch f {
+		case "localhost", "localhost.localdomain", "broadcasthost", "local":
+			continue
+		}
+
+		h, reason := host(f)
+		if reason != "" {
+			skip(reason)
+			invalid = true
+			continue
+		}
+		hosts = append(hosts, h...)
+	}
+
+	if len(hosts) == 0 && !invalid {
+		return nil, skipLocalhost
+	}
+	return hosts, ""
+}
+
+// Adblock-style filters. Plain ||example.com^ and /regexp/ rules are passed to
+// add(); everything else (exceptions, rules with modifiers) is added to the
+// filters that are being read.
+//
+// Rules that can't be applied to DNS (cosmetic rules, paths, etc.) are skipped.
+func parseAdblock(fp io.Reader, add func(string, int), skip func(string)) error {
+	scanner := bufio.NewScanner(fp)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.Contains(line, "##") || strings.Contains(line, "#@#") ||
+			strings.Contains(line, "#?#") || strings.Contains(line, "#$#"):
+			skip(skipCosmetic)
+			continue
+		case line == "" || line[0] == '!' || line[0] == '#' || line[0] == '[':
+			continue
+		}
+
+		f, bad, reason := parseFilter(line)
+		if reason != "" {
+			skip(reason)
+			continue
+		}
+
+		f.Origin = Origin{Source: loading, Line: n}
+		switch plain, ok := f.plain(); {
+		case bad:
+			reading.filters.Disable(f.Text)
+		case ok && f.Regexp != nil:
+			add("/"+plain+"/", n)
+		case ok:
+			add(plain, n)
+		default:
+			reading.filters.Add(f)
+		}
+	}
+	return scanner.Err()
+}
+
+// dnsmasq: address=/example.com/0.0.0.0 or server=/example.com/
+func parseDnsmasq(line string) ([]string, string) {
+	if line[0] == '#' {
+		return nil, ""
+	}
+
+	var key, value string
+	if i := strings.Index(line, "="); i > -1 {
+		key, value = line[:i], line[i+1:]
+	}
+
+	switch key {
+	case "address", "server", "local":
+	default:
+		return nil, skipUnknown
+	}
+
+	// /domain1/domain2/addr
+	parts := strings.Split(value, "/")
+	if len(parts) < 3 || parts[0] != "" {
+		return nil, skipUnknown
+	}
+
+	addr := parts[len(parts)-1]
+	switch {
+	case key == "address" && !nullAddr(addr):
+		return nil, skipRedirect
+	case key == "server" && addr != "":
+		return nil, skipNotBlock
+	}
+
+	var hosts []string
+	for _, d := range parts[1 : len(parts)-1] {
+		if d == "#" {
+			return nil, skipInvalid
+		}
+		h, reason := host(d)
+		if reason != "" {
+			return nil, reason
+		}
+		hosts = append(hosts, h...)
+	}
+	return hosts, ""
+}
+
+// unbound: local-zone: "example.com" always_nxdomain or
+// local-data: "example.com A 0.0.0.0"
+func parseUnbound(line string) ([]string, string) {
+	if line[0] == '#' || line == "server:" {
+		return nil, ""
+	}
+
+	i := strings.Index(line, ":")
+	if i == -1 {
+		return nil, skipUnknown
+	}
+	key := line[:i]
+	value := strings.Fields(strings.Replace(strings.Split(line[i+1:], "#")[0], `"`, " ", -1))
+
+	switch key {
+	case "local-zone":
+		if len(value) != 2 {
+			return nil, skipUnknown
+		}
+		switch value[1] {
+		case "deny", "refuse", "static", "redirect", "inform_deny",
+			"always_refuse", "always_nxdomain", "always_null":
+			return host(value[0])
+		}
+		return nil, skipNotBlock
+
+	case "local-data":
+		// name [ttl] [class] type address
+		if len(value) < 3 {
+			return nil, skipUnknown
+		}
+		typ := strings.ToUpper(value[len(value)-2])
+		if typ != "A" && typ != "AAAA" {
+			return nil, skipNotBlock
+		}
+		if !nullAddr(value[len(value)-1]) {
+			return nil, skipRedirect
+		}
+		return host(value[0])
+	}
+
+	return nil, skipNotBlock
+}
+
+// Wildcard lists: *.example.com
+//
+// Since we always block subdomains the wildcard is merely removed; note this
+// means that example.com itself is also blocked.
+func parseWildcard(line string) ([]string, string) {
+	if line[0] == '#' || line[0] == '!' {
+		return nil, ""
+	}
+
+	line = strings.TrimPrefix(line, "*.")
+	if strings.Contains(line, "*") {
+		return nil, skipWildcard
+	}
+	return host(line)
+}
+
+// JSON lists; accepted are an array of strings or objects, or an object with a
+// "domains" or "hosts" key containing such an array:
+//
+//	["example.com", "example.net"]
+//	[{"domain": "example.com"}]
+//	{"domains": ["example.com"]}
+func parseJSON(fp io.Reader, add func(string, int), skip func(string)) error {
+	var data interface{}
+	err := json.NewDecoder(fp).Decode(&data)
+	if err != nil {
+		return fmt.Errorf("cannot parse JSON: %v", err)
+	}
+
+	if obj, ok := data.(map[string]interface{}); ok {
+		data = obj["domains"]
+		if data == nil {
+			data = obj["hosts"]
+		}
+	}
+
+	list, ok := data.([]interface{})
+	if !ok {
+		return fmt.Errorf("cannot parse JSON: not an array or an object with a domains or hosts key")
+	}
+
+	for _, entry := range list {
+		if obj, ok := entry.(map[string]interface{}); ok {
+			entry = nil
+			for _, k := range []string{"domain", "host", "hostname", "name"} {
+				if v, has := obj[k]; has {
+					entry = v
+					break
+				}
+			}
+		}
+
+		s, ok := entry.(string)
+		if !ok {
+			skip(skipNoDomain)
+			continue
+		}
+
+		hosts, reason := host(s)
+		if reason != "" {
+			skip(reason)
+			continue
+		}
+		for _, h := range hosts {
+			add(h, 0)
+		}
+	}
+
+	return nil
+}
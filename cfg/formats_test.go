@@ -0,0 +1,91 @@
+package cfg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"arp242.net/trackwall/tt"
+)
+
+func TestFormats(t *testing.T) {
+	cases := []struct {
+		format       string
+		in           string
+		expected     []string
+		expectedSkip skipped
+	}{
+		{"plain", "example.com\n\n  example.net  \n", []string{"example.com", "example.net"}, skipped{}},
+
+		{"hosts", "# Comment\n127.0.0.1 localhost\n0.0.0.0\tads.example.com # ads\n",
+			[]string{"ads.example.com"}, skipped{skipLocalhost: 1}},
+		{"hosts", "0.0.0.0 a.example.com b.example.com\n", []string{"a.example.com", "b.example.com"}, skipped{}},
+		{"hosts", "192.168.1.1 router.example.com\n0.0.0.0 not_valid!\n", nil,
+			skipped{skipRedirect: 1, skipInvalid: 1}},
+		{"hosts", "0.0.0.0 a.example.com not_valid! b.example.com also_not!\n",
+			[]string{"a.example.com", "b.example.com"}, skipped{skipInvalid: 2}},
+
+		{"adblock", "! Title\n[Adblock Plus 2.0]\n||ads.example.com^\n||Track.Example.com^|\nexample.org\n",
+			[]string{"ads.example.com", "track.example.com", "example.org"}, skipped{}},
+		{"adblock", "example.com##.ad\n||example.com^$third-party\n||example.com/ads\n||ads.*.example.com^\n",
+			nil, skipped{skipCosmetic: 1, skipUnsupportedModifier: 1, skipPath: 1, skipWildcard: 1}},
+
+		{"dnsmasq", "# Comment\naddress=/ads.example.com/0.0.0.0\naddress=/a.example.com/b.example.com/\nserver=/c.example.com/\nlocal=/d.example.com/\n",
+			[]string{"ads.example.com", "a.example.com", "b.example.com", "c.example.com", "d.example.com"}, skipped{}},
+		{"dnsmasq", "address=/example.com/10.0.0.1\nserver=/example.com/8.8.8.8\naddress=/#/\nno-resolv\n",
+			nil, skipped{skipRedirect: 1, skipNotBlock: 1, skipInvalid: 1, skipUnknown: 1}},
+
+		{"unbound", "server:\n# Comment\nlocal-zone: \"ads.example.com\" always_nxdomain\nlocal-data: \"a.example.com. A 0.0.0.0\"\nlocal-data: \"b.example.com 3600 IN AAAA ::\"\n",
+			[]string{"ads.example.com", "a.example.com", "b.example.com"}, skipped{}},
+		{"unbound", "local-zone: \"example.com\" transparent\nlocal-data: \"example.com A 10.0.0.1\"\nlocal-data: \"example.com MX 10 mail.example.com\"\nlocal-zone: \"example.com\"\n",
+			nil, skipped{skipNotBlock: 2, skipRedirect: 1, skipUnknown: 1}},
+
+		{"wildcard", "# Comment\n*.ads.example.com\nexample.net\nads.*.example.com\n",
+			[]string{"ads.example.com", "example.net"}, skipped{skipWildcard: 1}},
+
+		{"json", `["ads.example.com", "example.net", "not valid", 1]`,
+			[]string{"ads.example.com", "example.net"}, skipped{skipInvalid: 1, skipNoDomain: 1}},
+		{"json", `{"domains": [{"domain": "ads.example.com"}, {"host": "example.net"}, {"x": "y"}]}`,
+			[]string{"ads.example.com", "example.net"}, skipped{skipNoDomain: 1}},
+		{"json", `{"hosts": ["ads.example.com"]}`, []string{"ads.example.com"}, skipped{}},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%v-%v", tc.format, i), func(t *testing.T) {
+			var out []string
+			skip := make(skipped)
+			err := formats[tc.format](strings.NewReader(tc.in),
+				func(h string, _ int) { out = append(out, h) },
+				func(r string) { skip[r]++ })
+			tt.Err(t, err)
+			tt.Eq(t, "hosts", tc.expected, out)
+			tt.Eq(t, "skipped", tc.expectedSkip, skip)
+		})
+	}
+}
+
+func TestFormatsJSONError(t *testing.T) {
+	for _, in := range []string{`{`, `"example.com"`, `{"x": []}`} {
+		t.Run(in, func(t *testing.T) {
+			err := parseJSON(strings.NewReader(in), func(string, int) {}, func(string) {})
+			if err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestFormatsLine(t *testing.T) {
+	var lines []int
+	err := formats["hosts"](strings.NewReader("# Comment\n127.0.0.1 a.example.com\n\n0.0.0.0 b.example.com c.example.com\n"),
+		func(_ string, line int) { lines = append(lines, line) },
+		func(string) {})
+	tt.Err(t, err)
+	tt.Eq(t, "lines", []int{2, 4, 4}, lines)
+}
+
+func TestSkipped(t *testing.T) {
+	s := skipped{"b": 1, "a": 1, "c": 3}
+	tt.Eq(t, "total", 5, s.total())
+	tt.Eq(t, "string", "3 c, 1 a, 1 b", s.String())
+}
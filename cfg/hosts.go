@@ -3,6 +3,7 @@ package cfg
 import (
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -11,7 +12,8 @@ import (
 // hostname, the (optional) value is a surrogate script to serve.
 type HostList struct {
 	sync.RWMutex
-	m map[string]string
+	m   map[string]string
+	gen uint64
 }
 
 var (
@@ -36,6 +38,7 @@ func (l *HostList) Get(k string) (string, bool) {
 func (l *HostList) Add(hosts ...string) {
 	l.Lock()
 	defer l.Unlock()
+	l.gen++
 
 	for _, host := range hosts {
 		if strings.HasPrefix(host, "www.") {
@@ -44,7 +47,7 @@ func (l *HostList) Add(hosts ...string) {
 
 		// We already got this
 		if _, has := l.m[host]; has {
-			return
+			continue
 		}
 		l.m[host] = ""
 	}
@@ -61,6 +64,7 @@ func (l *HostList) SetScript(host, script string) {
 func (l *HostList) Remove(hosts ...string) {
 	l.Lock()
 	defer l.Unlock()
+	l.gen++
 	for _, host := range hosts {
 		delete(l.m, host)
 	}
@@ -73,6 +77,28 @@ func (l *HostList) Len() int {
 	return len(l.m)
 }
 
+// Generation is incremented on every change to the list.
+func (l *HostList) Generation() uint64 {
+	l.RLock()
+	defer l.RUnlock()
+	return l.gen
+}
+
+// List all hosts containing search, sorted.
+func (l *HostList) List(search string) []string {
+	l.RLock()
+	hosts := make([]string, 0, len(l.m))
+	for k := range l.m {
+		if search == "" || strings.Contains(k, search) {
+			hosts = append(hosts, k)
+		}
+	}
+	l.RUnlock()
+
+	sort.Strings(hosts)
+	return hosts
+}
+
 // Dump all keys to the writer.
 func (l *HostList) Dump(w io.Writer) {
 	l.RLock()
@@ -91,5 +117,6 @@ func (l *HostList) Dump(w io.Writer) {
 func (l *HostList) Purge() {
 	l.Lock()
 	l.m = make(map[string]string)
+	l.gen++
 	l.Unlock()
 }
@@ -0,0 +1,23 @@
+package cfg
+
+import (
+	"testing"
+
+	"arp242.net/trackwall/tt"
+)
+
+func TestHostListAdd(t *testing.T) {
+	defer Hosts.Purge()
+
+	Hosts.Purge()
+	Hosts.Add("a.example.com")
+	Hosts.SetScript("a.example.com", "var x;")
+
+	// A host that's already in the list is skipped, but the rest is still
+	// added, and the surrogate script is kept.
+	Hosts.Add("a.example.com", "b.example.com", "www.c.example.com")
+	tt.Eq(t, "len", 3, Hosts.Len())
+	tt.Eq(t, "hosts", []string{"a.example.com", "b.example.com", "c.example.com"}, Hosts.List(""))
+	script, _ := Hosts.Get("a.example.com")
+	tt.Eq(t, "script", "var x;", script)
+}
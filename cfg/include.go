@@ -0,0 +1,119 @@
+package cfg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"arp242.net/sconfig"
+)
+
+// rulesT are the options that can be used in files loaded with include-rules;
+// anything else (such as dns-listen or user) is an error.
+type rulesT struct {
+	Hosts      []string
+	Unhosts    []string
+	Regexps    []string
+	Unregexps  []string
+	Surrogates [][]string
+	Hostlists  [][]string
+}
+
+// add the rules from r.
+func (rules *rulesT) add(r rulesT) {
+	rules.Hosts = append(rules.Hosts, r.Hosts...)
+	rules.Unhosts = append(rules.Unhosts, r.Unhosts...)
+	rules.Regexps = append(rules.Regexps, r.Regexps...)
+	rules.Unregexps = append(rules.Unregexps, r.Unregexps...)
+	rules.Surrogates = append(rules.Surrogates, r.Surrogates...)
+	rules.Hostlists = append(rules.Hostlists, r.Hostlists...)
+}
+
+// Load all the include-rules files.
+func (c *ConfigT) readIncludes() (rulesT, error) {
+	var all rulesT
+	for _, inc := range c.IncludeRules {
+		url := inc[0]
+		verify := ""
+		if len(inc) > 1 {
+			verify = inc[1]
+		}
+
+		fp, err := c.loadCachedURL(url, verify)
+		if err != nil {
+			return all, err
+		}
+
+		rules, err := parseRules(fp, "/cache", !strings.HasPrefix(url, "file://"))
+		_ = fp.Close()
+		if err != nil {
+			return all, fmt.Errorf("%v: %v", url, err)
+		}
+		all.add(rules)
+
+		o := Origin{Source: url}
+		reading.origins.Set(OriginHost, o, rules.Hosts...)
+		reading.origins.Set(OriginUnhost, o, rules.Unhosts...)
+		reading.origins.Set(OriginRegexp, o, rules.Regexps...)
+		reading.origins.Set(OriginUnregexp, o, rules.Unregexps...)
+	}
+	return all, nil
+}
+
+// Parse the rules in the config syntax from r. Rules from a remote file can't
+// load lists from local files.
+//
+// sconfig can only read files, so it's written to a temporary file in tmpdir
+// first.
+func parseRules(r io.Reader, tmpdir string, remote bool) (rulesT, error) {
+	var rules rulesT
+
+	tmp, err := ioutil.TempFile(tmpdir, "include-rules")
+	if err != nil {
+		return rules, err
+	}
+	defer os.Remove(tmp.Name()) // nolint: errcheck
+
+	// Don't allow reading local files.
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if f := strings.Fields(line); len(f) > 0 && f[0] == "source" {
+			_ = tmp.Close()
+			return rules, fmt.Errorf("source is not allowed in include-rules")
+		}
+		_, err = fmt.Fprintln(tmp, line)
+		if err != nil {
+			_ = tmp.Close()
+			return rules, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		_ = tmp.Close()
+		return rules, err
+	}
+	if err := tmp.Close(); err != nil {
+		return rules, err
+	}
+
+	err = sconfig.Parse(&rules, tmp.Name(), sconfig.Handlers{
+		"Hostlists": func(l []string) error {
+			lists, err := listArgs(l)
+			for _, list := range lists {
+				if remote && strings.HasPrefix(strings.ToLower(list[1]), "file://") {
+					return fmt.Errorf("file:// is not allowed in remote include-rules: %v", list[1])
+				}
+			}
+			rules.Hostlists = append(rules.Hostlists, lists...)
+			return err
+		},
+		"Surrogates": func(l []string) error {
+			rules.Surrogates = append(rules.Surrogates, []string{l[0], strings.Join(l[1:], " ")})
+			return nil
+		},
+	})
+	return rules, err
+}
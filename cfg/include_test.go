@@ -0,0 +1,72 @@
+package cfg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"arp242.net/trackwall/tt"
+)
+
+func TestParseRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackwall-include")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	rules, err := parseRules(strings.NewReader(`
+# Comment
+host example.com
+	example.net
+unhost ok.example.com
+regexp ^ads?\.
+unregexp ^ad\.
+surrogate ^example\.com$ var x = @@
+hostlist hosts http://example.com/hosts
+`), dir, true)
+	tt.Err(t, err)
+	tt.Eq(t, "rules", rulesT{
+		Hosts:      []string{"example.com", "example.net"},
+		Unhosts:    []string{"ok.example.com"},
+		Regexps:    []string{`^ads?.`}, // sconfig removes the \
+		Unregexps:  []string{`^ad.`},
+		Surrogates: [][]string{{`^example.com$`, "var x = @@"}},
+		Hostlists:  [][]string{{"hosts", "http://example.com/hosts"}},
+	}, rules)
+
+	// A local file with valid rules, so that it's not an error to read it.
+	local := filepath.Join(dir, "local")
+	tt.Err(t, ioutil.WriteFile(local, []byte("host local.example.com\n"), 0600))
+	defer os.Remove(local) // nolint: errcheck
+
+	for _, in := range []string{
+		"dns-listen 0.0.0.0:53",
+		"user root",
+		"include-rules http://example.com/rules",
+		"source /etc/passwd",
+		"source\t" + local,
+		"source  " + local,
+		" source " + local,
+		"hostlist hosts file:///etc/hosts",
+		"hostlist hosts FILE:///etc/hosts",
+		"hostlist nope http://example.com/hosts",
+	} {
+		t.Run(in, func(t *testing.T) {
+			_, err := parseRules(strings.NewReader("host example.com\n"+in+"\n"), dir, true)
+			if err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+
+	// Local files can be used from local includes.
+	rules, err = parseRules(strings.NewReader("hostlist hosts file:///etc/hosts\n"), dir, false)
+	tt.Err(t, err)
+	tt.Eq(t, "hostlists", [][]string{{"hosts", "file:///etc/hosts"}}, rules.Hostlists)
+
+	// Temporary files are removed.
+	files, err := ioutil.ReadDir(dir)
+	tt.Err(t, err)
+	tt.Eq(t, "files", 1, len(files))
+}
@@ -0,0 +1,227 @@
+package cfg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ListInfo is information about a hostlist, unhostlist, regexplist, or
+// unregexplist.
+type ListInfo struct {
+	Kind     string    `json:"kind"`
+	Format   string    `json:"format"`
+	URL      string    `json:"url"`
+	Verify   string    `json:"verify,omitempty"`
+	Enabled  bool      `json:"enabled"`
+	Modified time.Time `json:"modified"` // Of the local file or cached copy.
+	Size     int64     `json:"size"`
+}
+
+// ErrUnknownList is used if a list isn't in the configuration.
+var ErrUnknownList = errors.New("no such list")
+
+var reloadMu sync.Mutex
+
+// ruleSet are the lists that the rules are read in to.
+type ruleSet struct {
+	hosts      *HostList
+	regexps    *RegexpList
+	filters    *FilterList
+	surrogates *SurrogateList
+	origins    *OriginList
+}
+
+var (
+	// The live lists that are used to answer queries.
+	live = ruleSet{&Hosts, &Regexps, &Filters, &Surrogates, &Origins}
+
+	// The lists that readRules() adds to: the live lists when starting, or
+	// new lists while reloading.
+	reading = live
+)
+
+// Make a new set of empty lists.
+func newRuleSet() ruleSet {
+	s := ruleSet{&HostList{}, &RegexpList{}, &FilterList{}, &SurrogateList{}, &OriginList{}}
+	s.hosts.Purge()
+	s.regexps.Purge()
+	s.filters.Purge()
+	s.surrogates.Purge()
+	s.origins.Purge()
+	return s
+}
+
+// Replace the live lists with the ones from s.
+func (s ruleSet) swap() {
+	Hosts.Lock()
+	Hosts.m = s.hosts.m
+	Hosts.gen++
+	Hosts.Unlock()
+
+	Regexps.Lock()
+	Regexps.l = s.regexps.l
+	Regexps.gen++
+	Regexps.Unlock()
+
+	Filters.Lock()
+	Filters.names, Filters.regexps = s.filters.names, s.filters.regexps
+	Filters.bad, Filters.plain, Filters.clients = s.filters.bad, s.filters.plain, s.filters.clients
+	Filters.Unlock()
+
+	Surrogates.Lock()
+	Surrogates.l = s.surrogates.l
+	Surrogates.Unlock()
+
+	Origins.Lock()
+	Origins.m = s.origins.m
+	Origins.Unlock()
+}
+
+// Reload all the rules from the lists and the configuration. The response
+// policy zones aren't reloaded, as they're refreshed on their own.
+//
+// The live rules aren't changed if there's an error.
+func (c *ConfigT) Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	return c.reload()
+}
+
+// Read the rules in to new lists, and replace the live lists with them once
+// everything is read, so queries never see a partly loaded set of rules.
+func (c *ConfigT) reload() error {
+	included, sources := c.included, loadedSources
+	reading = newRuleSet()
+	defer func() { reading = live }()
+
+	if err := c.readRules(); err != nil {
+		c.included, loadedSources = included, sources
+		return err
+	}
+	reading.swap()
+	return nil
+}
+
+// Lists gets information about all the lists.
+func (c *ConfigT) Lists() []ListInfo {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	var lists []ListInfo
+	for _, l := range c.allLists() {
+		info := ListInfo{Kind: l.kind, Format: l.list[0], URL: l.list[1], Enabled: !c.disabled[l.list[1]]}
+		if len(l.list) > 2 {
+			info.Verify = l.list[2]
+		}
+		if stat, err := os.Stat(sourcePath(info.URL)); err == nil {
+			info.Modified = stat.ModTime()
+			info.Size = stat.Size()
+		}
+		lists = append(lists, info)
+	}
+	return lists
+}
+
+// RefreshLists downloads a new copy of the list with url, or all remote lists
+// if url is "", and reloads the rules.
+func (c *ConfigT) RefreshLists(url string) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	found := false
+	var errs []string
+	for _, l := range c.allLists() {
+		if url != "" && l.list[1] != url {
+			continue
+		}
+		found = true
+		if strings.HasPrefix(l.list[1], "file://") || c.disabled[l.list[1]] {
+			continue
+		}
+
+		verify := ""
+		if len(l.list) > 2 {
+			verify = l.list[2]
+		}
+		if err := download(l.list[1], cachePath(l.list[1]), verify); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if !found {
+		return ErrUnknownList
+	}
+
+	if err := c.reload(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to refresh: %v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// DisableList disables or enables the list with url, and reloads the rules.
+// This isn't saved, and all lists are enabled again after a restart.
+func (c *ConfigT) DisableList(url string, disable bool) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	found := false
+	for _, l := range c.allLists() {
+		if l.list[1] == url {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrUnknownList
+	}
+
+	if c.disabled == nil {
+		c.disabled = make(map[string]bool)
+	}
+	wasDisabled := c.disabled[url]
+	if disable {
+		c.disabled[url] = true
+	} else {
+		delete(c.disabled, url)
+	}
+
+	if err := c.reload(); err != nil {
+		c.disabled[url] = wasDisabled
+		if !wasDisabled {
+			delete(c.disabled, url)
+		}
+		return err
+	}
+	return nil
+}
+
+type kindList struct {
+	kind string
+	list []string
+}
+
+// Get all the lists with their kind.
+func (c *ConfigT) allLists() []kindList {
+	var lists []kindList
+	for _, k := range []struct {
+		kind  string
+		lists [][]string
+	}{
+		{"hostlist", c.Hostlists},
+		{"hostlist", c.included.Hostlists},
+		{"unhostlist", c.Unhostlists},
+		{"regexplist", c.Regexplists},
+		{"unregexplist", c.Unregexplists},
+	} {
+		for _, l := range k.lists {
+			lists = append(lists, kindList{k.kind, l})
+		}
+	}
+	return lists
+}
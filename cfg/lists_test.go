@@ -0,0 +1,82 @@
+package cfg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"arp242.net/trackwall/tt"
+)
+
+func TestLists(t *testing.T) {
+	defer Hosts.Purge()
+	defer Origins.Purge()
+
+	dir, err := ioutil.TempDir("", "trackwall-lists")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	list := filepath.Join(dir, "hosts")
+	tt.Err(t, ioutil.WriteFile(list, []byte("example.com\n"), 0644))
+	url := "file://" + list
+
+	c := ConfigT{Hostlists: [][]string{{"plain", url}}, Hosts: []string{"example.net"}}
+	tt.Err(t, c.Reload())
+	tt.Eq(t, "hosts", []string{"example.com", "example.net"}, Hosts.List(""))
+
+	tt.Err(t, c.DisableList(url, true))
+	tt.Eq(t, "disabled", []string{"example.net"}, Hosts.List(""))
+	lists := c.Lists()
+	tt.Eq(t, "lists", 1, len(lists))
+	tt.Eq(t, "enabled", false, lists[0].Enabled)
+	tt.Eq(t, "size", int64(12), lists[0].Size)
+
+	tt.Err(t, c.DisableList(url, false))
+	tt.Eq(t, "enabled", []string{"example.com", "example.net"}, Hosts.List(""))
+
+	tt.Eq(t, "unknown", ErrUnknownList, c.DisableList("file:///nope", true))
+	tt.Eq(t, "unknown", ErrUnknownList, c.RefreshLists("file:///nope"))
+	tt.Err(t, c.RefreshLists(url))
+
+	// The live rules are kept if reloading fails.
+	tt.Err(t, os.Remove(list))
+	if c.Reload() == nil {
+		t.Error("no error for missing list")
+	}
+	tt.Eq(t, "kept", []string{"example.com", "example.net"}, Hosts.List(""))
+	if c.DisableList(url, false) == nil {
+		t.Error("no error for missing list")
+	}
+	tt.Eq(t, "kept", []string{"example.com", "example.net"}, Hosts.List(""))
+}
+
+func TestListsAdblockRegexps(t *testing.T) {
+	defer Hosts.Purge()
+	defer Regexps.Purge()
+	defer Origins.Purge()
+
+	dir, err := ioutil.TempDir("", "trackwall-lists")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	block := filepath.Join(dir, "block")
+	tt.Err(t, ioutil.WriteFile(block, []byte("||example.com^\n/^ads?\\./\n/^track\\./\n"), 0644))
+	allow := filepath.Join(dir, "allow")
+	tt.Err(t, ioutil.WriteFile(allow, []byte("/^track\\./\n"), 0644))
+
+	c := ConfigT{
+		Hostlists:   [][]string{{"adblock", "file://" + block}},
+		Unhostlists: [][]string{{"adblock", "file://" + allow}},
+	}
+	tt.Err(t, c.Reload())
+	tt.Eq(t, "hosts", []string{"example.com"}, Hosts.List(""))
+	_, ok := Regexps.Find("ads.example.com")
+	tt.Eq(t, "regexp", true, ok)
+	_, ok = Regexps.Find("track.example.com")
+	tt.Eq(t, "unregexp", false, ok)
+	o, _ := Origins.Get(OriginRegexp, `^ads?\.`)
+	tt.Eq(t, "origin", Origin{Source: "file://" + block, Line: 2}, o)
+
+	tt.Err(t, c.DisableList("file://"+block, true))
+	_, ok = Regexps.Find("ads.example.com")
+	tt.Eq(t, "disabled", false, ok)
+}
@@ -0,0 +1,80 @@
+package cfg
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Origin is where a rule was loaded from.
+type Origin struct {
+	Source string // URL of the list, or "config".
+	Line   int    // Line number in the list; 0 if not known.
+}
+
+func (o Origin) String() string {
+	if o.Line > 0 {
+		return fmt.Sprintf("%v line %v", o.Source, o.Line)
+	}
+	return o.Source
+}
+
+// The kinds of rules we record the origin for.
+const (
+	OriginHost     = "host"
+	OriginUnhost   = "unhost"
+	OriginRegexp   = "regexp"
+	OriginUnregexp = "unregexp"
+)
+
+// OriginList records the origin of all the hosts and regexps. Only the first
+// origin is recorded if the same rule is in more than one list.
+type OriginList struct {
+	sync.RWMutex
+	m map[string]map[string]Origin
+}
+
+var (
+	// Origins of all the hosts and regexps.
+	Origins OriginList
+
+	// The list that's being loaded by loadList(), for the formats that add
+	// filters directly (see parseAdblock()).
+	loading string
+)
+
+func init() {
+	Origins = OriginList{}
+	Origins.Purge()
+}
+
+// Get the origin of a rule.
+func (l *OriginList) Get(kind, rule string) (Origin, bool) {
+	l.RLock()
+	o, ok := l.m[kind][rule]
+	l.RUnlock()
+	return o, ok
+}
+
+// Set the origin for rules.
+func (l *OriginList) Set(kind string, o Origin, rules ...string) {
+	l.Lock()
+	defer l.Unlock()
+
+	m, ok := l.m[kind]
+	if !ok {
+		m = make(map[string]Origin)
+		l.m[kind] = m
+	}
+	for _, r := range rules {
+		if _, has := m[r]; !has {
+			m[r] = o
+		}
+	}
+}
+
+// Purge the entire list
+func (l *OriginList) Purge() {
+	l.Lock()
+	l.m = make(map[string]map[string]Origin)
+	l.Unlock()
+}
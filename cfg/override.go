@@ -1,18 +1,50 @@
 package cfg
 
 import (
+	"fmt"
 	"io"
+	"net"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 )
 
 // OverrideList are all the hosts the user told us to override.
-//
-// The value is expiry timestamp.
 type OverrideList struct {
 	sync.RWMutex
-	m map[string]int64
+	m map[OverrideKey]OverrideEntry
+}
+
+// OverrideKey is the host and client of an override. The client is an IP
+// address, the name of a client group, or empty for all clients.
+type OverrideKey struct {
+	Host   string
+	Client string
+}
+
+// OverrideEntry is a single override.
+type OverrideEntry struct {
+	Expires int64 `json:"expires"` // Unix timestamp.
+	Subtree bool  `json:"subtree"` // Also override all subdomains.
+}
+
+// Scope gets the scope as text: "exact" or "subtree".
+func (e OverrideEntry) Scope() string {
+	if e.Subtree {
+		return "subtree"
+	}
+	return "exact"
+}
+
+// ClientName gets the client as text, using "all" for all clients.
+func (k OverrideKey) ClientName() string {
+	if k.Client == "" {
+		return "all"
+	}
+	return k.Client
 }
 
 // Override these hosts and regexps.
@@ -23,8 +55,35 @@ func init() {
 	Override.Purge()
 }
 
+// ParseOverrideClient parses the client for an override: an IP address, the
+// name of a client group, or "" or "all" for all clients.
+func ParseOverrideClient(client string) (string, error) {
+	if client == "" || client == "all" {
+		return "", nil
+	}
+	if ip := net.ParseIP(client); ip != nil {
+		return ip.String(), nil
+	}
+	if _, ok := Config.ClientGroups[client]; ok {
+		return client, nil
+	}
+	return "", fmt.Errorf("not an IP address or client group: %#v", client)
+}
+
+// ClientGroupsOf gets the names of all the client groups that ip is in.
+func (c ConfigT) ClientGroupsOf(ip net.IP) []string {
+	var groups []string
+	for name, nets := range c.ClientGroups {
+		if inNets(ip, nets) {
+			groups = append(groups, name)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
 // Get a single item.
-func (l *OverrideList) Get(k string) (int64, bool) {
+func (l *OverrideList) Get(k OverrideKey) (OverrideEntry, bool) {
 	l.RLock()
 	v, ok := l.m[k]
 	l.RUnlock()
@@ -32,14 +91,69 @@ func (l *OverrideList) Get(k string) (int64, bool) {
 }
 
 // Store an item.
-func (l *OverrideList) Store(k string, expire int64) {
+func (l *OverrideList) Store(k OverrideKey, e OverrideEntry) {
 	l.Lock()
-	l.m[k] = expire
+	l.m[k] = e
 	l.Unlock()
 }
 
+// Match finds the override for name from client: either for name itself, or
+// a subtree override for one of its parent domains. The most specific host is
+// returned; for the same host an override for the client's address is
+// preferred over one for its client groups, which is preferred over one for
+// all clients. Expired overrides are returned as well.
+func (l *OverrideList) Match(name string, client net.IP) (k OverrideKey, e OverrideEntry, ok bool) {
+	clients := []string{}
+	if client != nil {
+		clients = append(clients, client.String())
+		clients = append(clients, Config.ClientGroupsOf(client)...)
+	}
+	clients = append(clients, "")
+
+	l.RLock()
+	defer l.RUnlock()
+
+	find := func(host string, subtree bool) bool {
+		for _, c := range clients {
+			k = OverrideKey{host, c}
+			if e, ok = l.m[k]; ok && (e.Subtree || !subtree) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if find(name, false) {
+		return k, e, true
+	}
+	for i := strings.IndexByte(name, '.'); i > -1; i = strings.IndexByte(name, '.') {
+		name = name[i+1:]
+		if find(name, true) {
+			return k, e, true
+		}
+	}
+	return OverrideKey{}, OverrideEntry{}, false
+}
+
+// PurgeExpired removes all expired overrides, and returns the hosts that were
+// removed.
+func (l *OverrideList) PurgeExpired() []string {
+	l.Lock()
+	defer l.Unlock()
+
+	var hosts []string
+	now := time.Now().Unix()
+	for k, e := range l.m {
+		if now > e.Expires {
+			delete(l.m, k)
+			hosts = append(hosts, k.Host)
+		}
+	}
+	return hosts
+}
+
 // Delete items.
-func (l *OverrideList) Delete(keys ...string) {
+func (l *OverrideList) Delete(keys ...OverrideKey) {
 	l.Lock()
 	defer l.Unlock()
 	for _, k := range keys {
@@ -47,10 +161,21 @@ func (l *OverrideList) Delete(keys ...string) {
 	}
 }
 
+// List all the overrides.
+func (l *OverrideList) List() map[OverrideKey]OverrideEntry {
+	l.RLock()
+	defer l.RUnlock()
+	m := make(map[OverrideKey]OverrideEntry, len(l.m))
+	for k, v := range l.m {
+		m[k] = v
+	}
+	return m
+}
+
 // Purge the entire list
 func (l *OverrideList) Purge() {
 	l.Lock()
-	l.m = make(map[string]int64)
+	l.m = make(map[OverrideKey]OverrideEntry)
 	l.Unlock()
 }
 
@@ -0,0 +1,107 @@
+package cfg
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"arp242.net/trackwall/tt"
+)
+
+func TestOverrideMatch(t *testing.T) {
+	l := &OverrideList{}
+	l.Purge()
+
+	exp := time.Now().Add(time.Hour).Unix()
+	l.Store(OverrideKey{"example.com", ""}, OverrideEntry{Expires: exp, Subtree: true})
+	l.Store(OverrideKey{"exact.example.net", ""}, OverrideEntry{Expires: exp})
+	l.Store(OverrideKey{"a.example.com", ""}, OverrideEntry{Expires: exp})
+	l.Store(OverrideKey{"example.org", "10.0.0.1"}, OverrideEntry{Expires: exp, Subtree: true})
+	l.Store(OverrideKey{"example.org", "kids"}, OverrideEntry{Expires: exp, Subtree: true})
+	l.Store(OverrideKey{"kids.example.org", "kids"}, OverrideEntry{Expires: exp})
+
+	defer func() { Config.ClientGroups = nil }()
+	_, n, _ := net.ParseCIDR("10.0.0.0/24")
+	Config.ClientGroups = map[string][]*net.IPNet{"kids": {n}}
+
+	cases := []struct {
+		name, client   string
+		expectedHost   string
+		expectedClient string
+		expectedOK     bool
+	}{
+		{"example.com", "", "example.com", "", true},
+		{"www.example.com", "", "example.com", "", true},
+		{"a.b.example.com", "10.0.0.1", "example.com", "", true},
+		{"a.example.com", "", "a.example.com", "", true},
+		{"exact.example.net", "", "exact.example.net", "", true},
+		{"www.exact.example.net", "", "", "", false},
+		{"example.net", "", "", "", false},
+		{"notexample.com", "", "", "", false},
+
+		{"example.org", "", "", "", false},
+		{"example.org", "10.0.1.1", "", "", false},
+		{"example.org", "10.0.0.1", "example.org", "10.0.0.1", true},
+		{"www.example.org", "10.0.0.2", "example.org", "kids", true},
+		{"kids.example.org", "10.0.0.2", "kids.example.org", "kids", true},
+		{"kids.example.org", "10.0.0.1", "kids.example.org", "kids", true},
+		{"www.kids.example.org", "10.0.0.1", "example.org", "10.0.0.1", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name+" "+tc.client, func(t *testing.T) {
+			k, _, ok := l.Match(tc.name, net.ParseIP(tc.client))
+			tt.Eq(t, "host", tc.expectedHost, k.Host)
+			tt.Eq(t, "client", tc.expectedClient, k.Client)
+			tt.Eq(t, "ok", tc.expectedOK, ok)
+		})
+	}
+}
+
+func TestParseOverrideClient(t *testing.T) {
+	defer func() { Config.ClientGroups = nil }()
+	Config.ClientGroups = map[string][]*net.IPNet{"kids": nil}
+
+	cases := []struct {
+		in, expected, expectedErr string
+	}{
+		{"", "", ""},
+		{"all", "", ""},
+		{"10.0.0.1", "10.0.0.1", ""},
+		{"::0001", "::1", ""},
+		{"kids", "kids", ""},
+		{"adults", "", `not an IP address or client group: "adults"`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			out, err := ParseOverrideClient(tc.in)
+			if tc.expectedErr != "" {
+				tt.Eq(t, "err", tc.expectedErr, fmt.Sprintf("%v", err))
+				return
+			}
+			tt.Err(t, err)
+			tt.Eq(t, "out", tc.expected, out)
+		})
+	}
+}
+
+func TestOverridePurgeExpired(t *testing.T) {
+	l := &OverrideList{}
+	l.Purge()
+
+	l.Store(OverrideKey{"example.com", ""}, OverrideEntry{Expires: time.Now().Add(time.Hour).Unix()})
+	l.Store(OverrideKey{"expired.example.com", "10.0.0.1"}, OverrideEntry{Expires: time.Now().Add(-time.Second).Unix()})
+
+	tt.Eq(t, "removed", []string{"expired.example.com"}, l.PurgeExpired())
+	tt.Eq(t, "left", "map[example.com:exact]", fmt.Sprintf("%v", scopes(l.List())))
+}
+
+func scopes(m map[OverrideKey]OverrideEntry) map[string]string {
+	s := make(map[string]string, len(m))
+	for k, v := range m {
+		s[k.Host] = v.Scope()
+	}
+	return s
+}
@@ -12,7 +12,8 @@ import (
 // Pre-compiling the surrogate scripts isn't possible here.
 type RegexpList struct {
 	sync.RWMutex
-	l []*regexp.Regexp
+	l   []*regexp.Regexp
+	gen uint64
 }
 
 var (
@@ -36,6 +37,7 @@ func (l *RegexpList) Len() int {
 func (l *RegexpList) Add(regexps ...string) {
 	l.Lock()
 	defer l.Unlock()
+	l.gen++
 
 	for _, re := range regexps {
 		l.l = append(l.l, regexp.MustCompile(re))
@@ -46,6 +48,7 @@ func (l *RegexpList) Add(regexps ...string) {
 func (l *RegexpList) Remove(regexps ...string) {
 	l.Lock()
 	defer l.Unlock()
+	l.gen++
 
 	for _, re := range regexps {
 		for i, r := range l.l {
@@ -59,15 +62,51 @@ func (l *RegexpList) Remove(regexps ...string) {
 
 // Match the name against all the regexps.
 func (l *RegexpList) Match(name string) bool {
+	_, ok := l.Find(name)
+	return ok
+}
+
+// Has reports if the regexp is in the list.
+func (l *RegexpList) Has(re string) bool {
+	l.RLock()
+	defer l.RUnlock()
+	for _, r := range l.l {
+		if r.String() == re {
+			return true
+		}
+	}
+	return false
+}
+
+// Find the first regexp that matches the name.
+func (l *RegexpList) Find(name string) (string, bool) {
 	l.Lock()
 	defer l.Unlock()
 
 	for _, r := range l.l {
 		if r.MatchString(name) {
-			return true
+			return r.String(), true
 		}
 	}
-	return false
+	return "", false
+}
+
+// Generation is incremented on every change to the list.
+func (l *RegexpList) Generation() uint64 {
+	l.RLock()
+	defer l.RUnlock()
+	return l.gen
+}
+
+// List all the regexps.
+func (l *RegexpList) List() []string {
+	l.RLock()
+	defer l.RUnlock()
+	regexps := make([]string, 0, len(l.l))
+	for _, r := range l.l {
+		regexps = append(regexps, r.String())
+	}
+	return regexps
 }
 
 // Dump all keys to the writer.
@@ -83,5 +122,6 @@ func (l *RegexpList) Dump(w io.Writer) {
 func (l *RegexpList) Purge() {
 	l.Lock()
 	l.l = []*regexp.Regexp{}
+	l.gen++
 	l.Unlock()
 }
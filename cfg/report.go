@@ -0,0 +1,186 @@
+package cfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ReportsPath is the location in the chroot where the reports of wrongly
+// blocked hosts are saved.
+const ReportsPath = "/reports.json"
+
+// Report of a host that was wrongly blocked, sent from the blocked page.
+type Report struct {
+	ID      int       `json:"id"`
+	Time    time.Time `json:"time"`
+	Host    string    `json:"host"`    // Name that was blocked.
+	Rule    string    `json:"rule"`    // Rule that blocked it.
+	List    string    `json:"list"`    // Where the rule was loaded from.
+	Line    int       `json:"line"`    // Line number in the list; 0 if not known.
+	Referer string    `json:"referer"` // Page that loaded the host.
+	Client  string    `json:"client"`
+}
+
+// The file at ReportsPath; the next ID is stored so that IDs are never reused.
+type reportsFile struct {
+	Next    int      `json:"next"`
+	Reports []Report `json:"reports"`
+}
+
+// ReportList is the queue of reports that need to be triaged.
+type ReportList struct {
+	sync.Mutex
+	path    string
+	next    int
+	reports []Report
+}
+
+// Reports of wrongly blocked hosts.
+var Reports ReportList
+
+// Load the reports from path, and save all changes there. Without calling this
+// the reports are only kept in memory.
+func (l *ReportList) Load(path string) error {
+	l.Lock()
+	defer l.Unlock()
+
+	l.path, l.next, l.reports = path, 1, nil
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st reportsFile
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	l.next, l.reports = st.Next, st.Reports
+	for _, r := range l.reports {
+		if r.ID >= l.next {
+			l.next = r.ID + 1
+		}
+	}
+	return nil
+}
+
+// Add a report, and return it with the ID set. If there's already a report for
+// the same host and rule that one is returned and nothing is added.
+func (l *ReportList) Add(r Report) (Report, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	for _, have := range l.reports {
+		if have.Host == r.Host && have.Rule == r.Rule {
+			return have, nil
+		}
+	}
+
+	if l.next == 0 {
+		l.next = 1
+	}
+	r.ID = l.next
+	l.next++
+	l.reports = append(l.reports, r)
+	return r, l.save()
+}
+
+// Get a report by ID.
+func (l *ReportList) Get(id int) (Report, bool) {
+	l.Lock()
+	defer l.Unlock()
+	for _, r := range l.reports {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return Report{}, false
+}
+
+// List all reports, oldest first.
+func (l *ReportList) List() []Report {
+	l.Lock()
+	defer l.Unlock()
+	reports := make([]Report, len(l.reports))
+	copy(reports, l.reports)
+	return reports
+}
+
+// Remove reports.
+func (l *ReportList) Remove(ids ...int) error {
+	l.Lock()
+	defer l.Unlock()
+
+	keep := l.reports[:0]
+	for _, r := range l.reports {
+		if !containsInt(ids, r.ID) {
+			keep = append(keep, r)
+		}
+	}
+	l.reports = keep
+	return l.save()
+}
+
+// ExportReports writes the rules of the reports for the maintainers of the
+// lists, grouped by list.
+func ExportReports(w io.Writer, reports []Report) {
+	lists := make(map[string][]Report)
+	for _, r := range reports {
+		lists[r.List] = append(lists[r.List], r)
+	}
+	names := make([]string, 0, len(lists))
+	for n := range lists {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for i, n := range names {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if n == "" {
+			n = "unknown list"
+		}
+		fmt.Fprintf(w, "# False positives in %v\n", n)
+		for _, r := range lists[n] {
+			fmt.Fprintf(w, "%v", r.Rule)
+			if r.Line > 0 {
+				fmt.Fprintf(w, "  # line %v", r.Line)
+			} else {
+				fmt.Fprintf(w, "  #")
+			}
+			fmt.Fprintf(w, "; blocks %v", r.Host)
+			if r.Referer != "" {
+				fmt.Fprintf(w, " on %v", r.Referer)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+func (l *ReportList) save() error {
+	if l.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(reportsFile{l.next, l.reports}, "", "\t")
+	if err != nil {
+		return err
+	}
+	return WriteAtomic(l.path, data)
+}
+
+func containsInt(l []int, n int) bool {
+	for _, v := range l {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package cfg
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"arp242.net/trackwall/tt"
+)
+
+func TestReports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackwall-reports")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "reports.json")
+
+	l := &ReportList{}
+	tt.Err(t, l.Load(path))
+
+	a, err := l.Add(Report{Host: "a.example.com", Rule: "example.com", List: "http://list", Line: 3, Referer: "http://page/"})
+	tt.Err(t, err)
+	tt.Eq(t, "id", 1, a.ID)
+	b, err := l.Add(Report{Host: "b.example.net", Rule: `\.example\.net$`, List: "config"})
+	tt.Err(t, err)
+	tt.Eq(t, "id", 2, b.ID)
+	dup, err := l.Add(Report{Host: "a.example.com", Rule: "example.com"})
+	tt.Err(t, err)
+	tt.Eq(t, "duplicate", a, dup)
+	c, err := l.Add(Report{Host: "c.example.com", Rule: "example.com", List: "http://list", Line: 3})
+	tt.Err(t, err)
+
+	// Loaded again.
+	l2 := &ReportList{}
+	tt.Err(t, l2.Load(path))
+	tt.Eq(t, "loaded", []Report{a, b, c}, l2.List())
+
+	var buf bytes.Buffer
+	ExportReports(&buf, l2.List())
+	tt.Eq(t, "export", "# False positives in config\n"+
+		"\\.example\\.net$  #; blocks b.example.net\n"+
+		"\n"+
+		"# False positives in http://list\n"+
+		"example.com  # line 3; blocks a.example.com on http://page/\n"+
+		"example.com  # line 3; blocks c.example.com\n", buf.String())
+
+	tt.Err(t, l2.Remove(1, 3))
+	tt.Eq(t, "removed", []Report{b}, l2.List())
+	_, ok := l2.Get(1)
+	tt.Eq(t, "get removed", false, ok)
+
+	// IDs aren't reused.
+	tt.Err(t, l.Load(path))
+	d, err := l.Add(Report{Host: "d.example.com"})
+	tt.Err(t, err)
+	tt.Eq(t, "id", 4, d.ID)
+}
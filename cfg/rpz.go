@@ -0,0 +1,553 @@
+package cfg
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"arp242.net/trackwall/msg"
+	"github.com/miekg/dns"
+)
+
+// RPZList are the Response Policy Zones, in the order they're defined in the
+// config file.
+type RPZList struct {
+	sync.RWMutex
+	zones []*rpzZone
+}
+
+// rpzZone is a single response policy zone.
+type rpzZone struct {
+	origin string // rpz.example.com.
+	source string // file:///path or axfr://host:port
+	soa    *dns.SOA
+
+	// Triggers; the key is the name without the origin. Wildcards are stored
+	// without the "*.".
+	names     map[string]*Policy
+	wildcards map[string]*Policy
+
+	// Last successful load; used for the SOA expire timer.
+	updated time.Time
+
+	// Refresh() started a goroutine for this zone.
+	refreshing bool
+}
+
+// Policy is the action for a single RPZ trigger. The action is determined by
+// the records.
+type Policy struct {
+	Zone    string   // Origin of the zone this policy is in.
+	Trigger string   // Name of the trigger, without the origin.
+	Records []dns.RR // All records for this trigger.
+}
+
+// RPZ policy actions.
+const (
+	PolicyNXDomain = iota + 1 // CNAME .
+	PolicyNoData              // CNAME *.
+	PolicyPassthru            // CNAME rpz-passthru.
+	PolicyDrop                // CNAME rpz-drop.
+	PolicyTCPOnly             // CNAME rpz-tcp-only.
+	PolicyLocal               // Any other records.
+)
+
+// Default timers if the zone has no SOA record.
+const (
+	rpzRefresh = time.Hour
+	rpzRetry   = 10 * time.Minute
+	rpzExpire  = 7 * 24 * time.Hour
+)
+
+var (
+	// RPZ are all the loaded response policy zones.
+	RPZ RPZList
+)
+
+// Load all the zones; the zone is an origin and source. Zones that are already
+// loaded are skipped, as they're refreshed on their own.
+func (l *RPZList) Load(zones ...[]string) {
+	for _, z := range zones {
+		zone := &rpzZone{origin: dns.Fqdn(strings.ToLower(z[0])), source: z[1]}
+		if l.has(zone.origin, zone.source) {
+			continue
+		}
+
+		_, err := zone.update()
+		if err != nil {
+			msg.Warn(fmt.Errorf("unable to load RPZ %v from %v: %v", zone.origin, zone.source, err))
+		}
+
+		l.Lock()
+		l.zones = append(l.zones, zone)
+		l.Unlock()
+	}
+}
+
+func (l *RPZList) has(origin, source string) bool {
+	l.RLock()
+	defer l.RUnlock()
+	for _, z := range l.zones {
+		if z.origin == origin && z.source == source {
+			return true
+		}
+	}
+	return false
+}
+
+// Refresh the zones according to the SOA refresh, retry, and expire timers.
+// This starts a goroutine for every zone that isn't refreshed yet; changed is
+// called after a zone has changed.
+func (l *RPZList) Refresh(changed func()) {
+	l.Lock()
+	defer l.Unlock()
+
+	for _, z := range l.zones {
+		if z.refreshing {
+			continue
+		}
+		z.refreshing = true
+		go func(z *rpzZone) {
+			wait := z.refresh()
+			for {
+				time.Sleep(wait)
+
+				ch, err := z.update()
+				if err == nil {
+					wait = z.refresh()
+					if ch {
+						msg.Info(fmt.Sprintf("RPZ %v updated to serial %v", z.origin, z.serial()), Config.Verbose)
+						changed()
+					}
+					continue
+				}
+
+				msg.Warn(fmt.Errorf("unable to refresh RPZ %v from %v: %v", z.origin, z.source, err))
+				wait = z.retry()
+
+				l.Lock()
+				expired := !z.updated.IsZero() && time.Since(z.updated) > z.expire()
+				if expired {
+					z.names = make(map[string]*Policy)
+					z.wildcards = make(map[string]*Policy)
+					z.updated = time.Time{}
+				}
+				l.Unlock()
+
+				if expired {
+					msg.Warn(fmt.Errorf("RPZ %v expired; not using it until it can be refreshed", z.origin))
+					changed()
+				}
+			}
+		}(z)
+	}
+}
+
+// Match name against all the zones. The first zone with a trigger wins, and
+// within a zone exact triggers take precedence over wildcards (the most
+// specific wildcard is used).
+func (l *RPZList) Match(name string) *Policy {
+	l.RLock()
+	defer l.RUnlock()
+
+	name = strings.ToLower(name)
+	for _, z := range l.zones {
+		if p, ok := z.names[name]; ok {
+			return p
+		}
+
+		parent := name
+		for i := strings.Index(parent, "."); i > -1; i = strings.Index(parent, ".") {
+			parent = parent[i+1:]
+			if p, ok := z.wildcards[parent]; ok {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// Len returns the number of triggers.
+func (l *RPZList) Len() int {
+	l.RLock()
+	defer l.RUnlock()
+
+	n := 0
+	for _, z := range l.zones {
+		n += len(z.names) + len(z.wildcards)
+	}
+	return n
+}
+
+// Dump all zones to the writer.
+func (l *RPZList) Dump(w io.Writer) {
+	l.RLock()
+	defer l.RUnlock()
+
+	for _, z := range l.zones {
+		fmt.Fprintf(w, "# %v from %v; serial %v, %v triggers\n", z.origin, z.source,
+			z.serial(), len(z.names)+len(z.wildcards))
+		for _, p := range z.names {
+			for _, rr := range p.Records {
+				fmt.Fprintf(w, "%v\n", rr)
+			}
+		}
+		for _, p := range z.wildcards {
+			for _, rr := range p.Records {
+				fmt.Fprintf(w, "%v\n", rr)
+			}
+		}
+	}
+}
+
+// Purge the entire list
+func (l *RPZList) Purge() {
+	l.Lock()
+	l.zones = []*rpzZone{}
+	l.Unlock()
+}
+
+// Action gets the Policy* constant for this policy.
+func (p *Policy) Action() int {
+	for _, rr := range p.Records {
+		cname, ok := rr.(*dns.CNAME)
+		if !ok {
+			continue
+		}
+
+		switch strings.ToLower(cname.Target) {
+		case ".":
+			return PolicyNXDomain
+		case "*.":
+			return PolicyNoData
+		case "rpz-passthru.", p.Trigger + ".":
+			return PolicyPassthru
+		case "rpz-drop.":
+			return PolicyDrop
+		case "rpz-tcp-only.":
+			return PolicyTCPOnly
+		}
+	}
+	return PolicyLocal
+}
+
+// Answer gets the answer section for local data policies. A CNAME is always
+// returned, other records only if the type matches.
+func (p *Policy) Answer(name string, qtype uint16) []dns.RR {
+	var answer []dns.RR
+	for _, rr := range p.Records {
+		if rr.Header().Rrtype != qtype && rr.Header().Rrtype != dns.TypeCNAME {
+			continue
+		}
+
+		rr = dns.Copy(rr)
+		rr.Header().Name = dns.Fqdn(name)
+
+		// *.example.com CNAME *.walled-garden. rewrites to
+		// name.walled-garden.
+		if cname, ok := rr.(*dns.CNAME); ok {
+			if strings.HasPrefix(cname.Target, "*.") {
+				cname.Target = dns.Fqdn(name) + cname.Target[2:]
+			}
+			return []dns.RR{cname}
+		}
+		answer = append(answer, rr)
+	}
+	return answer
+}
+
+func (z *rpzZone) serial() uint32 {
+	if z.soa == nil {
+		return 0
+	}
+	return z.soa.Serial
+}
+
+func (z *rpzZone) refresh() time.Duration {
+	if z.soa == nil || z.soa.Refresh == 0 {
+		return rpzRefresh
+	}
+	return time.Duration(z.soa.Refresh) * time.Second
+}
+
+func (z *rpzZone) retry() time.Duration {
+	if z.soa == nil || z.soa.Retry == 0 {
+		return rpzRetry
+	}
+	return time.Duration(z.soa.Retry) * time.Second
+}
+
+func (z *rpzZone) expire() time.Duration {
+	if z.soa == nil || z.soa.Expire == 0 {
+		return rpzExpire
+	}
+	return time.Duration(z.soa.Expire) * time.Second
+}
+
+// update the zone from the source if the serial changed. Returns true if the
+// zone was changed.
+func (z *rpzZone) update() (bool, error) {
+	var (
+		rrs  []dns.RR
+		ixfr bool
+		err  error
+	)
+
+	switch {
+	case strings.HasPrefix(z.source, "file://"):
+		rrs, err = readZone(z.source[7:], z.origin)
+	case strings.HasPrefix(z.source, "axfr://"):
+		rrs, ixfr, err = z.transfer(z.source[7:])
+	default:
+		return false, fmt.Errorf("unknown source: %v", z.source)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Nothing changed.
+	if rrs == nil {
+		RPZ.Lock()
+		z.updated = time.Now()
+		RPZ.Unlock()
+		return false, nil
+	}
+
+	if ixfr {
+		return true, z.applyIXFR(rrs)
+	}
+
+	n := &rpzZone{origin: z.origin, names: make(map[string]*Policy), wildcards: make(map[string]*Policy)}
+	skip := make(skipped)
+	for _, rr := range rrs {
+		if reason := n.add(rr); reason != "" {
+			skip[reason]++
+		}
+	}
+	if n.soa == nil {
+		return false, fmt.Errorf("no SOA record for %v", z.origin)
+	}
+	if s := skip.total(); s > 0 {
+		msg.Info(fmt.Sprintf("skipped %d records in RPZ %v: %v", s, z.origin, skip), Config.Verbose)
+	}
+
+	// Serial didn't change for files.
+	if z.soa != nil && z.names != nil && !serialNewer(n.soa.Serial, z.soa.Serial) {
+		RPZ.Lock()
+		z.updated = time.Now()
+		RPZ.Unlock()
+		return false, nil
+	}
+
+	RPZ.Lock()
+	z.soa, z.names, z.wildcards, z.updated = n.soa, n.names, n.wildcards, time.Now()
+	RPZ.Unlock()
+	return true, nil
+}
+
+// Get all records with a zone transfer from the primary at addr. This uses
+// IXFR if we already have the zone, and AXFR otherwise. The primary may
+// respond to an IXFR with the full zone, in which case ixfr is false.
+//
+// Returns nil if the zone is up to date.
+func (z *rpzZone) transfer(addr string) (rrs []dns.RR, ixfr bool, err error) {
+	if !strings.Contains(addr, ":") {
+		addr += ":53"
+	}
+
+	m := new(dns.Msg)
+	if z.soa != nil {
+		// Check the serial first so we don't need to connect over TCP.
+		q := new(dns.Msg)
+		q.SetQuestion(z.origin, dns.TypeSOA)
+		resp, _, err := (&dns.Client{}).Exchange(q, addr)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(resp.Answer) == 0 {
+			return nil, false, fmt.Errorf("no SOA record for %v", z.origin)
+		}
+		if soa, ok := resp.Answer[0].(*dns.SOA); ok && !serialNewer(soa.Serial, z.soa.Serial) {
+			return nil, false, nil
+		}
+		m.SetIxfr(z.origin, z.soa.Serial, z.soa.Ns, z.soa.Mbox)
+	} else {
+		m.SetAxfr(z.origin)
+	}
+
+	rrs, err = xfr(m, addr)
+	if err != nil && z.soa != nil {
+		// Not all primaries support IXFR.
+		msg.Warn(fmt.Errorf("IXFR for %v failed, trying AXFR: %v", z.origin, err))
+		m = new(dns.Msg).SetAxfr(z.origin)
+		rrs, err = xfr(m, addr)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Up to date.
+	if len(rrs) == 1 {
+		return nil, false, nil
+	}
+
+	// An AXFR response has the zone's records after the SOA, an IXFR response
+	// the old SOA.
+	ixfr = m.Question[0].Qtype == dns.TypeIXFR && len(rrs) > 2 &&
+		rrs[1].Header().Rrtype == dns.TypeSOA
+	return rrs, ixfr, nil
+}
+
+func xfr(m *dns.Msg, addr string) ([]dns.RR, error) {
+	env, err := new(dns.Transfer).In(m, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var rrs []dns.RR
+	for e := range env {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		rrs = append(rrs, e.RR...)
+	}
+	if len(rrs) == 0 {
+		return nil, fmt.Errorf("empty zone transfer")
+	}
+	return rrs, nil
+}
+
+// Read a zone file.
+func readZone(path, origin string) ([]dns.RR, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = fp.Close() }()
+
+	var rrs []dns.RR
+	for t := range dns.ParseZone(fp, origin, path) {
+		if t.Error != nil {
+			return nil, t.Error
+		}
+		rrs = append(rrs, t.RR)
+	}
+	return rrs, nil
+}
+
+// applyIXFR applies the differences from an IXFR response:
+//
+//	SOA (new serial)
+//	SOA (old serial) <records to delete> SOA (next serial) <records to add>
+//	...
+//	SOA (new serial)
+func (z *rpzZone) applyIXFR(rrs []dns.RR) error {
+	final, ok := rrs[0].(*dns.SOA)
+	if !ok || rrs[len(rrs)-1].Header().Rrtype != dns.TypeSOA {
+		return fmt.Errorf("malformed IXFR response")
+	}
+
+	RPZ.RLock()
+	n := &rpzZone{origin: z.origin, soa: z.soa,
+		names:     make(map[string]*Policy, len(z.names)),
+		wildcards: make(map[string]*Policy, len(z.wildcards))}
+	for k, p := range z.names {
+		n.names[k] = &Policy{Zone: p.Zone, Trigger: p.Trigger, Records: append([]dns.RR{}, p.Records...)}
+	}
+	for k, p := range z.wildcards {
+		n.wildcards[k] = &Policy{Zone: p.Zone, Trigger: p.Trigger, Records: append([]dns.RR{}, p.Records...)}
+	}
+	RPZ.RUnlock()
+
+	deleting := false
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if _, ok := rr.(*dns.SOA); ok {
+			deleting = !deleting
+			continue
+		}
+		if deleting {
+			n.remove(rr)
+		} else {
+			n.add(rr)
+		}
+	}
+
+	RPZ.Lock()
+	z.soa, z.names, z.wildcards, z.updated = final, n.names, n.wildcards, time.Now()
+	RPZ.Unlock()
+	return nil
+}
+
+// trigger gets the trigger name and map to store the record in. Returns the
+// reason if the record can't be used.
+func (z *rpzZone) trigger(rr dns.RR) (string, map[string]*Policy, string) {
+	owner := strings.ToLower(rr.Header().Name)
+	if !strings.HasSuffix(owner, "."+z.origin) {
+		return "", nil, "outside of zone"
+	}
+
+	name := strings.TrimSuffix(owner, "."+z.origin)
+	labels := strings.Split(name, ".")
+	if strings.HasPrefix(labels[len(labels)-1], "rpz-") {
+		return "", nil, "unsupported trigger"
+	}
+
+	if strings.HasPrefix(name, "*.") {
+		return name[2:], z.wildcards, ""
+	}
+	return name, z.names, ""
+}
+
+// add a record. Returns the reason if it can't be used.
+func (z *rpzZone) add(rr dns.RR) string {
+	if strings.ToLower(rr.Header().Name) == z.origin {
+		if soa, ok := rr.(*dns.SOA); ok {
+			z.soa = soa
+		}
+		return ""
+	}
+
+	name, m, reason := z.trigger(rr)
+	if reason != "" {
+		return reason
+	}
+
+	p, ok := m[name]
+	if !ok {
+		p = &Policy{Zone: z.origin, Trigger: name}
+		m[name] = p
+	}
+	p.Records = append(p.Records, rr)
+	return ""
+}
+
+// remove a record.
+func (z *rpzZone) remove(rr dns.RR) {
+	name, m, reason := z.trigger(rr)
+	if reason != "" {
+		return
+	}
+
+	p, ok := m[name]
+	if !ok {
+		return
+	}
+	for i, r := range p.Records {
+		if dns.IsDuplicate(r, rr) {
+			p.Records = append(p.Records[:i], p.Records[i+1:]...)
+			break
+		}
+	}
+	if len(p.Records) == 0 {
+		delete(m, name)
+	}
+}
+
+// serialNewer reports if serial a is newer than b, using RFC 1982 serial number
+// arithmetic.
+func serialNewer(a, b uint32) bool {
+	return int32(a-b) > 0
+}
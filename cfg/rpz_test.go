@@ -0,0 +1,174 @@
+package cfg
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"arp242.net/trackwall/tt"
+	"github.com/miekg/dns"
+)
+
+const testZone = `$TTL 60
+@                     SOA   ns.example.com. admin.example.com. 1 3600 600 86400 60
+                      NS    ns.example.com.
+nx.example.com        CNAME .
+nodata.example.com    CNAME *.
+pass.example.com      CNAME rpz-passthru.
+legacy.example.com    CNAME legacy.example.com.
+drop.example.com      CNAME rpz-drop.
+tcp.example.com       CNAME rpz-tcp-only.
+local.example.com     A     10.0.0.1
+local.example.com     TXT   "blocked"
+cname.example.com     CNAME walled-garden.example.net.
+*.wild.example.com    CNAME .
+ok.wild.example.com   CNAME rpz-passthru.
+*.garden.example.com  CNAME *.walled-garden.example.net.
+32.1.0.0.10.rpz-ip    CNAME .
+`
+
+func loadTestZone(t *testing.T) *rpzZone {
+	fp, err := ioutil.TempFile("", "trackwall-rpz")
+	tt.Err(t, err)
+	defer os.Remove(fp.Name()) // nolint: errcheck
+
+	_, err = fp.WriteString(testZone)
+	tt.Err(t, err)
+	tt.Err(t, fp.Close())
+
+	RPZ.Purge()
+	RPZ.Load([]string{"rpz.test", "file://" + fp.Name()})
+	tt.Eq(t, "len", 11, RPZ.Len())
+
+	RPZ.RLock()
+	defer RPZ.RUnlock()
+	return RPZ.zones[0]
+}
+
+func TestRPZMatch(t *testing.T) {
+	loadTestZone(t)
+	defer RPZ.Purge()
+
+	cases := []struct {
+		name     string
+		expected int
+	}{
+		{"nx.example.com", PolicyNXDomain},
+		{"NX.Example.COM", PolicyNXDomain},
+		{"sub.nx.example.com", 0},
+		{"nodata.example.com", PolicyNoData},
+		{"pass.example.com", PolicyPassthru},
+		{"legacy.example.com", PolicyPassthru},
+		{"drop.example.com", PolicyDrop},
+		{"tcp.example.com", PolicyTCPOnly},
+		{"local.example.com", PolicyLocal},
+		{"cname.example.com", PolicyLocal},
+		{"wild.example.com", 0},
+		{"a.wild.example.com", PolicyNXDomain},
+		{"a.b.wild.example.com", PolicyNXDomain},
+		{"ok.wild.example.com", PolicyPassthru},
+		{"example.com", 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := 0
+			if p := RPZ.Match(tc.name); p != nil {
+				out = p.Action()
+			}
+			tt.Eq(t, "action", tc.expected, out)
+		})
+	}
+}
+
+func TestRPZLoadTwice(t *testing.T) {
+	fp, err := ioutil.TempFile("", "trackwall-rpz")
+	tt.Err(t, err)
+	defer os.Remove(fp.Name()) // nolint: errcheck
+	_, err = fp.WriteString(testZone)
+	tt.Err(t, err)
+	tt.Err(t, fp.Close())
+
+	RPZ.Purge()
+	defer RPZ.Purge()
+	zone := []string{"rpz.test", "file://" + fp.Name()}
+	RPZ.Load(zone)
+	RPZ.Load(zone, []string{"RPZ.test.", "file://" + fp.Name()})
+	tt.Eq(t, "zones", 1, len(RPZ.zones))
+	tt.Eq(t, "len", 11, RPZ.Len())
+
+	// Only one goroutine is started for every zone.
+	RPZ.Refresh(func() {})
+	tt.Eq(t, "refreshing", true, RPZ.zones[0].refreshing)
+	RPZ.Load([]string{"rpz2.test", "file://" + fp.Name()})
+	tt.Eq(t, "new zone", false, RPZ.zones[1].refreshing)
+	RPZ.Refresh(func() {})
+	tt.Eq(t, "new zone", true, RPZ.zones[1].refreshing)
+}
+
+func TestRPZAnswer(t *testing.T) {
+	loadTestZone(t)
+	defer RPZ.Purge()
+
+	cases := []struct {
+		name     string
+		qtype    uint16
+		expected []string
+	}{
+		{"local.example.com", dns.TypeA, []string{"local.example.com.\t60\tIN\tA\t10.0.0.1"}},
+		{"local.example.com", dns.TypeTXT, []string{"local.example.com.\t60\tIN\tTXT\t\"blocked\""}},
+		{"local.example.com", dns.TypeMX, nil},
+		{"cname.example.com", dns.TypeA, []string{"cname.example.com.\t60\tIN\tCNAME\twalled-garden.example.net."}},
+		{"x.garden.example.com", dns.TypeA, []string{"x.garden.example.com.\t60\tIN\tCNAME\tx.garden.example.com.walled-garden.example.net."}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out []string
+			for _, rr := range RPZ.Match(tc.name).Answer(tc.name, tc.qtype) {
+				out = append(out, rr.String())
+			}
+			tt.Eq(t, "answer", tc.expected, out)
+		})
+	}
+}
+
+func TestRPZIXFR(t *testing.T) {
+	z := loadTestZone(t)
+	defer RPZ.Purge()
+
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		tt.Err(t, err)
+		return r
+	}
+
+	err := z.applyIXFR([]dns.RR{
+		rr("rpz.test. 60 SOA ns.example.com. admin.example.com. 3 3600 600 86400 60"),
+		rr("rpz.test. 60 SOA ns.example.com. admin.example.com. 1 3600 600 86400 60"),
+		rr("nx.example.com.rpz.test. 60 CNAME ."),
+		rr("rpz.test. 60 SOA ns.example.com. admin.example.com. 2 3600 600 86400 60"),
+		rr("new.example.com.rpz.test. 60 CNAME ."),
+		rr("rpz.test. 60 SOA ns.example.com. admin.example.com. 2 3600 600 86400 60"),
+		rr("local.example.com.rpz.test. 60 TXT \"blocked\""),
+		rr("pass.example.com.rpz.test. 60 CNAME rpz-passthru."),
+		rr("rpz.test. 60 SOA ns.example.com. admin.example.com. 3 3600 600 86400 60"),
+		rr("pass.example.com.rpz.test. 60 CNAME ."),
+		rr("rpz.test. 60 SOA ns.example.com. admin.example.com. 3 3600 600 86400 60"),
+	})
+	tt.Err(t, err)
+
+	tt.Eq(t, "serial", uint32(3), z.serial())
+	tt.Eq(t, "len", 11, RPZ.Len())
+	tt.Eq(t, "deleted", (*Policy)(nil), RPZ.Match("nx.example.com"))
+	tt.Eq(t, "added", PolicyNXDomain, RPZ.Match("new.example.com").Action())
+	tt.Eq(t, "changed", PolicyNXDomain, RPZ.Match("pass.example.com").Action())
+	tt.Eq(t, "records", 1, len(RPZ.Match("local.example.com").Records))
+}
+
+func TestSerialNewer(t *testing.T) {
+	tt.Eq(t, "newer", true, serialNewer(2, 1))
+	tt.Eq(t, "same", false, serialNewer(1, 1))
+	tt.Eq(t, "older", false, serialNewer(1, 2))
+	tt.Eq(t, "wrap", true, serialNewer(1, 0xfffffff0))
+}
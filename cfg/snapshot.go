@@ -0,0 +1,560 @@
+package cfg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"arp242.net/trackwall/msg"
+)
+
+// A snapshot is the complete effective ruleset as written by "trackwall
+// compile". The file format is:
+//
+//	magic     "TWSNAPSH"
+//	version   uint32, big endian
+//	checksum  SHA-256 of the body
+//	body
+//
+// Numbers in the body are uvarints, and strings are prefixed with the length:
+//
+//	config hash, build time (unix)
+//	sources:    count, [URL, state, SHA-256]
+//	origins:    count, [origin source]
+//	hosts:      count, [host, surrogate script, origin, line]
+//	regexps:    count, [regexp, origin, line]
+//	surrogates: count, [regexp, script]
+//	filters:    count, [filter text, origin, line]
+//
+// The origin is an index in the origins table; 0 is "unknown".
+const (
+	snapshotMagic   = "TWSNAPSH"
+	snapshotVersion = 3
+	snapshotHeader  = len(snapshotMagic) + 4 + sha256.Size
+
+	// CompiledPath is the location of the snapshot in the chroot.
+	CompiledPath = "/cache/compiled"
+)
+
+// Snapshot is a compiled ruleset.
+type Snapshot struct {
+	Version    uint32
+	ConfigHash string
+	Built      time.Time
+	Sources    []Source
+	Hosts      map[string]string
+	Regexps    []string
+	Surrogates [][]string
+	Filters    []string
+
+	// Origins of the hosts, regexps, and filters, by kind and rule.
+	Origins map[string]map[string]Origin
+}
+
+// Kind for the filter origins in the snapshot; the filters store their own
+// origin.
+const originFilter = "filter"
+
+// Get the origin of a rule in the snapshot.
+func (s *Snapshot) origin(kind, rule string) Origin {
+	return s.Origins[kind][rule]
+}
+
+// Set the origin of a rule in the snapshot.
+func (s *Snapshot) setOrigin(kind, rule string, o Origin) {
+	if o == (Origin{}) {
+		return
+	}
+	if s.Origins == nil {
+		s.Origins = make(map[string]map[string]Origin)
+	}
+	if s.Origins[kind] == nil {
+		s.Origins[kind] = make(map[string]Origin)
+	}
+	s.Origins[kind][rule] = o
+}
+
+// Source is a list the ruleset was compiled from.
+type Source struct {
+	URL   string
+	State string // Modification time and size of the file or cached copy.
+	Sum   string // SHA-256 of the file or cached copy.
+}
+
+// The URLs of all the lists loaded by loadCachedURL().
+var loadedSources []string
+
+// Compile all the rules in a snapshot, which can be loaded much faster than
+// the lists.
+func (c *ConfigT) Compile() {
+	s, err := c.compile()
+	msg.Fatal(err)
+	err = writeSnapshot(CompiledPath, s)
+	msg.Fatal(err)
+
+	fmt.Printf("Compiled %v hosts, %v regexps, %v surrogates, and %v filters from %v sources\n",
+		len(s.Hosts), len(s.Regexps), len(s.Surrogates), len(s.Filters), len(s.Sources))
+}
+
+// Read the rules and get a snapshot of them. The rules from AddedPath aren't
+// included: they're not in the config hash, and are added when the snapshot is
+// loaded.
+func (c *ConfigT) compile() (*Snapshot, error) {
+	loadedSources = nil
+	if err := c.readLists(); err != nil {
+		return nil, err
+	}
+	return c.snapshot(), nil
+}
+
+// Get a snapshot of the currently loaded rules.
+//
+// All hosts are stored, including subdomains of other hosts: they can have a
+// different surrogate script and origin.
+func (c *ConfigT) snapshot() *Snapshot {
+	s := &Snapshot{
+		Version:    snapshotVersion,
+		ConfigHash: c.rulesHash(),
+		Built:      time.Now(),
+	}
+
+	for _, url := range loadedSources {
+		state, _, err := sourceState(url)
+		msg.Fatal(err)
+		data, err := ioutil.ReadFile(sourcePath(url))
+		msg.Fatal(err)
+		sum := sha256.Sum256(data)
+		s.Sources = append(s.Sources, Source{URL: url, State: state, Sum: hex.EncodeToString(sum[:])})
+	}
+
+	Hosts.RLock()
+	s.Hosts = make(map[string]string, len(Hosts.m))
+	for h, script := range Hosts.m {
+		s.Hosts[h] = script
+	}
+	Hosts.RUnlock()
+	for h := range s.Hosts {
+		o, _ := Origins.Get(OriginHost, h)
+		s.setOrigin(OriginHost, h, o)
+	}
+
+	Regexps.RLock()
+	for _, re := range Regexps.l {
+		s.Regexps = append(s.Regexps, re.String())
+	}
+	Regexps.RUnlock()
+	for _, re := range s.Regexps {
+		o, _ := Origins.Get(OriginRegexp, re)
+		s.setOrigin(OriginRegexp, re, o)
+	}
+
+	Surrogates.RLock()
+	for _, sur := range Surrogates.l {
+		s.Surrogates = append(s.Surrogates, []string{sur.String(), sur.script})
+	}
+	Surrogates.RUnlock()
+
+	Filters.RLock()
+	for _, filters := range Filters.names {
+		for _, f := range filters {
+			s.Filters = append(s.Filters, f.Text)
+			s.setOrigin(originFilter, f.Text, f.Origin)
+		}
+	}
+	for _, f := range Filters.regexps {
+		s.Filters = append(s.Filters, f.Text)
+		s.setOrigin(originFilter, f.Text, f.Origin)
+	}
+	Filters.RUnlock()
+	sort.Strings(s.Filters)
+	return s
+}
+
+// Load the rules from the snapshot, if it exists and is still valid.
+func (c *ConfigT) loadSnapshot() bool {
+	if _, err := os.Stat(CompiledPath); os.IsNotExist(err) {
+		return false
+	}
+
+	s, err := ReadSnapshot(CompiledPath)
+	if err != nil {
+		msg.Warn(fmt.Errorf("not using the compiled list: %v", err))
+		return false
+	}
+	if err := c.validSnapshot(s); err != nil {
+		msg.Warn(fmt.Errorf("not using the compiled list: %v; run trackwall compile to update it", err))
+		return false
+	}
+
+	msg.Info(fmt.Sprintf("reading compiled list from %v (built %v)",
+		CompiledPath, s.Built.Format("2006-01-02 15:04")), Config.Verbose)
+	s.apply()
+	return true
+}
+
+// Check if the snapshot is still valid for the current configuration and
+// lists.
+func (c *ConfigT) validSnapshot(s *Snapshot) error {
+	if s.ConfigHash != c.rulesHash() {
+		return errors.New("the configuration changed")
+	}
+	for _, src := range s.Sources {
+		state, expired, err := sourceState(src.URL)
+		switch {
+		case err != nil:
+			return err
+		case expired:
+			return fmt.Errorf("%v expired", src.URL)
+		case state != src.State:
+			return fmt.Errorf("%v changed", src.URL)
+		}
+	}
+	return nil
+}
+
+// Add all the rules from the snapshot.
+func (s *Snapshot) apply() {
+	hosts := reading.hosts
+	hosts.Lock()
+	for k, v := range s.Hosts {
+		hosts.m[k] = v
+	}
+	hosts.gen++
+	hosts.Unlock()
+
+	reading.regexps.Add(s.Regexps...)
+
+	for kind, rules := range s.Origins {
+		for rule, o := range rules {
+			reading.origins.Set(kind, o, rule)
+		}
+	}
+
+	// Don't use Add(), as the scripts are already set on the hosts.
+	surrogates := reading.surrogates
+	surrogates.Lock()
+	for _, sur := range s.Surrogates {
+		surrogates.l = append(surrogates.l, SurrogateEntry{regexp.MustCompile(sur[0]), sur[1]})
+	}
+	surrogates.Unlock()
+
+	for _, text := range s.Filters {
+		if f, _, reason := parseFilter(text); reason == "" {
+			f.Origin = s.origin(originFilter, text)
+			reading.filters.Add(f)
+		}
+	}
+
+	loadedSources = nil
+	for _, src := range s.Sources {
+		loadedSources = append(loadedSources, src.URL)
+	}
+}
+
+// Info writes information about the snapshot.
+func (s *Snapshot) Info(w io.Writer) {
+	fmt.Fprintf(w, "version:     %v\n", s.Version)
+	fmt.Fprintf(w, "built:       %v\n", s.Built.Format(time.RFC3339))
+	fmt.Fprintf(w, "config:      %v\n", s.ConfigHash)
+	fmt.Fprintf(w, "hosts:       %v\n", len(s.Hosts))
+	fmt.Fprintf(w, "regexps:     %v\n", len(s.Regexps))
+	fmt.Fprintf(w, "surrogates:  %v\n", len(s.Surrogates))
+	fmt.Fprintf(w, "filters:     %v\n", len(s.Filters))
+	fmt.Fprintf(w, "sources:\n")
+	for _, src := range s.Sources {
+		fmt.Fprintf(w, "  %v\n    sha256 %v\n", src.URL, src.Sum)
+	}
+}
+
+// Get a hash of all the options that affect the ruleset.
+func (c *ConfigT) rulesHash() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d %q %q %q %q %q %q %q %q %q %q",
+		snapshotVersion,
+		c.Hostlists, c.Unhostlists, c.Regexplists, c.Unregexplists,
+		c.Hosts, c.Unhosts, c.Regexps, c.Unregexps, c.Surrogates, c.IncludeRules)))
+	return hex.EncodeToString(sum[:])
+}
+
+// RulesHash gets a hash of the configuration, the state of the lists that the
+// current rules were read from, and the rules from AddedPath. Data that depends
+// on the rules, such as the saved DNS cache, can't be used if this changed.
+//
+// The response policy zones aren't included, as they're refreshed on their
+// own.
+func (c *ConfigT) RulesHash() string {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	h := sha256.New()
+	fmt.Fprintln(h, c.rulesHash())
+	for _, url := range loadedSources {
+		state, _, err := sourceState(url)
+		if err != nil {
+			state = err.Error()
+		}
+		fmt.Fprintf(h, "%q %q\n", url, state)
+	}
+	added.Lock()
+	fmt.Fprintf(h, "%q\n", added.rules)
+	added.Unlock()
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get the path of the local file or cached copy of a list.
+func sourcePath(url string) string {
+	if strings.HasPrefix(url, "file://") {
+		return url[7:]
+	}
+	return cachePath(url)
+}
+
+// Get the modification time and size of a list, and if the cached copy of a
+// remote list expired.
+func sourceState(url string) (state string, expired bool, err error) {
+	stat, err := os.Stat(sourcePath(url))
+	if err != nil {
+		return "", false, err
+	}
+
+	if !strings.HasPrefix(url, "file://") {
+		expired = time.Now().After(stat.ModTime().Add(time.Duration(Config.CacheHosts) * time.Second))
+	}
+	return fmt.Sprintf("%d %d", stat.ModTime().UnixNano(), stat.Size()), expired, nil
+}
+
+// Write the snapshot to path.
+func writeSnapshot(path string, s *Snapshot) error {
+	var body snapshotWriter
+	body.str(s.ConfigHash)
+	body.uint(uint64(s.Built.Unix()))
+
+	body.uint(uint64(len(s.Sources)))
+	for _, src := range s.Sources {
+		body.str(src.URL)
+		body.str(src.State)
+		body.str(src.Sum)
+	}
+
+	// Most rules come from a few lists, so store the sources only once.
+	originIdx := map[string]uint64{"": 0}
+	originList := []string{""}
+	for _, rules := range s.Origins {
+		for _, o := range rules {
+			if _, has := originIdx[o.Source]; !has {
+				originIdx[o.Source] = uint64(len(originList))
+				originList = append(originList, o.Source)
+			}
+		}
+	}
+	sort.Strings(originList[1:])
+	for i, src := range originList {
+		originIdx[src] = uint64(i)
+	}
+	body.uint(uint64(len(originList) - 1))
+	for _, src := range originList[1:] {
+		body.str(src)
+	}
+	origin := func(kind, rule string) {
+		o := s.origin(kind, rule)
+		body.uint(originIdx[o.Source])
+		body.uint(uint64(o.Line))
+	}
+
+	hosts := make([]string, 0, len(s.Hosts))
+	for h := range s.Hosts {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	body.uint(uint64(len(hosts)))
+	for _, h := range hosts {
+		body.str(h)
+		body.str(s.Hosts[h])
+		origin(OriginHost, h)
+	}
+
+	body.uint(uint64(len(s.Regexps)))
+	for _, re := range s.Regexps {
+		body.str(re)
+		origin(OriginRegexp, re)
+	}
+
+	body.uint(uint64(len(s.Surrogates)))
+	for _, sur := range s.Surrogates {
+		body.str(sur[0])
+		body.str(sur[1])
+	}
+
+	body.uint(uint64(len(s.Filters)))
+	for _, f := range s.Filters {
+		body.str(f)
+		origin(originFilter, f)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(snapshotMagic)
+	_ = binary.Write(&out, binary.BigEndian, uint32(snapshotVersion))
+	sum := sha256.Sum256(body.Bytes())
+	out.Write(sum[:])
+	out.Write(body.Bytes())
+
+	return WriteAtomic(path, out.Bytes())
+}
+
+// ReadSnapshot reads the snapshot at path, and checks that the version and
+// checksum are correct.
+func ReadSnapshot(path string) (*Snapshot, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = fp.Close() }()
+
+	stat, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() < int64(snapshotHeader) {
+		return nil, errors.New("not a snapshot")
+	}
+
+	data, err := syscall.Mmap(int(fp.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mmap: %v", err)
+	}
+	defer func() { _ = syscall.Munmap(data) }()
+
+	return decodeSnapshot(data)
+}
+
+func decodeSnapshot(data []byte) (*Snapshot, error) {
+	if len(data) < snapshotHeader || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, errors.New("not a snapshot")
+	}
+
+	s := &Snapshot{Version: binary.BigEndian.Uint32(data[len(snapshotMagic):])}
+	if s.Version != snapshotVersion {
+		return nil, fmt.Errorf("snapshot version %d is not supported (expected %d)", s.Version, snapshotVersion)
+	}
+
+	body := data[snapshotHeader:]
+	sum := sha256.Sum256(body)
+	if !bytes.Equal(sum[:], data[snapshotHeader-sha256.Size:snapshotHeader]) {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	r := snapshotReader{b: body}
+	s.ConfigHash = r.str()
+	s.Built = time.Unix(int64(r.uint()), 0)
+
+	for i := r.count(); i > 0; i-- {
+		s.Sources = append(s.Sources, Source{URL: r.str(), State: r.str(), Sum: r.str()})
+	}
+
+	originList := []string{""}
+	for i := r.count(); i > 0; i-- {
+		originList = append(originList, r.str())
+	}
+	origin := func(kind, rule string) {
+		i, line := r.uint(), r.uint()
+		if i >= uint64(len(originList)) {
+			r.err = errors.New("invalid origin")
+			return
+		}
+		s.setOrigin(kind, rule, Origin{Source: originList[i], Line: int(line)})
+	}
+
+	n := r.count()
+	s.Hosts = make(map[string]string, n)
+	for ; n > 0; n-- {
+		h := r.str()
+		s.Hosts[h] = r.str()
+		origin(OriginHost, h)
+	}
+
+	for i := r.count(); i > 0; i-- {
+		re := r.str()
+		s.Regexps = append(s.Regexps, re)
+		origin(OriginRegexp, re)
+	}
+	for i := r.count(); i > 0; i-- {
+		s.Surrogates = append(s.Surrogates, []string{r.str(), r.str()})
+	}
+	for i := r.count(); i > 0; i-- {
+		f := r.str()
+		s.Filters = append(s.Filters, f)
+		origin(originFilter, f)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.b) > 0 {
+		return nil, errors.New("trailing data")
+	}
+	return s, nil
+}
+
+type snapshotWriter struct{ bytes.Buffer }
+
+func (w *snapshotWriter) uint(n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.Write(b[:binary.PutUvarint(b[:], n)])
+}
+
+func (w *snapshotWriter) str(s string) {
+	w.uint(uint64(len(s)))
+	w.WriteString(s)
+}
+
+type snapshotReader struct {
+	b   []byte
+	err error
+}
+
+var errTruncated = errors.New("truncated snapshot")
+
+func (r *snapshotReader) uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	n, l := binary.Uvarint(r.b)
+	if l <= 0 {
+		r.err = errTruncated
+		return 0
+	}
+	r.b = r.b[l:]
+	return n
+}
+
+// Read a count; this is never larger than the remaining data, so it's safe to
+// use for allocations.
+func (r *snapshotReader) count() int {
+	n := r.uint()
+	if n > uint64(len(r.b)) {
+		r.err = errTruncated
+		return 0
+	}
+	return int(n)
+}
+
+func (r *snapshotReader) str() string {
+	n := r.uint()
+	if r.err != nil || n > uint64(len(r.b)) {
+		r.err = errTruncated
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
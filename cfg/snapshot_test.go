@@ -0,0 +1,165 @@
+package cfg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"arp242.net/trackwall/tt"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackwall-snapshot")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "compiled")
+
+	s := &Snapshot{
+		Version:    snapshotVersion,
+		ConfigHash: Config.rulesHash(),
+		Built:      time.Unix(1536000000, 0),
+		Sources:    []Source{{URL: "file:///hosts", State: "1 2", Sum: "abc"}},
+		Hosts:      map[string]string{"example.com": "", "ads.example.net": "var x;"},
+		Regexps:    []string{`^ad[0-9]+\.`},
+		Surrogates: [][]string{{`^ads\.`, "var x;"}},
+		Filters:    []string{"@@||ok.example.com^", "||mx.example.com^$dnstype=MX"},
+		Origins: map[string]map[string]Origin{
+			OriginHost:   {"example.com": {"file:///hosts", 3}, "ads.example.net": {"config", 0}},
+			originFilter: {"@@||ok.example.com^": {"file:///hosts", 12}},
+		},
+	}
+	tt.Err(t, writeSnapshot(path, s))
+
+	out, err := ReadSnapshot(path)
+	tt.Err(t, err)
+	tt.Eq(t, "snapshot", s, out)
+
+	data, err := ioutil.ReadFile(path)
+	tt.Err(t, err)
+
+	cases := []struct {
+		name   string
+		modify func([]byte) []byte
+	}{
+		{"magic", func(b []byte) []byte { b[0] = 'X'; return b }},
+		{"version", func(b []byte) []byte { b[len(snapshotMagic)+3] = 9; return b }},
+		{"checksum", func(b []byte) []byte { b[len(b)-1] ^= 1; return b }},
+		{"truncated", func(b []byte) []byte { return b[:len(b)-5] }},
+		{"short", func(b []byte) []byte { return b[:10] }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := decodeSnapshot(tc.modify(append([]byte{}, data...)))
+			if err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestSnapshotApply(t *testing.T) {
+	defer Origins.Purge()
+	defer Hosts.Purge()
+	defer Regexps.Purge()
+	defer Surrogates.Purge()
+	defer Filters.Purge()
+
+	s := &Snapshot{
+		Hosts:      map[string]string{"example.com": "", "ads.example.net": "var x;"},
+		Regexps:    []string{`^ad[0-9]+\.`},
+		Surrogates: [][]string{{`^ads\.`, "var x;"}},
+		Filters:    []string{"@@||ok.example.com^"},
+		Origins: map[string]map[string]Origin{
+			OriginRegexp: {`^ad[0-9]+\.`: {"file:///re", 2}},
+			originFilter: {"@@||ok.example.com^": {"config", 0}},
+		},
+	}
+	s.apply()
+
+	tt.Eq(t, "hosts", 2, Hosts.Len())
+	tt.Eq(t, "regexp", true, Regexps.Match("ad1.example.org"))
+	script, _ := Surrogates.Find("ads.example.org")
+	tt.Eq(t, "surrogate", "var x;", script)
+	script, _ = Surrogates.Find("ads.example.net")
+	tt.Eq(t, "host surrogate", "var x;", script)
+	tt.Eq(t, "filters", 1, Filters.Len())
+
+	o, _ := Origins.Get(OriginRegexp, `^ad[0-9]+\.`)
+	tt.Eq(t, "regexp origin", Origin{"file:///re", 2}, o)
+	tt.Eq(t, "filter origin", Origin{"config", 0}, Filters.Match("ok.example.com", 1, nil).Origin)
+}
+
+func TestValidSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackwall-snapshot")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	list := filepath.Join(dir, "hosts")
+	tt.Err(t, ioutil.WriteFile(list, []byte("example.com\n"), 0644))
+
+	c := ConfigT{Hostlists: [][]string{{"plain", "file://" + list}}}
+	state, _, err := sourceState("file://" + list)
+	tt.Err(t, err)
+	s := &Snapshot{ConfigHash: c.rulesHash(), Sources: []Source{{URL: "file://" + list, State: state}}}
+	tt.Err(t, c.validSnapshot(s))
+
+	c.Hosts = []string{"example.net"}
+	if c.validSnapshot(s) == nil {
+		t.Error("no error after changing config")
+	}
+	c.Hosts = nil
+
+	tt.Err(t, ioutil.WriteFile(list, []byte("example.com\nexample.net\n"), 0644))
+	if c.validSnapshot(s) == nil {
+		t.Error("no error after changing list")
+	}
+
+	tt.Err(t, os.Remove(list))
+	if c.validSnapshot(s) == nil {
+		t.Error("no error after removing list")
+	}
+}
+
+func TestSnapshotSubdomains(t *testing.T) {
+	defer Origins.Purge()
+	defer Hosts.Purge()
+
+	Hosts.Add("example.com")
+	Origins.Set(OriginHost, Origin{"file:///hosts", 1}, "example.com")
+	Hosts.Add("ads.example.com")
+	Hosts.SetScript("ads.example.com", "var x;")
+	Origins.Set(OriginHost, Origin{"file:///hosts", 2}, "ads.example.com")
+
+	s := Config.snapshot()
+	tt.Eq(t, "hosts", map[string]string{"example.com": "", "ads.example.com": "var x;"}, s.Hosts)
+	tt.Eq(t, "origin", Origin{"file:///hosts", 2}, s.origin(OriginHost, "ads.example.com"))
+
+	Hosts.Purge()
+	Origins.Purge()
+	s.apply()
+	script, _ := Hosts.Get("ads.example.com")
+	tt.Eq(t, "surrogate", "var x;", script)
+	o, _ := Origins.Get(OriginHost, "ads.example.com")
+	tt.Eq(t, "applied origin", Origin{"file:///hosts", 2}, o)
+}
+
+func TestSnapshotAdded(t *testing.T) {
+	defer closeAdded()
+	defer Origins.Purge()
+	defer Hosts.Purge()
+	defer func() { Config.Hosts = nil }()
+
+	// The rules from config.managed aren't in the config hash, so they must not
+	// end up in the snapshot either.
+	Config.Hosts = []string{"b.example.com", "c.example.com"}
+	added.rules = rulesT{Hosts: []string{"a.example.com"}, Unhosts: []string{"b.example.com"}}
+	s, err := Config.compile()
+	tt.Err(t, err)
+	tt.Eq(t, "hosts", map[string]string{"b.example.com": "", "c.example.com": ""}, s.Hosts)
+
+	Hosts.Purge()
+	s.apply()
+	readAdded()
+	tt.Eq(t, "applied", []string{"a.example.com", "c.example.com"}, Hosts.List(""))
+}
@@ -75,15 +75,15 @@ func (l *SurrogateList) Add(scripts ...[]string) {
 		sur = strings.Replace(sur, "@@", "function(){}", -1)
 
 		re := regexp.MustCompile(reg)
-		Surrogates.l = append(Surrogates.l, SurrogateEntry{re, sur})
+		l.l = append(l.l, SurrogateEntry{re, sur})
 
 		// Add to the hosts; bit more memory/expensive now, but saves a lot of
 		// regexp checks later on.
 		found := 0
-		for host := range Hosts.m {
+		for host := range reading.hosts.m {
 			if re.MatchString(host) {
 				found++
-				Hosts.SetScript(host, sur)
+				reading.hosts.SetScript(host, sur)
 			}
 		}
 
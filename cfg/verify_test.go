@@ -0,0 +1,140 @@
+package cfg
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"arp242.net/trackwall/tt"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Make a minisign public key and a signature for data.
+func minisign(t *testing.T, alg string, data []byte) (string, []byte) {
+	pk, sk, err := ed25519.GenerateKey(rand.Reader)
+	tt.Err(t, err)
+
+	id := []byte("\x01\x02\x03\x04\x05\x06\x07\x08")
+	key := base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), id...), pk...))
+
+	sig := ed25519.Sign(sk, data)
+	comment := "timestamp:1536000000"
+	global := ed25519.Sign(sk, append(append([]byte{}, sig...), comment...))
+
+	return key, []byte(fmt.Sprintf("untrusted comment: signature\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(append(append([]byte(alg), id...), sig...)),
+		comment,
+		base64.StdEncoding.EncodeToString(global)))
+}
+
+func TestVerifyList(t *testing.T) {
+	data := []byte("example.com\n")
+	key, sig := minisign(t, "Ed", data)
+	otherKey, _ := minisign(t, "Ed", data)
+	_, prehashed := minisign(t, "ED", data)
+
+	cases := []struct {
+		verify      string
+		data        []byte
+		sig         []byte
+		expectedErr string
+	}{
+		{"", data, nil, ""},
+		{"sha256:ed4b92a2ed83b9a3aa6e1ceaa0d7bdd9ad0ac1aee0f9ae1e2dfa5e3d35d4a2e8", data, nil, "checksum mismatch"},
+		{"minisign:" + key, data, sig, ""},
+		{"minisign:" + key, []byte("example.net\n"), sig, "invalid minisign signature"},
+		{"minisign:" + otherKey, data, sig, "invalid minisign signature"},
+		{"minisign:" + key, data, prehashed, "prehashed"},
+		{"minisign:" + key, data, []byte("nope"), "too short"},
+		{"minisign:" + key, data, []byte(strings.Replace(string(sig), "1536000000", "1536000001", 1)),
+			"trusted comment"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			err := verifyList(tc.verify, tc.data, tc.sig)
+			if tc.expectedErr == "" {
+				tt.Err(t, err)
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("wrong error\nout:      %v\nexpected: %v", err, tc.expectedErr)
+			}
+		})
+	}
+
+	// Correct checksum
+	tt.Err(t, verifyList("sha256:391196688aa55d3321deffa736f8d103b4813470952b748e9c2c9deb17fa60f5", data, nil))
+}
+
+func TestParseVerify(t *testing.T) {
+	key, _ := minisign(t, "Ed", nil)
+	cases := []struct {
+		in       string
+		expected bool
+	}{
+		{"sha256:8ad8757baa8564dc136c1e07507f4a98919c1a3bdd8b2e5ac0b77ea29c2a6be5", true},
+		{"sha256:8AD8757BAA8564DC136C1E07507F4A98919C1A3BDD8B2E5AC0B77EA29C2A6BE5", true},
+		{"sha256:8ad8", false},
+		{"sha256:xyz", false},
+		{"minisign:" + key, true},
+		{"minisign:RWQ", false},
+		{"md5:d41d8cd98f00b204e9800998ecf8427e", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			tt.Eq(t, "valid", tc.expected, parseVerify(tc.in) == nil)
+		})
+	}
+}
+
+func TestDownload(t *testing.T) {
+	data := []byte("example.com\n")
+	key, sig := minisign(t, "Ed", data)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/list":
+			_, _ = w.Write(data)
+		case "/list.minisig":
+			_, _ = w.Write(sig)
+		case "/tampered":
+			_, _ = w.Write([]byte("example.net\n"))
+		case "/tampered.minisig":
+			_, _ = w.Write(sig)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "trackwall-download")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	cache := filepath.Join(dir, "list")
+
+	tt.Err(t, download(srv.URL+"/list", cache, "minisign:"+key))
+	tt.Err(t, verifyFile(cache, "minisign:"+key, cache+".minisig"))
+
+	// The last good copy is kept.
+	err = download(srv.URL+"/tampered", cache, "minisign:"+key)
+	if err == nil {
+		t.Fatal("no error for tampered list")
+	}
+	out, err := ioutil.ReadFile(cache)
+	tt.Err(t, err)
+	tt.Eq(t, "cache", string(data), string(out))
+
+	err = download(srv.URL+"/missing", cache, "")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("wrong error: %v", err)
+	}
+}
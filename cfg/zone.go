@@ -0,0 +1,196 @@
+package cfg
+
+import (
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// Trigger is a response policy zone trigger.
+type Trigger struct {
+	Name     string // Relative to the zone origin, e.g. "*.example.com".
+	Passthru bool   // rpz-passthru. for @@ exceptions; NXDOMAIN otherwise.
+}
+
+// Triggers returns the effective blocklist as RPZ triggers (relative to the
+// zone origin): every host as both "host" and "*.host", and the regexps which
+// can be expressed as a hostname.
+//
+// Hosts that are already blocked by a parent domain are left out. Names that
+// are excepted by a @@ filter are left out as well, and get a passthru trigger
+// if a parent domain is still blocked by a wildcard. The number of regexps that
+// can't be expressed is returned as skipped.
+func Triggers() (triggers []Trigger, skipped int) {
+	names := make(map[string]struct{})
+
+	Hosts.RLock()
+	for host := range compactHosts(Hosts.m) {
+		names[host] = struct{}{}
+		names["*."+host] = struct{}{}
+	}
+	Hosts.RUnlock()
+
+	Regexps.RLock()
+	for _, re := range Regexps.l {
+		t := regexpTriggers(re.String())
+		if t == nil {
+			skipped++
+		}
+		for _, n := range t {
+			names[n] = struct{}{}
+		}
+	}
+	Regexps.RUnlock()
+
+	pass, n := exceptionTriggers()
+	skipped += n
+	for n := range names {
+		if excepted(n, pass) {
+			delete(names, n)
+		}
+	}
+
+	triggers = make([]Trigger, 0, len(names))
+	for n := range names {
+		triggers = append(triggers, Trigger{Name: n})
+	}
+	for p := range pass {
+		if wildcardParent(p, names) {
+			triggers = append(triggers, Trigger{Name: p, Passthru: true})
+		}
+	}
+	sort.Slice(triggers, func(i, j int) bool { return triggers[i].Name < triggers[j].Name })
+	return triggers, skipped
+}
+
+// Get the triggers for the @@ exceptions which apply to everyone and every
+// query type. The number of regexp exceptions that can't be expressed is
+// returned as skipped.
+func exceptionTriggers() (pass map[string]struct{}, skipped int) {
+	pass = make(map[string]struct{})
+	unconditional := func(f *Filter) bool {
+		return f.Exception && len(f.Clients) == 0 && len(f.NoClients) == 0 &&
+			len(f.Types) == 0 && len(f.NoTypes) == 0 && len(f.DenyAllow) == 0
+	}
+
+	Filters.RLock()
+	defer Filters.RUnlock()
+	for name, filters := range Filters.names {
+		for _, f := range filters {
+			if name == "" || !unconditional(f) {
+				continue
+			}
+			pass[name] = struct{}{}
+			if !f.Exact {
+				pass["*."+name] = struct{}{}
+			}
+		}
+	}
+	for _, f := range Filters.regexps {
+		if !unconditional(f) {
+			continue
+		}
+		t := regexpTriggers(f.Regexp.String())
+		if t == nil {
+			skipped++
+		}
+		for _, n := range t {
+			pass[n] = struct{}{}
+		}
+	}
+	return pass, skipped
+}
+
+// Report if the trigger t is excepted by one of the pass triggers: either the
+// same trigger, or a wildcard for a parent domain.
+func excepted(t string, pass map[string]struct{}) bool {
+	if _, ok := pass[t]; ok {
+		return true
+	}
+	return wildcardParent(t, pass)
+}
+
+// Report if there is a wildcard trigger for a parent domain of the trigger t in
+// triggers.
+func wildcardParent(t string, triggers map[string]struct{}) bool {
+	name := strings.TrimPrefix(t, "*.")
+	for i := 0; i < len(name); i++ {
+		if name[i] != '.' {
+			continue
+		}
+		if _, ok := triggers["*."+name[i+1:]]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the RPZ triggers for a regexp, or nil if it can't be expressed as a
+// trigger. The supported forms are:
+//
+//	^example\.com$        example.com
+//	\.example\.com$       *.example.com
+//	(^|\.)example\.com$   example.com and *.example.com
+func regexpTriggers(re string) []string {
+	r, err := syntax.Parse(re, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	r = r.Simplify()
+	if r.Op != syntax.OpConcat || r.Sub[len(r.Sub)-1].Op != syntax.OpEndText {
+		return nil
+	}
+
+	switch len(r.Sub) {
+	case 2:
+		if r.Sub[0].Op != syntax.OpLiteral {
+			return nil
+		}
+		lit := strings.ToLower(string(r.Sub[0].Rune))
+		if !strings.HasPrefix(lit, ".") || !ValidHost(lit[1:]) {
+			return nil
+		}
+		return []string{"*" + lit}
+	case 3:
+		if r.Sub[1].Op != syntax.OpLiteral {
+			return nil
+		}
+		name := strings.ToLower(string(r.Sub[1].Rune))
+		if !ValidHost(name) {
+			return nil
+		}
+
+		anchor := r.Sub[0]
+		if anchor.Op == syntax.OpCapture {
+			anchor = anchor.Sub[0]
+		}
+		switch {
+		case anchor.Op == syntax.OpBeginText:
+			return []string{name}
+		case anchor.Op == syntax.OpAlternate && len(anchor.Sub) == 2 &&
+			anchor.Sub[0].Op == syntax.OpBeginText &&
+			anchor.Sub[1].Op == syntax.OpLiteral && string(anchor.Sub[1].Rune) == ".":
+			return []string{name, "*." + name}
+		}
+	}
+	return nil
+}
+
+// Remove all hosts that are subdomains of another host in the list; for
+// example "s8.addthis.com" is redundant if "addthis.com" is also in the list.
+func compactHosts(hosts map[string]string) map[string]string {
+	compact := make(map[string]string, len(hosts))
+outer:
+	for name, script := range hosts {
+		for i := 0; i < len(name); i++ {
+			if name[i] != '.' {
+				continue
+			}
+			if _, has := hosts[name[i+1:]]; has {
+				continue outer
+			}
+		}
+		compact[name] = script
+	}
+	return compact
+}
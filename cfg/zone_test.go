@@ -0,0 +1,106 @@
+package cfg
+
+import (
+	"testing"
+
+	"arp242.net/trackwall/tt"
+)
+
+func TestRegexpTriggers(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected []string
+	}{
+		{`^example\.com$`, []string{"example.com"}},
+		{`^Example\.COM$`, []string{"example.com"}},
+		{`\.example\.com$`, []string{"*.example.com"}},
+		{`(^|\.)example\.com$`, []string{"example.com", "*.example.com"}},
+		{`(?:^|\.)example\.com$`, []string{"example.com", "*.example.com"}},
+
+		{`example\.com$`, nil},
+		{`^example\.com`, nil},
+		{`^example.com$`, nil},
+		{`^ad(s|srv)?[0-9]+?\.`, nil},
+		{`(^|x)example\.com$`, nil},
+		{`^$`, nil},
+		{`(`, nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			tt.Eq(t, "triggers", tc.expected, regexpTriggers(tc.in))
+		})
+	}
+}
+
+func TestTriggers(t *testing.T) {
+	defer Hosts.Purge()
+	defer Regexps.Purge()
+
+	Hosts.Add("example.com")
+	Hosts.Add("ads.example.com")
+	Hosts.Add("tracker.example.net")
+	Regexps.Add(`^ads\.example\.org$`, `^ad[0-9]+\.`)
+
+	triggers, skipped := Triggers()
+	tt.Eq(t, "triggers", []Trigger{{"*.example.com", false}, {"*.tracker.example.net", false},
+		{"ads.example.org", false}, {"example.com", false}, {"tracker.example.net", false}}, triggers)
+	tt.Eq(t, "skipped", 1, skipped)
+}
+
+func TestTriggersExceptions(t *testing.T) {
+	defer Hosts.Purge()
+	defer Regexps.Purge()
+	defer Filters.Purge()
+
+	Hosts.Add("example.com", "tracker.example.net", "ads.example.org")
+	for _, rule := range []string{
+		"@@||good.example.com^",
+		"@@|exact.example.com^",
+		"@@||tracker.example.net^",
+		"@@||ads.example.org^$client=192.168.1.2",
+		`@@/^ok\.example\.com$/`,
+		`@@/^x+\.example\.com$/`,
+	} {
+		f, _, reason := parseFilter(rule)
+		tt.Eq(t, "reason", "", reason)
+		Filters.Add(f)
+	}
+
+	triggers, skipped := Triggers()
+	tt.Eq(t, "triggers", []Trigger{
+		{"*.ads.example.org", false},
+		{"*.example.com", false},
+		{"*.good.example.com", true},
+		{"ads.example.org", false},
+		{"exact.example.com", true},
+		{"example.com", false},
+		{"good.example.com", true},
+		{"ok.example.com", true},
+	}, triggers)
+	tt.Eq(t, "skipped", 1, skipped)
+}
+
+func TestGeneration(t *testing.T) {
+	defer Hosts.Purge()
+
+	gen := Hosts.Generation()
+	Hosts.Add("example.com")
+	Hosts.Remove("example.com")
+	tt.Eq(t, "generation", gen+2, Hosts.Generation())
+}
+
+func TestCompactHosts(t *testing.T) {
+	out := compactHosts(map[string]string{
+		"example.com":         "",
+		"ads.example.com":     "",
+		"a.b.ads.example.com": "",
+		"example.net":         "x",
+		"notexample.com":      "",
+	})
+	tt.Eq(t, "compact", map[string]string{
+		"example.com":    "",
+		"example.net":    "x",
+		"notexample.com": "",
+	}, out)
+}
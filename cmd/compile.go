@@ -7,6 +7,7 @@ import (
 	"os"
 
 	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/msg"
 	"github.com/spf13/cobra"
 )
 
@@ -14,35 +15,55 @@ var compileCmd = &cobra.Command{
 	Use:   "compile",
 	Short: "Compile the host list",
 	Long: `
-Compile all the hosts (as added with hostlist, host, unhostlist, and unhost in
-the configuration file) to one "compiled" file with duplicates and redundant
-entries removed. trackwall doesn't do this automatically on startup since this
-is a comparatively expensive operation.
+Compile all the rules (as added with hostlist, host, unhostlist, unhost, regexp,
+surrogate, etc. in the configuration file) to one "compiled" snapshot with
+duplicates removed. trackwall doesn't do this automatically on startup since
+this is a comparatively expensive operation.
 
 You don't strictly need to do this, but it will make the program start up and
 run a bit faster.
 
-The result is written to /compiled-hosts in the chroot directory and is used
-automatically if its mtime is not older than cache-hosts. If it's older
-trackwall will show a warning and ignore the file.`,
+The result is written to /cache/compiled in the chroot directory and is used
+automatically as long as the configuration and lists haven't changed; if they
+did, or if a remote list's cache expired, trackwall will show a warning and
+ignore the file.
+
+Use --show to show information about the current snapshot, such as which lists
+it was compiled from.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if compileShow {
+			showCompiled()
+			return
+		}
 		compile()
 	},
 }
 
+var compileShow bool
+
 func init() {
 	RootCmd.AddCommand(compileCmd)
+	compileCmd.Flags().BoolVarP(&compileShow, "show", "s", false,
+		"Show information about the compiled snapshot")
 }
 
 func compile() {
 	chroot()
 	DropPrivs()
 
-	_ = os.Remove("/cache/compiled")
-	cfg.Config.ReadHosts()
+	_ = os.Remove(cfg.CompiledPath)
 	cfg.Config.Compile()
 }
 
+func showCompiled() {
+	chroot()
+	DropPrivs()
+
+	s, err := cfg.ReadSnapshot(cfg.CompiledPath)
+	msg.Fatal(err)
+	s.Info(os.Stdout)
+}
+
 // The MIT License (MIT)
 //
 // Copyright © 2016-2017 Martin Tournoij
@@ -0,0 +1,70 @@
+// Copyright © 2016-2017 Martin Tournoij <martin@arp242.net>
+// See the bottom of this file for the full copyright notice.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/msg"
+	"arp242.net/trackwall/srvhttp"
+	"github.com/spf13/cobra"
+)
+
+var (
+	controlCertCmd = &cobra.Command{
+		Use:   "control-cert <name>",
+		Short: "Issue a client certificate for the control listener",
+		Long: `
+Issue a client certificate for control-listen with control-tls enabled, for
+example to use the API with curl or a browser. The certificate and key are
+written to <name>.crt and <name>.key in the current directory.
+
+This needs access to the root key. The trackwall CLI itself doesn't need a
+certificate, as it makes one on the fly.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			certPEM, keyPEM, err := srvhttp.IssueClientCert(
+				cfg.Config.ChrootDir(cfg.Config.RootCert), cfg.Config.ChrootDir(cfg.Config.RootKey),
+				args[0], controlCertValidity)
+			msg.Fatal(err)
+
+			msg.Fatal(ioutil.WriteFile(args[0]+".crt", certPEM, 0644))
+			msg.Fatal(ioutil.WriteFile(args[0]+".key", keyPEM, 0600))
+			fmt.Printf("wrote %[1]v.crt and %[1]v.key\n", args[0])
+		},
+	}
+
+	controlCertValidity time.Duration
+)
+
+func init() {
+	RootCmd.AddCommand(controlCertCmd)
+	controlCertCmd.Flags().DurationVar(&controlCertValidity, "validity", 365*24*time.Hour,
+		"How long the certificate is valid")
+}
+
+// The MIT License (MIT)
+//
+// Copyright © 2016-2017 Martin Tournoij
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// The software is provided "as is", without warranty of any kind, express or
+// implied, including but not limited to the warranties of merchantability,
+// fitness for a particular purpose and noninfringement. In no event shall the
+// authors or copyright holders be liable for any claim, damages or other
+// liability, whether in an action of contract, tort or otherwise, arising
+// from, out of or in connection with the software or the use or other dealings
+// in the software.
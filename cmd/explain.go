@@ -0,0 +1,64 @@
+// Copyright © 2016-2017 Martin Tournoij <martin@arp242.net>
+// See the bottom of this file for the full copyright notice.
+
+package cmd
+
+import (
+	"strings"
+
+	"arp242.net/trackwall/srvctl"
+	"github.com/spf13/cobra"
+)
+
+var (
+	explainCmd = &cobra.Command{
+		Use:   "explain <name>",
+		Short: "Show why a name is blocked",
+		Long: `
+Show why a name is blocked (or isn't) by the running trackwall instance: the
+override, the matching host, regexp, filter, or response policy zone and the
+list and line it was loaded from, the surrogate script, the cached response,
+and the response that will be sent for A and AAAA queries.
+
+Filters can apply to specific clients; use --client to explain the response for
+a client other than this machine.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			send := "explain " + strings.ToLower(args[0])
+			if explainClient != "" {
+				send += " " + explainClient
+			}
+			srvctl.Write(send)
+		},
+	}
+
+	explainClient string
+)
+
+func init() {
+	RootCmd.AddCommand(explainCmd)
+	explainCmd.Flags().StringVar(&explainClient, "client", "",
+		"Explain the response for this client IP")
+}
+
+// The MIT License (MIT)
+//
+// Copyright © 2016-2017 Martin Tournoij
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// The software is provided "as is", without warranty of any kind, express or
+// implied, including but not limited to the warranties of merchantability,
+// fitness for a particular purpose and noninfringement. In no event shall the
+// authors or copyright holders be liable for any claim, damages or other
+// liability, whether in an action of contract, tort or otherwise, arising
+// from, out of or in connection with the software or the use or other dealings
+// in the software.
@@ -27,6 +27,17 @@ func sendCmd(cmd *cobra.Command, args []string) {
 	srvctl.Write(send)
 }
 
+// Send a command which edits the rules; --persist is sent as the first
+// argument.
+func sendEditCmd(persist *bool) func(*cobra.Command, []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if *persist {
+			args = append([]string{"--persist"}, args...)
+		}
+		sendCmd(cmd, args)
+	}
+}
+
 // Setup chroot() from the information in cfg.Config
 func chroot() {
 	msg.Info(fmt.Sprintf("chrooting to %v", cfg.Config.Chroot), cfg.Config.Verbose)
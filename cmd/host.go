@@ -9,25 +9,36 @@ var (
 	hostCmd = &cobra.Command{
 		Use:   "host",
 		Short: "Control host list",
+		Long: `
+Add or remove hosts in the running trackwall instance. The change is applied
+immediately, but lost on restart unless --persist is used; this saves it to
+the config.managed file sourced in the configuration, which is applied after all
+other rules.
+
+Removing a host with --persist unblocks it even if it's in a hostlist.`,
 	}
 	hostAddCmd = &cobra.Command{
 		Use:   "add",
 		Short: "Add new hosts",
-		Run:   sendCmd,
+		Run:   sendEditCmd(&hostPersist),
 		Args:  cobra.MinimumNArgs(1),
 	}
 	hostRmCmd = &cobra.Command{
 		Use:   "rm",
 		Short: "Remove hosts",
-		Run:   sendCmd,
+		Run:   sendEditCmd(&hostPersist),
 		Args:  cobra.MinimumNArgs(1),
 	}
+
+	hostPersist bool
 )
 
 func init() {
 	RootCmd.AddCommand(hostCmd)
 	hostCmd.AddCommand(hostAddCmd)
 	hostCmd.AddCommand(hostRmCmd)
+	hostCmd.PersistentFlags().BoolVarP(&hostPersist, "persist", "p", false,
+		"Save the change so it's kept after a restart")
 }
 
 // The MIT License (MIT)
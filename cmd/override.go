@@ -9,17 +9,74 @@ var (
 	overrideCmd = &cobra.Command{
 		Use:   "override",
 		Short: "Control override",
+		Long: `
+Overrides allow a blocked host and its subdomains for some time; use --exact to
+allow only the host itself. The duration is either in the Go syntax ("1h30m")
+or a number with a suffix ("30m", "1d", "2w", "1M", or "1y").
+
+Overrides apply to all clients, unless --client is used with an IP address or
+the name of a client group.`,
+	}
+	overrideAddCmd = &cobra.Command{
+		Use:   "add",
+		Short: "Allow a host for some time: add <host> <duration>",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if overrideExact {
+				args = append([]string{"--exact"}, args...)
+			}
+			sendOverrideCmd(cmd, args)
+		},
+	}
+	overrideExtendCmd = &cobra.Command{
+		Use:   "extend",
+		Short: "Extend an override: extend <host> <duration>",
+		Run:   sendOverrideCmd,
+		Args:  cobra.ExactArgs(2),
+	}
+	overrideRmCmd = &cobra.Command{
+		Use:   "rm",
+		Short: "Remove overrides",
+		Run:   sendOverrideCmd,
+		Args:  cobra.MinimumNArgs(1),
+	}
+	overrideListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List all overrides",
+		Run:   sendCmd,
+		Args:  cobra.NoArgs,
 	}
 	overrideFlushCmd = &cobra.Command{
 		Use:   "flush",
 		Short: "Flush all overrides",
 		Run:   sendCmd,
 	}
+
+	overrideExact  bool
+	overrideClient string
 )
 
 func init() {
 	RootCmd.AddCommand(overrideCmd)
+	overrideCmd.AddCommand(overrideAddCmd)
+	overrideCmd.AddCommand(overrideExtendCmd)
+	overrideCmd.AddCommand(overrideRmCmd)
+	overrideCmd.AddCommand(overrideListCmd)
 	overrideCmd.AddCommand(overrideFlushCmd)
+	overrideAddCmd.Flags().BoolVar(&overrideExact, "exact", false,
+		"Only allow the host itself, and not its subdomains")
+	for _, c := range []*cobra.Command{overrideAddCmd, overrideExtendCmd, overrideRmCmd} {
+		c.Flags().StringVar(&overrideClient, "client", "",
+			"Only for this client: an IP address or client group")
+	}
+}
+
+// Send the command with the --client flag.
+func sendOverrideCmd(cmd *cobra.Command, args []string) {
+	if overrideClient != "" {
+		args = append([]string{"--client=" + overrideClient}, args...)
+	}
+	sendCmd(cmd, args)
 }
 
 // The MIT License (MIT)
@@ -0,0 +1,79 @@
+// Copyright © 2016-2017 Martin Tournoij <martin@arp242.net>
+// See the bottom of this file for the full copyright notice.
+
+package cmd
+
+import (
+	"arp242.net/trackwall/srvctl"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pauseCmd = &cobra.Command{
+		Use:   "pause <duration>",
+		Short: "Pause all blocking for some time",
+		Long: `
+Pause all blocking for some time, for example to check if a broken site is
+caused by trackwall; all queries are forwarded until the duration expires or
+"trackwall resume" is used. The duration is either in the Go syntax ("1h30m")
+or a number with a suffix ("30m", "1d").
+
+Use --client to pause only for an IP address or client group.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			srvctl.Write("pause " + pauseClientFlag() + args[0])
+		},
+	}
+	resumeCmd = &cobra.Command{
+		Use:   "resume",
+		Short: "Resume blocking after pause",
+		Long: `
+Resume blocking after pause. Without --client this resumes blocking for all
+clients.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			srvctl.Write("resume " + pauseClientFlag())
+		},
+	}
+
+	pauseClient string
+)
+
+func init() {
+	RootCmd.AddCommand(pauseCmd)
+	RootCmd.AddCommand(resumeCmd)
+	for _, c := range []*cobra.Command{pauseCmd, resumeCmd} {
+		c.Flags().StringVar(&pauseClient, "client", "",
+			"Only for this client: an IP address or client group")
+	}
+}
+
+// Get the --client flag to send, with a trailing space.
+func pauseClientFlag() string {
+	if pauseClient == "" {
+		return ""
+	}
+	return "--client=" + pauseClient + " "
+}
+
+// The MIT License (MIT)
+//
+// Copyright © 2016-2017 Martin Tournoij
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// The software is provided "as is", without warranty of any kind, express or
+// implied, including but not limited to the warranties of merchantability,
+// fitness for a particular purpose and noninfringement. In no event shall the
+// authors or copyright holders be liable for any claim, damages or other
+// liability, whether in an action of contract, tort or otherwise, arising
+// from, out of or in connection with the software or the use or other dealings
+// in the software.
@@ -0,0 +1,107 @@
+// Copyright © 2016-2017 Martin Tournoij <martin@arp242.net>
+// See the bottom of this file for the full copyright notice.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/msg"
+	"arp242.net/trackwall/srvctl"
+	"arp242.net/trackwall/srvdns"
+	"github.com/spf13/cobra"
+)
+
+var (
+	queryCmd = &cobra.Command{
+		Use:   "query <name> [type]",
+		Short: "Resolve a name",
+		Long: `
+Resolve a name by sending a DNS query to the running trackwall instance, and
+show the answer along with how it was handled (forwarded, spoofed, emptied,
+etc.), if the response came from the cache, and how long it took. The type is A
+if it's not given.
+
+Use --client to resolve the name as if it was sent by another client, for
+example to test filters with $client. This is sent with the EDNS client subnet
+option, which trackwall only accepts from this host.
+
+With --offline the name is resolved without a running server, by loading all
+the rules in this process; this doesn't use or update the server's cache.`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			qtype := "A"
+			if len(args) > 1 {
+				qtype = args[1]
+			}
+			if queryOffline {
+				queryLocal(args[0], qtype)
+				return
+			}
+
+			send := fmt.Sprintf("query %v %v", strings.ToLower(args[0]), qtype)
+			if queryClient != "" {
+				send += " " + queryClient
+			}
+			srvctl.Write(send)
+		},
+	}
+
+	queryClient  string
+	queryOffline bool
+)
+
+func init() {
+	RootCmd.AddCommand(queryCmd)
+	queryCmd.Flags().StringVar(&queryClient, "client", "",
+		"Resolve the name as if it was sent from this client IP")
+	queryCmd.Flags().BoolVar(&queryOffline, "offline", false,
+		"Resolve the name in this process without a running server")
+}
+
+func queryLocal(name, qtype string) {
+	t, err := srvdns.ParseType(qtype)
+	msg.Fatal(err)
+
+	client := net.IPv4(127, 0, 0, 1)
+	if queryClient != "" {
+		client = net.ParseIP(queryClient)
+		if client == nil {
+			msg.Fatal(fmt.Errorf("invalid client IP: %#v", queryClient))
+		}
+	}
+
+	chroot()
+	DropPrivs()
+
+	cfg.Config.ReadHosts()
+	srvdns.Configure(cfg.Config.DNSForward.String(), cfg.Config.CacheDNS,
+		cfg.Config.HTTPListen.Host, cfg.Config.Verbose)
+
+	fmt.Print(srvdns.Query(name, t, client))
+}
+
+// The MIT License (MIT)
+//
+// Copyright © 2016-2017 Martin Tournoij
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// The software is provided "as is", without warranty of any kind, express or
+// implied, including but not limited to the warranties of merchantability,
+// fitness for a particular purpose and noninfringement. In no event shall the
+// authors or copyright holders be liable for any claim, damages or other
+// liability, whether in an action of contract, tort or otherwise, arising
+// from, out of or in connection with the software or the use or other dealings
+// in the software.
@@ -9,26 +9,34 @@ var (
 	regexpCmd = &cobra.Command{
 		Use:   "regexp",
 		Short: "Control regexp list",
-		Long:  ``,
+		Long: `
+Add or remove regexps in the running trackwall instance. The change is applied
+immediately, but lost on restart unless --persist is used; this saves it to
+the config.managed file sourced in the configuration, which is applied after all
+other rules.`,
 	}
 	regexpAddCmd = &cobra.Command{
 		Use:   "add",
 		Short: "Add new regexps",
-		Run:   sendCmd,
+		Run:   sendEditCmd(&regexpPersist),
 		Args:  cobra.MinimumNArgs(1),
 	}
 	regexpRmCmd = &cobra.Command{
 		Use:   "rm",
 		Short: "Remove regexps",
-		Run:   sendCmd,
+		Run:   sendEditCmd(&regexpPersist),
 		Args:  cobra.MinimumNArgs(1),
 	}
+
+	regexpPersist bool
 )
 
 func init() {
 	RootCmd.AddCommand(regexpCmd)
 	regexpCmd.AddCommand(regexpAddCmd)
 	regexpCmd.AddCommand(regexpRmCmd)
+	regexpCmd.PersistentFlags().BoolVarP(&regexpPersist, "persist", "p", false,
+		"Save the change so it's kept after a restart")
 }
 
 // The MIT License (MIT)
@@ -0,0 +1,73 @@
+// Copyright © 2016-2017 Martin Tournoij <martin@arp242.net>
+// See the bottom of this file for the full copyright notice.
+
+package cmd
+
+import "github.com/spf13/cobra"
+
+var (
+	reportCmd = &cobra.Command{
+		Use:   "report",
+		Short: "Triage reports of wrongly blocked hosts",
+		Long: `
+Triage the hosts that were reported as broken with the button on the blocked
+page. The reports are saved to /reports.json in the chroot directory.
+
+A report can be promoted, which always allows the host by removing the hosts
+that block it (as with "trackwall host rm --persist"), or dismissed. Use export
+to get the rules with the list they're from, to send to the list maintainers.`,
+	}
+	reportListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List all reports",
+		Args:  cobra.NoArgs,
+		Run:   sendCmd,
+	}
+	reportPromoteCmd = &cobra.Command{
+		Use:   "promote",
+		Short: "Always allow the hosts of the reports with these IDs",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   sendCmd,
+	}
+	reportDismissCmd = &cobra.Command{
+		Use:   "dismiss",
+		Short: "Remove the reports with these IDs",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   sendCmd,
+	}
+	reportExportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Show the rules of all reports, or the reports with these IDs",
+		Run:   sendCmd,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(reportCmd)
+	reportCmd.AddCommand(reportListCmd)
+	reportCmd.AddCommand(reportPromoteCmd)
+	reportCmd.AddCommand(reportDismissCmd)
+	reportCmd.AddCommand(reportExportCmd)
+}
+
+// The MIT License (MIT)
+//
+// Copyright © 2016-2017 Martin Tournoij
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// The software is provided "as is", without warranty of any kind, express or
+// implied, including but not limited to the warranties of merchantability,
+// fitness for a particular purpose and noninfringement. In no event shall the
+// authors or copyright holders be liable for any claim, damages or other
+// liability, whether in an action of contract, tort or otherwise, arising
+// from, out of or in connection with the software or the use or other dealings
+// in the software.
@@ -41,11 +41,22 @@ func init() {
 
 // Start servers
 func listen() {
+	// Get the path of the file with the saved rules in the chroot.
+	msg.Warn(cfg.ChrootAdded())
 	chroot()
 
+	// Load the overrides before we start answering queries.
+	msg.Warn(srvdns.LoadState(srvdns.StatePath))
+	msg.Warn(srvdns.OpenAudit(srvdns.AuditPath))
+	msg.Warn(cfg.Reports.Load(cfg.ReportsPath))
+	msg.Warn(srvhttp.LoadTemplates())
+
 	// Setup servers; the bind* function only sets up the socket.
-	ctl := srvctl.Bind()
+	ctl, ctlSocket := srvctl.Bind()
 	http, https := srvhttp.Bind()
+	if cfg.Config.RpzServe != "" {
+		srvdns.ServeRPZ(cfg.Config.RpzServe, cfg.Config.RpzNotify, cfg.Config.RpzAllowTransfer)
+	}
 	dnsUDP, dnsTCP := srvdns.Serve(cfg.Config.DNSListen.String(),
 		cfg.Config.DNSForward.String(), cfg.Config.CacheDNS, cfg.Config.HTTPListen.Host,
 		cfg.Config.Verbose)
@@ -59,12 +70,15 @@ func listen() {
 	// Drop privileges
 	DropPrivs()
 
-	srvctl.Serve(ctl)
+	srvctl.Serve(ctl, ctlSocket)
 	srvhttp.Serve(http, https)
 
 	// Read the hosts information *after* starting the DNS server because we can
 	// add hosts from remote sources (and thus needs DNS)
 	cfg.Config.ReadHosts()
+	srvdns.RestoreCache()
+	cfg.RPZ.Refresh(srvdns.Cache.Purge)
+	go srvdns.KeepState(srvdns.StatePath, cfg.Config.StateCache)
 
 	msg.Info("initialisation finished; ready to serve", cfg.Config.Verbose)
 
@@ -72,6 +86,8 @@ func listen() {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	<-sigs
+
+	msg.Warn(srvdns.SaveState(srvdns.StatePath, cfg.Config.StateCache))
 }
 
 // The MIT License (MIT)
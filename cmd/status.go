@@ -36,11 +36,31 @@ var (
 		Short: "Show regexps",
 		Run:   sendCmd,
 	}
-	statusOverrideCmd = &cobra.Command{
-		Use:   "override",
-		Short: "Show override table",
+	statusFiltersCmd = &cobra.Command{
+		Use:   "filters",
+		Short: "Show adblock filters",
+		Run:   sendCmd,
+	}
+	statusRPZCmd = &cobra.Command{
+		Use:   "rpz",
+		Short: "Show response policy zones",
 		Run:   sendCmd,
 	}
+	statusOverrideCmd = &cobra.Command{
+		Use:     "override",
+		Aliases: []string{"overrides"},
+		Short:   "Show override table",
+		Long: `
+Show the override table, or with --history the log of all the overrides that
+were added, extended, or removed, and who did it.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if statusOverrideHistory {
+				args = append(args, "--history")
+			}
+			sendCmd(cmd, args)
+		},
+	}
+	statusOverrideHistory bool
 )
 
 func init() {
@@ -50,7 +70,11 @@ func init() {
 	statusCmd.AddCommand(statusCacheCmd)
 	statusCmd.AddCommand(statusHostsCmd)
 	statusCmd.AddCommand(statusRegexpsCmd)
+	statusCmd.AddCommand(statusFiltersCmd)
+	statusCmd.AddCommand(statusRPZCmd)
 	statusCmd.AddCommand(statusOverrideCmd)
+	statusOverrideCmd.Flags().BoolVar(&statusOverrideHistory, "history", false,
+		"Show the log of changes to the overrides")
 }
 
 // The MIT License (MIT)
@@ -0,0 +1,127 @@
+// Copyright © 2016-2017 Martin Tournoij <martin@arp242.net>
+// See the bottom of this file for the full copyright notice.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/msg"
+	"arp242.net/trackwall/srvctl"
+	"arp242.net/trackwall/srvdns"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tailCmd = &cobra.Command{
+		Use:   "tail",
+		Short: "Show DNS decisions and HTTP requests as they happen",
+		Long: `
+Show every DNS decision and request to the HTTP stub of the running trackwall
+instance as it happens, without having to restart it with -v.
+
+The filters are applied by the server:
+
+    --client    IP address or CIDR range of the client.
+    --name      Regexp the name must match.
+    --type      Comma-separated list of responses ("spoof", "forward",
+                "nxdomain", "surrogate", etc.), "blocked", "allowed", "dns",
+                or "http".`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			send := "tail"
+			for _, f := range [][]string{{"client", tailClient}, {"name", tailName}, {"type", tailType}} {
+				if strings.ContainsAny(f[1], " \n") {
+					msg.Fatal(fmt.Errorf("--%v can't contain spaces", f[0]))
+				}
+				if f[1] != "" {
+					send += fmt.Sprintf(" %v=%v", f[0], f[1])
+				}
+			}
+
+			conn, err := srvctl.Dial()
+			msg.Fatal(err)
+			defer func() { _ = conn.Close() }()
+			fmt.Fprintf(conn, "%v\n", send)
+
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				line := scanner.Text()
+				if strings.HasPrefix(line, "error: ") {
+					fmt.Fprintln(os.Stderr, line)
+					os.Exit(1)
+				}
+				if tailJSON {
+					fmt.Println(line)
+					continue
+				}
+
+				var e srvdns.Event
+				msg.Fatal(json.Unmarshal([]byte(line), &e))
+				printEvent(e)
+			}
+			msg.Fatal(scanner.Err())
+		},
+	}
+
+	tailClient, tailName, tailType string
+	tailJSON                       bool
+)
+
+func init() {
+	RootCmd.AddCommand(tailCmd)
+	tailCmd.Flags().StringVar(&tailClient, "client", "", "Only show events for this client")
+	tailCmd.Flags().StringVar(&tailName, "name", "", "Only show events for names matching this regexp")
+	tailCmd.Flags().StringVar(&tailType, "type", "", "Only show events of these types")
+	tailCmd.Flags().BoolVar(&tailJSON, "json", false, "Print the events as JSON")
+}
+
+// Print the event with the same colours as the server's verbose output.
+func printEvent(e srvdns.Event) {
+	color := ""
+	if cfg.Config.Color {
+		color = "green"
+		if e.Blocked {
+			color = "orange"
+		}
+	}
+
+	what := e.Name
+	if e.Kind == "http" {
+		what = e.Name + e.URL
+	}
+	cached := ""
+	if e.Cached {
+		cached = " (cached)"
+	}
+
+	fmt.Printf("%v %v %-9v %-5v %-15v %v%v\n", e.Time.Format("15:04:05"), msg.Fill(4, color),
+		e.Response, e.Type, e.Client, what, cached)
+}
+
+// The MIT License (MIT)
+//
+// Copyright © 2016-2017 Martin Tournoij
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// The software is provided "as is", without warranty of any kind, express or
+// implied, including but not limited to the warranties of merchantability,
+// fitness for a particular purpose and noninfringement. In no event shall the
+// authors or copyright holders be liable for any claim, damages or other
+// liability, whether in an action of contract, tort or otherwise, arising
+// from, out of or in connection with the software or the use or other dealings
+// in the software.
@@ -7,6 +7,7 @@ require (
 	github.com/miekg/dns v0.0.0-20180830064133-9a34f54f7a0f
 	github.com/spf13/cobra v0.0.0-20180829160745-99dc12355885
 	github.com/spf13/pflag v1.0.2 // indirect
+	github.com/ulikunitz/xz v0.5.15
 	golang.org/x/crypto v0.0.0-20180830192347-182538f80094 // indirect
 	golang.org/x/net v0.0.0-20180826012351-8a410e7b638d // indirect
 )
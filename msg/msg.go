@@ -118,8 +118,17 @@ func msg(prefix, msg interface{}, fillColor string, fp io.Writer) {
 
 	s := fmt.Sprintf("%s %v:%v", prefix, file, line)
 
-	fill := strings.Repeat(" ", 24-len(s))
-	switch fillColor {
+	fmt.Fprintf(fp, "%s %s %v\n", s, Fill(24-len(s), fillColor), msg)
+}
+
+// Fill gets n spaces with the background colour color, which is "orange",
+// "green", "red", or "" for no colour.
+func Fill(n int, color string) string {
+	if n < 0 {
+		n = 0
+	}
+	fill := strings.Repeat(" ", n)
+	switch color {
 	case "orange":
 		fill = orangebg(fill)
 	case "green":
@@ -127,7 +136,7 @@ func msg(prefix, msg interface{}, fillColor string, fp io.Writer) {
 	case "red":
 		fill = redbg(fill)
 	}
-	fmt.Fprintf(fp, "%s %s %v\n", s, fill, msg)
+	return fill
 }
 
 func greenbg(m string) string {
@@ -0,0 +1,572 @@
+package srvctl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/srvdns"
+)
+
+// The JSON API, under /api/v1/. Errors are sent as {"error": "..."}, with an
+// appropriate status code.
+//
+//	GET     status                     Summary.
+//	GET     stats?n=                   Query statistics, with the n most recent queries and top names.
+//	GET     events?client=&name=&type= Stream of DNS decisions and HTTP stub requests, as
+//	                                   NDJSON or as Server-Sent Events with "Accept: text/event-stream".
+//	GET     config                     Configuration.
+//	GET     hosts?q=&page=&per_page=   Blocked hosts, optionally filtered.
+//	POST    hosts                      Add hosts: {"hosts": [..], "persist": false}
+//	DELETE  hosts/<host>?persist=      Remove a host.
+//	GET     regexps                    Regexps.
+//	POST    regexps                    Add regexps: {"regexps": [..], "persist": false}
+//	DELETE  regexps?regexp=&persist=   Remove a regexp.
+//	GET     overrides                  Overrides.
+//	POST    overrides                  Add an override: {"host": "..", "duration": "1h", "exact": false, "client": ""}
+//	GET     overrides/<host>?client=   Get an override.
+//	PUT     overrides/<host>?client=   Change the expiry: {"duration": "1h"}
+//	DELETE  overrides/<host>?client=   Remove an override.
+//	GET     pause                      Clients for which blocking is paused.
+//	POST    pause                      Pause blocking: {"duration": "10m", "client": ""}
+//	DELETE  pause?client=              Resume blocking; for all clients without client.
+//	GET     reports                    Reports of wrongly blocked hosts.
+//	GET     reports/export             The rules of all reports, for the list maintainers, as text.
+//	POST    reports/<id>/promote       Remove the hosts that block the report, and save that.
+//	DELETE  reports/<id>               Dismiss a report.
+//	GET     cache?name=                Cache entries, optionally for one name.
+//	DELETE  cache?name=                Flush the cache, optionally for one name.
+//	GET     lists                      Hostlists, regexplists, etc.
+//	POST    lists/refresh?url=         Download new copies of all or one list.
+//	POST    lists/enable?url=          Enable a list.
+//	POST    lists/disable?url=         Disable a list.
+//	POST    reload                     Reload all the rules.
+
+// Maximum for ?per_page=
+const maxPerPage = 1000
+
+// Override in the API.
+type apiOverrideT struct {
+	Host    string    `json:"host"`
+	Scope   string    `json:"scope"`
+	Client  string    `json:"client"`
+	Expires time.Time `json:"expires"`
+}
+
+func newAPIOverride(k cfg.OverrideKey, e cfg.OverrideEntry) apiOverrideT {
+	return apiOverrideT{k.Host, e.Scope(), k.ClientName(), time.Unix(e.Expires, 0)}
+}
+
+func apiStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	apiJSON(w, http.StatusOK, map[string]interface{}{
+		"hosts":        cfg.Hosts.Len(),
+		"regexps":      cfg.Regexps.Len(),
+		"filters":      cfg.Filters.Len(),
+		"rpz_triggers": cfg.RPZ.Len(),
+		"overrides":    len(cfg.Override.List()),
+		"cache_items":  srvdns.Cache.Len(),
+		"paused":       pauseSummary(),
+		"memory_kb":    stats.Sys / 1024,
+	})
+}
+
+func apiStats(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	n, err := intParam(r, "n", 10)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if n < 1 || n > maxPerPage {
+		apiError(w, http.StatusBadRequest, fmt.Errorf("n must be between 1 and %v", maxPerPage))
+		return
+	}
+	apiJSON(w, http.StatusOK, srvdns.Stats.Summary(n))
+}
+
+func apiEvents(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		apiError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+	f, err := srvdns.ParseEventFilter(r.FormValue("client"), r.FormValue("name"), r.FormValue("type"))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events, cancel := srvdns.Events.Subscribe(f)
+	defer cancel()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			j, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			if sse {
+				_, err = fmt.Fprintf(w, "data: %s\n\n", j)
+			} else {
+				_, err = fmt.Fprintf(w, "%s\n", j)
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func apiConfig(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	apiJSON(w, http.StatusOK, cfg.Config)
+}
+
+func apiHosts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		page, err := intParam(r, "page", 1)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		perPage, err := intParam(r, "per_page", 100)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		if page < 1 || perPage < 1 || perPage > maxPerPage {
+			apiError(w, http.StatusBadRequest,
+				fmt.Errorf("page must be at least 1, and per_page between 1 and %v", maxPerPage))
+			return
+		}
+
+		hosts := cfg.Hosts.List(strings.ToLower(r.FormValue("q")))
+		total := len(hosts)
+		start := (page - 1) * perPage
+		if start > total {
+			start = total
+		}
+		end := start + perPage
+		if end > total {
+			end = total
+		}
+
+		apiJSON(w, http.StatusOK, map[string]interface{}{
+			"total":    total,
+			"page":     page,
+			"per_page": perPage,
+			"hosts":    hosts[start:end],
+		})
+	case http.MethodPost:
+		var args struct {
+			Hosts   []string `json:"hosts"`
+			Persist bool     `json:"persist"`
+		}
+		if !readJSON(w, r, &args) {
+			return
+		}
+		apiEdit(w, editHosts(true, args.Persist, args.Hosts...))
+	default:
+		allowMethod(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+func apiHost(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodDelete) {
+		return
+	}
+	host := strings.TrimPrefix(r.URL.Path, "/api/v1/hosts/")
+	apiEdit(w, editHosts(false, boolParam(r, "persist"), host))
+}
+
+func apiRegexps(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		apiJSON(w, http.StatusOK, map[string]interface{}{"regexps": cfg.Regexps.List()})
+	case http.MethodPost:
+		var args struct {
+			Regexps []string `json:"regexps"`
+			Persist bool     `json:"persist"`
+		}
+		if !readJSON(w, r, &args) {
+			return
+		}
+		apiEdit(w, editRegexps(true, args.Persist, args.Regexps...))
+	case http.MethodDelete:
+		re := r.FormValue("regexp")
+		if !cfg.Regexps.Has(re) {
+			apiError(w, http.StatusNotFound, fmt.Errorf("no such regexp: %#v", re))
+			return
+		}
+		apiEdit(w, editRegexps(false, boolParam(r, "persist"), re))
+	default:
+		allowMethod(w, r, http.MethodGet, http.MethodPost, http.MethodDelete)
+	}
+}
+
+func apiOverrides(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list := cfg.Override.List()
+		overrides := make([]apiOverrideT, 0, len(list))
+		for k, e := range list {
+			overrides = append(overrides, newAPIOverride(k, e))
+		}
+		sort.Slice(overrides, func(i, j int) bool {
+			if overrides[i].Host == overrides[j].Host {
+				return overrides[i].Client < overrides[j].Client
+			}
+			return overrides[i].Host < overrides[j].Host
+		})
+		apiJSON(w, http.StatusOK, map[string]interface{}{"overrides": overrides})
+	case http.MethodPost:
+		var args struct {
+			Host     string `json:"host"`
+			Duration string `json:"duration"`
+			Exact    bool   `json:"exact"`
+			Client   string `json:"client"`
+		}
+		if !readJSON(w, r, &args) {
+			return
+		}
+		host := strings.ToLower(strings.TrimRight(args.Host, "."))
+		if !cfg.ValidHost(host) {
+			apiError(w, http.StatusBadRequest, fmt.Errorf("invalid host: %#v", args.Host))
+			return
+		}
+		client, err := cfg.ParseOverrideClient(args.Client)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		setOverride(w, r, http.StatusCreated, cfg.OverrideKey{Host: host, Client: client}, args.Duration, !args.Exact)
+	default:
+		allowMethod(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+func apiOverride(w http.ResponseWriter, r *http.Request) {
+	host := strings.TrimPrefix(r.URL.Path, "/api/v1/overrides/")
+	client, err := cfg.ParseOverrideClient(r.URL.Query().Get("client"))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	k := cfg.OverrideKey{Host: host, Client: client}
+	e, ok := cfg.Override.Get(k)
+
+	switch r.Method {
+	case http.MethodGet, http.MethodPut, http.MethodDelete:
+		if !ok {
+			apiError(w, http.StatusNotFound, fmt.Errorf("no override for %#v", host))
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		apiJSON(w, http.StatusOK, newAPIOverride(k, e))
+	case http.MethodPut:
+		var args struct {
+			Duration string `json:"duration"`
+		}
+		if !readJSON(w, r, &args) {
+			return
+		}
+		setOverride(w, r, http.StatusOK, k, args.Duration, e.Subtree)
+	case http.MethodDelete:
+		srvdns.Unallow(k.Host, k.Client)
+		srvdns.Audit("remove", k, e, 0, requester(r))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		allowMethod(w, r, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+func setOverride(w http.ResponseWriter, r *http.Request, status int, k cfg.OverrideKey, duration string, subtree bool) {
+	d, err := parseDuration(duration)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	exp := time.Now().Add(d)
+	srvdns.Allow(k.Host, k.Client, exp, subtree)
+	e, _ := cfg.Override.Get(k)
+	action := "add"
+	if status == http.StatusOK {
+		action = "extend"
+	}
+	srvdns.Audit(action, k, e, d, requester(r))
+	apiJSON(w, status, newAPIOverride(k, e))
+}
+
+func apiPause(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list := srvdns.Pauses.List()
+		pauses := make([]map[string]interface{}, 0, len(list))
+		for c, until := range list {
+			if c == "" {
+				c = "all"
+			}
+			pauses = append(pauses, map[string]interface{}{"client": c, "until": time.Unix(until, 0)})
+		}
+		sort.Slice(pauses, func(i, j int) bool { return pauses[i]["client"].(string) < pauses[j]["client"].(string) })
+		apiJSON(w, http.StatusOK, map[string]interface{}{"pauses": pauses})
+	case http.MethodPost:
+		var args struct {
+			Duration string `json:"duration"`
+			Client   string `json:"client"`
+		}
+		if !readJSON(w, r, &args) {
+			return
+		}
+		client, err := cfg.ParseOverrideClient(args.Client)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		d, err := parseDuration(args.Duration)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		srvdns.Pauses.Pause(client, time.Now().Add(d))
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if _, ok := r.URL.Query()["client"]; !ok {
+			srvdns.Pauses.Purge()
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		client, err := cfg.ParseOverrideClient(r.URL.Query().Get("client"))
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		if !srvdns.Pauses.Resume(client) {
+			apiError(w, http.StatusNotFound, fmt.Errorf("not paused for %#v", client))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		allowMethod(w, r, http.MethodGet, http.MethodPost, http.MethodDelete)
+	}
+}
+
+func apiCache(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(strings.TrimRight(r.FormValue("name"), "."))
+	switch r.Method {
+	case http.MethodGet:
+		apiJSON(w, http.StatusOK, map[string]interface{}{"cache": srvdns.Cache.Items(name)})
+	case http.MethodDelete:
+		if name == "" {
+			srvdns.Cache.Purge()
+		} else {
+			srvdns.Cache.DeleteName(name)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		allowMethod(w, r, http.MethodGet, http.MethodDelete)
+	}
+}
+
+func apiLists(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	apiJSON(w, http.StatusOK, map[string]interface{}{"lists": cfg.Config.Lists()})
+}
+
+func apiListAction(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	url := r.FormValue("url")
+	var err error
+	switch strings.TrimPrefix(r.URL.Path, "/api/v1/lists/") {
+	case "refresh":
+		err = cfg.Config.RefreshLists(url)
+	case "enable":
+		err = cfg.Config.DisableList(url, false)
+	case "disable":
+		err = cfg.Config.DisableList(url, true)
+	default:
+		apiError(w, http.StatusNotFound, errors.New("no such endpoint"))
+		return
+	}
+
+	switch {
+	case err == cfg.ErrUnknownList:
+		apiError(w, http.StatusNotFound, fmt.Errorf("%v: %#v", err, url))
+	case err != nil:
+		apiError(w, http.StatusBadGateway, err)
+	default:
+		srvdns.Cache.Purge()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func apiReload(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if err := cfg.Config.Reload(); err != nil {
+		apiError(w, http.StatusBadGateway, err)
+		return
+	}
+	srvdns.Cache.Purge()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiReports(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	apiJSON(w, http.StatusOK, map[string]interface{}{"reports": cfg.Reports.List()})
+}
+
+func apiReport(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/reports/"), "/")
+	if len(path) == 1 && path[0] == "export" {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		cfg.ExportReports(w, cfg.Reports.List())
+		return
+	}
+
+	id, err := strconv.Atoi(path[0])
+	if err != nil {
+		apiError(w, http.StatusNotFound, fmt.Errorf("no such report: %#v", path[0]))
+		return
+	}
+	if _, ok := cfg.Reports.Get(id); !ok {
+		apiError(w, http.StatusNotFound, fmt.Errorf("no such report: %v", id))
+		return
+	}
+
+	var out string
+	switch {
+	case len(path) == 1 && r.Method == http.MethodDelete:
+		out = handleReport("dismiss", path[:1], nil)
+	case len(path) == 2 && path[1] == "promote" && r.Method == http.MethodPost:
+		out = handleReport("promote", path[:1], nil)
+	case len(path) == 1:
+		allowMethod(w, r, http.MethodDelete)
+		return
+	case len(path) == 2 && path[1] == "promote":
+		allowMethod(w, r, http.MethodPost)
+		return
+	default:
+		apiError(w, http.StatusNotFound, errors.New("no such endpoint"))
+		return
+	}
+	if strings.HasPrefix(out, "error: ") {
+		apiError(w, http.StatusBadRequest, errors.New(strings.TrimPrefix(out, "error: ")))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Send the result of editHosts() or editRegexps().
+func apiEdit(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case inputError:
+		apiError(w, http.StatusBadRequest, err)
+	default:
+		apiError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// Check if the request method is one of methods, and send an error if it's
+// not.
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	apiError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+	return false
+}
+
+func apiJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, err error) {
+	apiJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// Read the JSON request body in to v, and send an error if it's invalid.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(v)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, fmt.Errorf("invalid JSON: %v", err))
+		return false
+	}
+	return true
+}
+
+func intParam(r *http.Request, name string, def int) (int, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%v: not a number: %#v", name, v)
+	}
+	return n, nil
+}
+
+func boolParam(r *http.Request, name string) bool {
+	b, _ := strconv.ParseBool(r.FormValue(name))
+	return b
+}
@@ -0,0 +1,193 @@
+package srvctl
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/srvdns"
+	"arp242.net/trackwall/tt"
+)
+
+func TestAPI(t *testing.T) {
+	defer cfg.Hosts.Purge()
+	defer cfg.Origins.Purge()
+	defer cfg.Override.Purge()
+	defer srvdns.Cache.Purge()
+
+	cfg.Hosts.Add("a.example.com", "b.example.com", "c.example.net")
+	mux := newMux()
+
+	cases := []struct {
+		method, path, body string
+		expectedCode       int
+		expectedBody       string
+	}{
+		{"GET", "/api/v1/hosts?q=example.com&per_page=1&page=2", "", 200,
+			`{"hosts":["b.example.com"],"page":2,"per_page":1,"total":2}`},
+		{"GET", "/api/v1/hosts?page=0", "", 400, ""},
+		{"GET", "/api/v1/hosts?per_page=x", "", 400, `{"error":"per_page: not a number: \"x\""}`},
+		{"POST", "/api/v1/hosts", `{"hosts": ["d.example.com"]}`, 204, ""},
+		{"POST", "/api/v1/hosts", `{"hosts": ["1.2.3.4"]}`, 400, `{"error":"invalid host: \"1.2.3.4\""}`},
+		{"POST", "/api/v1/hosts", `{"hosts": `, 400, ""},
+		{"DELETE", "/api/v1/hosts/d.example.com", "", 204, ""},
+		{"PUT", "/api/v1/hosts", "", 405, `{"error":"method PUT not allowed"}`},
+
+		{"POST", "/api/v1/regexps", `{"regexps": ["^ads?\\."]}`, 204, ""},
+		{"GET", "/api/v1/regexps", "", 200, `{"regexps":["^ads?\\."]}`},
+		{"DELETE", "/api/v1/regexps?regexp=x", "", 404, `{"error":"no such regexp: \"x\""}`},
+		{"DELETE", "/api/v1/regexps?regexp=%5Eads%3F%5C.", "", 204, ""},
+
+		{"POST", "/api/v1/overrides", `{"host": "a.example.com", "duration": "1h"}`, 201, ""},
+		{"POST", "/api/v1/overrides", `{"host": "a.example.com", "duration": "x"}`, 400, `{"error":"invalid duration: \"x\""}`},
+		{"GET", "/api/v1/overrides/a.example.com", "", 200, ""},
+		{"PUT", "/api/v1/overrides/a.example.com", `{"duration": "2h"}`, 200, ""},
+		{"DELETE", "/api/v1/overrides/a.example.com", "", 204, ""},
+		{"DELETE", "/api/v1/overrides/a.example.com", "", 404, `{"error":"no override for \"a.example.com\""}`},
+		{"POST", "/api/v1/overrides", `{"host": "a.example.com", "duration": "1h", "client": "10.0.0.1"}`, 201, ""},
+		{"GET", "/api/v1/overrides/a.example.com", "", 404, `{"error":"no override for \"a.example.com\""}`},
+		{"DELETE", "/api/v1/overrides/a.example.com?client=10.0.0.1", "", 204, ""},
+		{"POST", "/api/v1/overrides", `{"host": "a.example.com", "duration": "1h", "client": "x"}`, 400, `{"error":"not an IP address or client group: \"x\""}`},
+		{"GET", "/api/v1/overrides", "", 200, `{"overrides":[]}`},
+		{"POST", "/api/v1/pause", `{"duration": "10m", "client": "10.0.0.1"}`, 204, ""},
+		{"DELETE", "/api/v1/pause?client=10.0.0.1", "", 204, ""},
+		{"DELETE", "/api/v1/pause?client=10.0.0.1", "", 404, `{"error":"not paused for \"10.0.0.1\""}`},
+		{"POST", "/api/v1/pause", `{"duration": "x"}`, 400, `{"error":"invalid duration: \"x\""}`},
+		{"DELETE", "/api/v1/pause", "", 204, ""},
+		{"GET", "/api/v1/pause", "", 200, `{"pauses":[]}`},
+		{"GET", "/api/v1/reports", "", 200, `{"reports":[]}`},
+		{"GET", "/api/v1/reports/export", "", 200, ""},
+		{"DELETE", "/api/v1/reports/1", "", 404, `{"error":"no such report: 1"}`},
+		{"POST", "/api/v1/reports/x/promote", "", 404, `{"error":"no such report: \"x\""}`},
+
+		{"GET", "/api/v1/stats?n=5", "", 200, ""},
+		{"GET", "/api/v1/stats?n=0", "", 400, `{"error":"n must be between 1 and 1000"}`},
+		{"GET", "/", "", 200, ""},
+
+		{"GET", "/api/v1/cache", "", 200, `{"cache":[]}`},
+		{"DELETE", "/api/v1/cache?name=a.example.com", "", 204, ""},
+		{"POST", "/api/v1/lists/enable?url=file:///x", "", 404, `{"error":"no such list: \"file:///x\""}`},
+		{"GET", "/api/v1/nope", "", 404, `{"error":"no such endpoint"}`},
+		{"GET", "/status/regexps", "", 200, ""},
+		{"GET", "/status/nope", "", 400, ""},
+		{"GET", "/override/list", "", 200, ""},
+		{"GET", "/cache/flush", "", 405, ""},
+		{"GET", "/pause/10y", "", 405, ""},
+		{"POST", "/cache/flush", "", 200, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%v %v", tc.method, tc.path), func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+			tt.Eq(t, "code", tc.expectedCode, rr.Code)
+			if tc.expectedBody != "" {
+				var v interface{}
+				tt.Err(t, json.Unmarshal(rr.Body.Bytes(), &v))
+				out, _ := json.Marshal(v)
+				tt.Eq(t, "body", tc.expectedBody, string(out))
+			}
+		})
+	}
+
+	_, ok := cfg.Hosts.Get("d.example.com")
+	tt.Eq(t, "removed host", false, ok)
+}
+
+func TestServe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	tt.Err(t, err)
+	Serve(l, nil)
+
+	// Line protocol.
+	conn, err := net.Dial("tcp", l.Addr().String())
+	tt.Err(t, err)
+	fmt.Fprintf(conn, "status nope\n")
+	out, err := ioutil.ReadAll(conn)
+	tt.Err(t, err)
+	tt.Eq(t, "line", "error: unknown subcommand: \"nope\"\n", string(out))
+	_ = conn.Close()
+
+	// HTTP.
+	resp, err := http.Get("http://" + l.Addr().String() + "/api/v1/status")
+	tt.Err(t, err)
+	defer resp.Body.Close() // nolint: errcheck
+	tt.Eq(t, "code", 200, resp.StatusCode)
+	tt.Eq(t, "content-type", "application/json", resp.Header.Get("Content-Type"))
+
+	// Old-style HTTP commands.
+	conn, err = net.Dial("tcp", l.Addr().String())
+	tt.Err(t, err)
+	fmt.Fprintf(conn, "GET /status/nope HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	tt.Err(t, err)
+	tt.Eq(t, "status", "HTTP/1.1 400 Bad Request\r\n", status)
+	_ = conn.Close()
+}
+
+func TestEventStream(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	tt.Err(t, err)
+	Serve(l, nil)
+
+	// Keep publishing until the subscriber got something, as we don't know when
+	// the server subscribed.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				srvdns.Events.Publish(srvdns.Event{LoggedQuery: srvdns.LoggedQuery{Name: "example.com"}, Kind: "dns"})
+				srvdns.Events.Publish(srvdns.Event{LoggedQuery: srvdns.LoggedQuery{Name: "ads.example.com", Blocked: true}, Kind: "dns"})
+			}
+		}
+	}()
+
+	read := func(t *testing.T, r *bufio.Reader) srvdns.Event {
+		line, err := r.ReadString('\n')
+		tt.Err(t, err)
+		line = strings.TrimPrefix(line, "data: ")
+		var e srvdns.Event
+		tt.Err(t, json.Unmarshal([]byte(line), &e))
+		return e
+	}
+
+	t.Run("line", func(t *testing.T) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		tt.Err(t, err)
+		defer conn.Close() // nolint: errcheck
+
+		fmt.Fprintf(conn, "tail type=nope\n")
+		out, err := ioutil.ReadAll(conn)
+		tt.Err(t, err)
+		tt.Eq(t, "error", "error: invalid type: \"nope\"\n", string(out))
+
+		conn, err = net.Dial("tcp", l.Addr().String())
+		tt.Err(t, err)
+		defer conn.Close() // nolint: errcheck
+		fmt.Fprintf(conn, "tail type=blocked name=^ads\n")
+		tt.Eq(t, "name", "ads.example.com", read(t, bufio.NewReader(conn)).Name)
+	})
+
+	t.Run("sse", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "http://"+l.Addr().String()+"/api/v1/events?type=allowed", nil)
+		tt.Err(t, err)
+		req.Header.Set("Accept", "text/event-stream")
+		resp, err := http.DefaultClient.Do(req)
+		tt.Err(t, err)
+		defer resp.Body.Close() // nolint: errcheck
+		tt.Eq(t, "content-type", "text/event-stream", resp.Header.Get("Content-Type"))
+		tt.Eq(t, "name", "example.com", read(t, bufio.NewReader(resp.Body)).Name)
+	})
+}
@@ -0,0 +1,186 @@
+package srvctl
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/srvdns"
+)
+
+// Authentication for the control socket:
+//
+// - Connections to control-socket are allowed if the uid or gid of the peer is
+//   in control-allow-users or control-allow-groups.
+// - With control-tls, the client must present a certificate issued by the root
+//   CA.
+// - Otherwise, the bearer token from control-token-file must be sent first, as
+//   "auth <token>" with the line protocol, or in the Authorization header with
+//   HTTP.
+// - Without a token, only connections from loopback addresses are allowed, and
+//   only if there is no control-socket; otherwise any local user could bypass
+//   control-allow-users and control-allow-groups.
+//
+// Browsers will happily send requests to loopback addresses from any website,
+// so HTTP requests that change anything must be from the same origin, and the
+// Host header must be an address or localhost if there's no token (to prevent
+// DNS rebinding).
+
+// peer is the other end of a control connection.
+type peer struct {
+	authed   bool   // Authenticated with the socket credentials or certificate.
+	loopback bool   // Authenticated only because it's from a loopback address.
+	name     string // Description, for errors.
+}
+
+// Get the requester for the audit log; ip is the address of the connection.
+func (p peer) requester(ip net.IP) srvdns.Requester {
+	r := srvdns.Requester{Client: ip.String()}
+	switch {
+	case !p.authed:
+		r.User = "token"
+	case p.name != r.Client:
+		r.User = p.name
+	}
+	return r
+}
+
+type peerKey struct{}
+
+var errNoPeerCred = errors.New("peer credentials are not supported on this system")
+
+// Authenticate the connection by its transport; the token is checked later, as
+// that depends on the protocol.
+func authenticate(conn net.Conn) (peer, error) {
+	switch c := conn.(type) {
+	case *net.UnixConn:
+		uid, gid, err := peerCred(c)
+		if err == errNoPeerCred {
+			return peer{authed: true, name: "socket"}, nil
+		}
+		if err != nil {
+			return peer{}, err
+		}
+		if !cfg.Config.ControlAllowed(uid, gid) {
+			return peer{}, fmt.Errorf("uid %v is not allowed", uid)
+		}
+		return peer{authed: true, name: fmt.Sprintf("uid %v", uid)}, nil
+	case *tls.Conn:
+		if err := c.Handshake(); err != nil {
+			return peer{}, err
+		}
+		name := "certificate"
+		if certs := c.ConnectionState().PeerCertificates; len(certs) > 0 {
+			name = certs[0].Subject.CommonName
+		}
+		return peer{authed: true, name: name}, nil
+	}
+
+	ip := remoteIP(conn.RemoteAddr())
+	if cfg.Config.ControlToken() == "" {
+		if cfg.Config.ControlSocket != "" {
+			return peer{}, fmt.Errorf("%v is not allowed; use control-socket, or control-token-file or control-tls for control-listen", ip)
+		}
+		if ip == nil || !ip.IsLoopback() {
+			return peer{}, fmt.Errorf("%v is not allowed; use control-token-file or control-tls to allow remote connections", ip)
+		}
+		return peer{authed: true, loopback: true, name: ip.String()}, nil
+	}
+	return peer{name: ip.String()}, nil
+}
+
+// Check the bearer token.
+func validToken(token string) bool {
+	want := cfg.Config.ControlToken()
+	return want != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// Check the "auth <token>" line of the line protocol.
+func authLine(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, "auth ") && validToken(line[5:])
+}
+
+// Store the peer in the request context.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	if pc, ok := c.(*peekedConn); ok {
+		return context.WithValue(ctx, peerKey{}, pc.peer)
+	}
+	return ctx
+}
+
+// Require authentication for all HTTP requests, except for the dashboard page
+// itself, which asks for the token if it's needed.
+//
+// HTTP requests that change anything must be from the same origin, whatever
+// the method; otherwise any website could use the browser of someone on the
+// loopback address.
+func requireAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !readOnlyRequest(r) && !sameOrigin(r) {
+			apiError(w, http.StatusForbidden, errors.New("cross-origin request"))
+			return
+		}
+		if r.URL.Path == "/" && r.Method == http.MethodGet {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if p, ok := r.Context().Value(peerKey{}).(peer); ok && p.authed {
+			if p.loopback && !localHost(r.Host) {
+				apiError(w, http.StatusForbidden, fmt.Errorf("invalid host: %#v", r.Host))
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		auth := r.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") && validToken(auth[7:]) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="trackwall"`)
+		apiError(w, http.StatusUnauthorized, errors.New("authentication required"))
+	})
+}
+
+// Check if the request only reads information: GET and HEAD requests for the
+// API and the dashboard, or for a read-only line protocol command.
+func readOnlyRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return r.URL.Path == "/" || strings.HasPrefix(r.URL.Path, "/api/") || readOnly(legacyCommand(r))
+}
+
+// Check that the Origin header is for this host, or the Referer header if
+// there is no Origin. Requests without either aren't from a browser.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Referer()
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && u.Host == r.Host
+}
+
+// Check if the host from the Host header is an address or localhost.
+func localHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	return host == "localhost" || net.ParseIP(host) != nil
+}
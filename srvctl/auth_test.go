@@ -0,0 +1,182 @@
+package srvctl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/srvdns"
+	"arp242.net/trackwall/tt"
+)
+
+func TestAuthToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackwall-auth")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	token := filepath.Join(dir, "token")
+	tt.Err(t, ioutil.WriteFile(token, []byte("0123456789abcdef\n"), 0600))
+	config := filepath.Join(dir, "config")
+	tt.Err(t, ioutil.WriteFile(config, []byte("control-token-file "+token+"\n"), 0600))
+	tt.Err(t, cfg.Load(config))
+	empty := filepath.Join(dir, "empty")
+	tt.Err(t, ioutil.WriteFile(empty, nil, 0600))
+	defer func() {
+		cfg.Config = cfg.ConfigT{}
+		tt.Err(t, cfg.Load(empty))
+	}()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	tt.Err(t, err)
+	Serve(l, nil)
+
+	line := func(send string) string {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		tt.Err(t, err)
+		defer conn.Close() // nolint: errcheck
+		fmt.Fprint(conn, send)
+		out, err := ioutil.ReadAll(conn)
+		tt.Err(t, err)
+		return string(out)
+	}
+	tt.Eq(t, "no token", "error: authentication required\n", line("status nope\n"))
+	tt.Eq(t, "wrong token", "error: authentication required\n", line("auth 0123456789abcdeX\nstatus nope\n"))
+	tt.Eq(t, "token", "error: unknown subcommand: \"nope\"\n", line("auth 0123456789abcdef\nstatus nope\n"))
+
+	var buf bytes.Buffer
+	handleStatus("config", nil, &buf)
+	if strings.Contains(buf.String(), "0123456789abcdef") {
+		t.Error("token in status config")
+	}
+
+	// Don't let the client dial connections in the background, as they may
+	// still be authenticating when the config is reset.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func(auth string) int {
+		req, err := http.NewRequest("GET", "http://"+l.Addr().String()+"/api/v1/status", nil)
+		tt.Err(t, err)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := client.Do(req)
+		tt.Err(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	tt.Eq(t, "no header", 401, get(""))
+	tt.Eq(t, "wrong header", 401, get("Bearer nope"))
+	tt.Eq(t, "header", 200, get("Bearer 0123456789abcdef"))
+}
+
+func TestAuthSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackwall-auth")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	tt.Err(t, err)
+	path := filepath.Join(dir, "control.sock")
+	unix, err := net.Listen("unix", path)
+	tt.Err(t, err)
+	Serve(tcp, unix)
+
+	conn, err := net.Dial("unix", path)
+	tt.Err(t, err)
+	defer conn.Close() // nolint: errcheck
+	fmt.Fprintf(conn, "status nope\n")
+	out, err := bufio.NewReader(conn).ReadString('\n')
+	tt.Err(t, err)
+
+	// Only root and the user we run as are allowed by default.
+	if os.Getuid() == 0 {
+		tt.Eq(t, "allowed", "error: unknown subcommand: \"nope\"\n", out)
+	} else {
+		tt.Eq(t, "denied", "error: permission denied\n", out)
+	}
+
+	// Loopback connections would bypass the socket permissions.
+	cfg.Config.ControlSocket = path
+	defer func() { cfg.Config.ControlSocket = "" }()
+	conn, err = net.Dial("tcp", tcp.Addr().String())
+	tt.Err(t, err)
+	defer conn.Close() // nolint: errcheck
+	fmt.Fprintf(conn, "status nope\n")
+	out, err = bufio.NewReader(conn).ReadString('\n')
+	tt.Err(t, err)
+	tt.Eq(t, "loopback", "error: permission denied\n", out)
+}
+
+func TestRequireAuth(t *testing.T) {
+	h := requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	local := peer{authed: true, loopback: true, name: "127.0.0.1"}
+	cases := []struct {
+		method, path, host, origin, referer string
+		peer                                peer
+		expectedCode                        int
+	}{
+		{"GET", "/api/v1/status", "127.0.0.1:4242", "", "", local, 204},
+		{"GET", "/api/v1/status", "localhost:4242", "", "", local, 204},
+		{"GET", "/api/v1/status", "[::1]:4242", "", "", local, 204},
+		{"GET", "/api/v1/status", "evil.example.com:4242", "", "", local, 403},
+		{"GET", "/api/v1/status", "127.0.0.1:4242", "", "", peer{name: "10.0.0.1"}, 401},
+		{"GET", "/", "127.0.0.1:4242", "", "", peer{name: "10.0.0.1"}, 204},
+		{"POST", "/api/v1/reload", "127.0.0.1:4242", "http://127.0.0.1:4242", "", local, 204},
+		{"POST", "/api/v1/reload", "127.0.0.1:4242", "http://evil.example.com", "", local, 403},
+		{"GET", "/api/v1/status", "trackwall.example.com", "", "", peer{authed: true, name: "cert"}, 204},
+		{"POST", "/api/v1/reload", "127.0.0.1:4242", "null", "", local, 403},
+		{"POST", "/api/v1/reload", "127.0.0.1:4242", "", "https://evil.example.com/", local, 403},
+
+		// Commands that change something are never allowed cross-origin, even
+		// with GET.
+		{"GET", "/pause/10y", "127.0.0.1:4242", "", "https://evil.example.com/", local, 403},
+		{"GET", "/override/flush", "127.0.0.1:4242", "http://evil.example.com", "", local, 403},
+		{"GET", "/status/summary", "127.0.0.1:4242", "", "https://evil.example.com/", local, 204},
+		{"GET", "/api/v1/status", "127.0.0.1:4242", "", "https://evil.example.com/", local, 204},
+		{"POST", "/cache/flush", "127.0.0.1:4242", "", "http://127.0.0.1:4242/", local, 204},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%v %v %v %v%v", tc.method, tc.path, tc.host, tc.origin, tc.referer), func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			r.Host = tc.host
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if tc.referer != "" {
+				r.Header.Set("Referer", tc.referer)
+			}
+			r = r.WithContext(context.WithValue(r.Context(), peerKey{}, tc.peer))
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, r)
+			tt.Eq(t, "code", tc.expectedCode, rr.Code)
+		})
+	}
+}
+
+func TestLegacyCrossOrigin(t *testing.T) {
+	defer srvdns.Pauses.Purge()
+	h := requireAuth(newMux())
+
+	r := httptest.NewRequest("GET", "/pause/10y", nil)
+	r.Host = "127.0.0.1:8081"
+	r.Header.Set("Referer", "https://evil.example/")
+	r = r.WithContext(context.WithValue(r.Context(), peerKey{}, peer{authed: true, loopback: true, name: "127.0.0.1"}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	tt.Eq(t, "code", http.StatusForbidden, rr.Code)
+	tt.Eq(t, "paused", 0, len(srvdns.Pauses.List()))
+}
@@ -4,27 +4,66 @@
 package srvctl
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"os"
 	"strings"
 
 	"arp242.net/trackwall/cfg"
 	"arp242.net/trackwall/msg"
+	"arp242.net/trackwall/srvhttp"
 )
 
 // Write to the server.
 func Write(what string) {
-	conn, err := net.Dial("tcp", cfg.Config.ControlListen.String())
+	conn, err := Dial()
 	msg.Fatal(err)
 	defer func() { _ = conn.Close() }()
 
-	fmt.Fprintf(conn, what+"\n")
+	fmt.Fprintf(conn, "%v\n", what)
 	data, err := ioutil.ReadAll(conn)
 	msg.Fatal(err)
 	fmt.Println(strings.TrimSpace(string(data)))
 }
 
+// Dial the server and authenticate. This uses control-socket if it's set, and
+// control-listen otherwise.
+//
+// The token is read from the TRACKWALL_CONTROL_TOKEN environment variable, or
+// control-token-file. With control-tls a short-lived client certificate is
+// issued, which requires access to the root key.
+func Dial() (net.Conn, error) {
+	if cfg.Config.ControlSocket != "" {
+		return net.Dial("unix", cfg.Config.ChrootDir(cfg.Config.ControlSocket))
+	}
+
+	addr := cfg.Config.ControlListen.String()
+	if cfg.Config.ControlTLS {
+		conf, err := srvhttp.ClientTLS(cfg.Config.ChrootDir(cfg.Config.RootCert),
+			cfg.Config.ChrootDir(cfg.Config.RootKey), cfg.Config.ControlListen.Host)
+		if err != nil {
+			return nil, fmt.Errorf("unable to make a client certificate: %v", err)
+		}
+		return tls.Dial("tcp", addr, conf)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	token := os.Getenv("TRACKWALL_CONTROL_TOKEN")
+	if token == "" {
+		token = cfg.Config.ControlToken()
+	}
+	if token != "" {
+		fmt.Fprintf(conn, "auth %v\n", token)
+	}
+	return conn, nil
+}
+
 // The MIT License (MIT)
 //
 // Copyright © 2016-2017 Martin Tournoij
@@ -0,0 +1,124 @@
+package srvctl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"arp242.net/trackwall/srvdns"
+)
+
+// connListener is a net.Listener for the connections handleConn() passes to
+// the HTTP server.
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{addr: addr, conns: make(chan net.Conn)}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	c, ok := <-l.conns
+	if !ok {
+		return nil, errors.New("listener closed")
+	}
+	return c, nil
+}
+
+func (l *connListener) Close() error   { return nil }
+func (l *connListener) Addr() net.Addr { return l.addr }
+
+// peekedConn is a connection of which we already read the first line.
+type peekedConn struct {
+	net.Conn
+	r    io.Reader
+	peer peer
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/status", apiStatus)
+	mux.HandleFunc("/api/v1/stats", apiStats)
+	mux.HandleFunc("/api/v1/events", apiEvents)
+	mux.HandleFunc("/api/v1/config", apiConfig)
+	mux.HandleFunc("/api/v1/hosts", apiHosts)
+	mux.HandleFunc("/api/v1/hosts/", apiHost)
+	mux.HandleFunc("/api/v1/regexps", apiRegexps)
+	mux.HandleFunc("/api/v1/overrides", apiOverrides)
+	mux.HandleFunc("/api/v1/overrides/", apiOverride)
+	mux.HandleFunc("/api/v1/pause", apiPause)
+	mux.HandleFunc("/api/v1/reports", apiReports)
+	mux.HandleFunc("/api/v1/reports/", apiReport)
+	mux.HandleFunc("/api/v1/cache", apiCache)
+	mux.HandleFunc("/api/v1/lists", apiLists)
+	mux.HandleFunc("/api/v1/lists/", apiListAction)
+	mux.HandleFunc("/api/v1/reload", apiReload)
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		apiError(w, http.StatusNotFound, errors.New("no such endpoint"))
+	})
+	mux.HandleFunc("/", handleLegacy)
+	return mux
+}
+
+// Handle the line protocol commands sent as HTTP, for example "GET
+// /status/summary". Only commands that don't change anything can be used with
+// GET; the others need POST.
+func handleLegacy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.URL.Path == "/" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		w.Header().Set("X-Frame-Options", "DENY")
+		_, _ = io.WriteString(w, tplDashboard)
+		return
+	}
+
+	input := legacyCommand(r)
+	if r.Method != http.MethodPost && (r.Method != http.MethodGet || !readOnly(input)) {
+		w.Header().Set("Allow", "POST")
+		if readOnly(input) {
+			w.Header().Set("Allow", "GET, POST")
+		}
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var buf bytes.Buffer
+	out := runCommand(&buf, input, remoteIP(addr(r)), requester(r))
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if strings.HasPrefix(out, "error: ") {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	_, _ = w.Write(buf.Bytes())
+	_, _ = io.WriteString(w, out+"\n")
+}
+
+// Get the line protocol command from the path of a request to handleLegacy().
+func legacyCommand(r *http.Request) []string {
+	return strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+}
+
+// Get the requester for the audit log.
+func requester(r *http.Request) srvdns.Requester {
+	ip := remoteIP(addr(r))
+	p, _ := r.Context().Value(peerKey{}).(peer)
+	by := p.requester(ip)
+	by.UserAgent = r.UserAgent()
+	return by
+}
+
+// Get the remote address of the request.
+func addr(r *http.Request) net.Addr {
+	a, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return a
+}
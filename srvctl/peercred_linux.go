@@ -0,0 +1,35 @@
+//go:build linux
+// +build linux
+
+package srvctl
+
+import (
+	"net"
+	"syscall"
+)
+
+// Anyone can connect to the socket; the peer credentials are checked.
+const socketMode = 0666
+
+const havePeerCred = true
+
+// Get the uid and gid of the process at the other end of the socket.
+func peerCred(c *net.UnixConn) (uid, gid int, err error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var cred *syscall.Ucred
+	var cerr error
+	err = raw.Control(func(fd uintptr) {
+		cred, cerr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if cerr != nil {
+		return 0, 0, cerr
+	}
+	return int(cred.Uid), int(cred.Gid), nil
+}
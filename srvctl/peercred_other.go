@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package srvctl
+
+import "net"
+
+// The peer credentials aren't supported, so rely on the permissions: only root
+// and the group of the user we run as can connect.
+const socketMode = 0660
+
+// control-allow-users and control-allow-groups can't be enforced.
+const havePeerCred = false
+
+func peerCred(c *net.UnixConn) (uid, gid int, err error) {
+	return 0, 0, errNoPeerCred
+}
@@ -6,87 +6,284 @@ package srvctl
 
 import (
 	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"os"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"arp242.net/trackwall/cfg"
 	"arp242.net/trackwall/msg"
 	"arp242.net/trackwall/srvdns"
+	"arp242.net/trackwall/srvhttp"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/miekg/dns"
 )
 
-// Bind the socket.
-func Bind() net.Listener {
-	l, err := net.Listen("tcp", cfg.Config.ControlListen.String())
+// Bind the sockets: control-listen, wrapped in TLS if control-tls is set, and
+// control-socket if it's set (unix is nil otherwise).
+func Bind() (tcp, unix net.Listener) {
+	tcp, err := net.Listen("tcp", cfg.Config.ControlListen.String())
 	msg.Fatal(err)
-	return l
+
+	if cfg.Config.ControlTLS {
+		conf, err := srvhttp.ControlTLS(cfg.Config.ControlListen.Host)
+		msg.Fatal(err)
+		tcp = tls.NewListener(tcp, conf)
+	} else if cfg.Config.ControlToken() == "" && cfg.Config.ControlSocket != "" {
+		msg.Warn(fmt.Errorf("control-socket is set, but there is no control-token-file or control-tls; control-listen %v won't allow any connections",
+			cfg.Config.ControlListen))
+	} else if cfg.Config.ControlToken() == "" && !isLoopback(cfg.Config.ControlListen.Host) {
+		msg.Warn(fmt.Errorf("control-listen %v is not a loopback address, but there is no control-token-file or control-tls; only local connections will be allowed",
+			cfg.Config.ControlListen))
+	}
+
+	if cfg.Config.ControlSocket != "" {
+		if !havePeerCred && (len(cfg.Config.ControlAllowUsers) > 0 || len(cfg.Config.ControlAllowGroups) > 0) {
+			msg.Fatal(fmt.Errorf("control-allow-users and control-allow-groups are not supported on %v; only root and the group of user can use control-socket",
+				runtime.GOOS))
+		}
+
+		path := cfg.Config.ControlSocket
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			msg.Fatal(err)
+		}
+		unix, err = net.Listen("unix", path)
+		msg.Fatal(err)
+		msg.Fatal(os.Chmod(path, socketMode))
+		if cfg.Config.User != nil {
+			msg.Fatal(os.Chown(path, 0, cfg.Config.User.GID))
+		}
+	}
+
+	return tcp, unix
+}
+
+func isLoopback(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
 
 // Serve requests.
-func Serve(l net.Listener) {
+//
+// The control socket accepts both the line protocol (see readCommand()) and
+// HTTP; the first line of the connection is used to tell the difference.
+func Serve(tcp, unix net.Listener) {
+	httpConns := newConnListener(tcp.Addr())
 	go func() {
-		for {
-			conn, err := l.Accept()
-			if err != nil {
-				msg.Warn(err)
-				continue
+		srv := &http.Server{Handler: requireAuth(newMux()), ConnContext: connContext}
+		err := srv.Serve(httpConns)
+		msg.Fatal(err)
+	}()
+
+	for _, l := range []net.Listener{tcp, unix} {
+		if l == nil {
+			continue
+		}
+		go func(l net.Listener) {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					msg.Warn(err)
+					continue
+				}
+				go handleConn(conn, httpConns)
 			}
-			go handleCtl(conn)
+		}(l)
+	}
+}
+
+// Match the request line of a HTTP request.
+var reHTTP = regexp.MustCompile(`^[A-Z]+ \S+ HTTP/1\.[01]\r?\n$`)
+
+// Pass HTTP connections to the HTTP server, and handle everything else as a
+// line protocol command.
+func handleConn(conn net.Conn, httpConns *connListener) {
+	p, err := authenticate(conn)
+	if err != nil {
+		msg.Warn(fmt.Errorf("control connection from %v: %v", conn.RemoteAddr(), err))
+		fmt.Fprintln(conn, "error: permission denied")
+		_ = conn.Close()
+		return
+	}
+
+	br := bufio.NewReader(conn)
+	line, err := br.ReadString('\n')
+	if err != nil {
+		msg.Warn(err)
+		_ = conn.Close()
+		return
+	}
+
+	if reHTTP.MatchString(line) {
+		httpConns.conns <- &peekedConn{conn, io.MultiReader(strings.NewReader(line), br), p}
+		return
+	}
+
+	// The line protocol sends the token as the first line.
+	if !p.authed {
+		if !authLine(line) {
+			msg.Warn(fmt.Errorf("control connection from %v: invalid token", conn.RemoteAddr()))
+			fmt.Fprintln(conn, "error: authentication required")
+			_ = conn.Close()
+			return
 		}
-	}()
+		line, err = br.ReadString('\n')
+		if err != nil {
+			msg.Warn(err)
+			_ = conn.Close()
+			return
+		}
+	}
+	handleCtl(conn, line, p)
 }
 
 const needSub = "error: need a subcommand"
 
-func handleCtl(conn net.Conn) {
+func handleCtl(conn net.Conn, line string, p peer) {
 	defer conn.Close() // nolint: errcheck
 
-	input, isHTTP, err := readCommand(conn)
+	input, _, err := readCommand(strings.NewReader(line))
 	if err != nil {
 		msg.Warn(err)
 		return
 	}
 
-	var w string
-	switch input[0] {
-	case "":
-		if isHTTP {
-			conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n")) // nolint: errcheck
-			w = tplIndex
-		} else {
-			w = fmt.Sprintf("error: unknown command: %#v", input[0])
+	if input[0] == "tail" {
+		handleTail(conn, input[1:])
+		return
+	}
+	client := remoteIP(conn.RemoteAddr())
+	fmt.Fprintln(conn, runCommand(conn, input, client, p.requester(client)))
+}
+
+// Send events as JSON, one per line, until the connection is closed: "tail
+// [client=ip] [name=regexp] [type=type,...]".
+func handleTail(conn net.Conn, args []string) {
+	var client, name, types string
+	for _, a := range args {
+		switch {
+		case a == "":
+		case strings.HasPrefix(a, "client="):
+			client = a[7:]
+		case strings.HasPrefix(a, "name="):
+			name = a[5:]
+		case strings.HasPrefix(a, "type="):
+			types = a[5:]
+		default:
+			fmt.Fprintf(conn, "error: unknown argument: %#v\n", a)
+			return
 		}
+	}
+	f, err := srvdns.ParseEventFilter(client, name, types)
+	if err != nil {
+		fmt.Fprintf(conn, "error: %v\n", err)
+		return
+	}
+
+	events, cancel := srvdns.Events.Subscribe(f)
+	defer cancel()
+
+	// Nothing is sent after the command, so a read returns when the client
+	// disconnects.
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, conn)
+		cancel()
+	}()
+
+	enc := json.NewEncoder(conn)
+	for e := range events {
+		if err := enc.Encode(e); err != nil {
+			return
+		}
+	}
+}
+
+// Run the command in input; detailed output is written to w, and the returned
+// string is the status (usually "okay" or an error).
+func runCommand(w io.Writer, input []string, client net.IP, by srvdns.Requester) string {
+	var out string
+	switch input[0] {
 	case "status":
 		if len(input) < 2 {
-			w = needSub
+			out = needSub
 		} else {
-			w = handleStatus(input[1], conn)
+			out = handleStatus(input[1], input[2:], w)
 		}
 	case "cache":
 		if len(input) < 2 {
-			w = needSub
+			out = needSub
 		} else {
-			w = handleCache(input[1], conn)
+			out = handleCache(input[1], w)
 		}
 	case "override":
 		if len(input) < 2 {
-			w = needSub
+			out = needSub
+		} else {
+			out = handleOverride(input[1], input[2:], w, by)
+		}
+	case "pause", "resume":
+		out = handlePause(input[0], input[1:])
+	case "report":
+		if len(input) < 2 {
+			out = needSub
+		} else {
+			out = handleReport(input[1], input[2:], w)
+		}
+	case "explain":
+		if len(input) < 2 || input[1] == "" {
+			out = "error: need a name"
+		} else {
+			out = handleExplain(input[1:], w, client)
+		}
+	case "query":
+		if len(input) < 2 || input[1] == "" {
+			out = "error: need a name"
 		} else {
-			w = handleOverride(input[1], conn)
+			out = handleQuery(input[1:], client)
 		}
 	case "host":
-	case "regex":
+		if len(input) < 2 {
+			out = needSub
+		} else {
+			out = handleHost(input[1], input[2:])
+		}
+	case "regexp":
+		if len(input) < 2 {
+			out = needSub
+		} else {
+			out = handleRegexp(input[1], input[2:])
+		}
 	default:
-		w = fmt.Sprintf("error: unknown command: %#v", input[0])
+		out = fmt.Sprintf("error: unknown command: %#v", input[0])
 	}
+	return out
+}
 
-	fmt.Fprintf(conn, w+"\n")
-	msg.Warn(err)
+// Check if the command only shows information, and doesn't change anything.
+func readOnly(input []string) bool {
+	switch {
+	case len(input) == 0:
+		return false
+	case input[0] == "status", input[0] == "explain", input[0] == "query":
+		return true
+	case input[0] == "override" && len(input) > 1:
+		return input[1] == "list"
+	case input[0] == "report" && len(input) > 1:
+		return input[1] == "list" || input[1] == "export"
+	}
+	return false
 }
 
 func readCommand(conn io.Reader) (
@@ -119,7 +316,7 @@ func readCommand(conn io.Reader) (
 	return input, isHTTP, nil
 }
 
-func handleCache(cmd string, w net.Conn) (out string) {
+func handleCache(cmd string, w io.Writer) (out string) {
 	switch cmd {
 	case "flush":
 		srvdns.Cache.Purge()
@@ -131,19 +328,467 @@ func handleCache(cmd string, w net.Conn) (out string) {
 	return out
 }
 
-func handleOverride(cmd string, w net.Conn) (out string) {
+// Manage the overrides: "override add [--exact] [--client=c] host duration",
+// "override extend [--client=c] host duration", "override rm [--client=c]
+// host...", "override list", and "override flush".
+//
+// Overrides apply to the host and all its subdomains, unless --exact is used.
+// They apply to all clients, unless --client is used with an IP address or
+// client group.
+//
+// All changes are recorded in the audit log, as made by the requester.
+func handleOverride(cmd string, args []string, w io.Writer, by srvdns.Requester) (out string) {
+	exact := false
+	client := ""
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		switch {
+		case args[0] == "--exact":
+			exact = true
+		case strings.HasPrefix(args[0], "--client="):
+			var err error
+			client, err = cfg.ParseOverrideClient(strings.TrimPrefix(args[0], "--client="))
+			if err != nil {
+				return fmt.Sprintf("error: %v", err)
+			}
+		default:
+			return fmt.Sprintf("error: unknown flag: %#v", args[0])
+		}
+		args = args[1:]
+	}
+
 	switch cmd {
 	case "flush":
-		cfg.Override.Purge()
-		out = "okay"
+		for k, e := range cfg.Override.List() {
+			srvdns.Unallow(k.Host, k.Client)
+			srvdns.Audit("remove", k, e, 0, by)
+		}
+	case "list":
+		listOverrides(w)
+	case "add", "extend":
+		if len(args) != 2 {
+			return "error: need a host and a duration"
+		}
+		host := strings.ToLower(strings.TrimRight(args[0], "."))
+		d, err := parseDuration(args[1])
+		if err != nil {
+			return fmt.Sprintf("error: %v", err)
+		}
+
+		exp := time.Now()
+		if cmd == "add" {
+			if !cfg.ValidHost(host) {
+				return fmt.Sprintf("error: invalid host: %#v", host)
+			}
+		} else {
+			cur, ok := cfg.Override.Get(cfg.OverrideKey{Host: host, Client: client})
+			if !ok {
+				return fmt.Sprintf("error: no override for %#v", host)
+			}
+			if cur.Expires > exp.Unix() {
+				exp = time.Unix(cur.Expires, 0)
+			}
+			exact = !cur.Subtree
+		}
+		k := cfg.OverrideKey{Host: host, Client: client}
+		srvdns.Allow(host, client, exp.Add(d), !exact)
+		e, _ := cfg.Override.Get(k)
+		srvdns.Audit(cmd, k, e, d, by)
+	case "rm":
+		if len(args) == 0 {
+			return "error: need at least one host"
+		}
+		for _, h := range args {
+			k := cfg.OverrideKey{Host: strings.ToLower(strings.TrimRight(h, ".")), Client: client}
+			e, ok := cfg.Override.Get(k)
+			if !ok {
+				return fmt.Sprintf("error: no override for %#v", k.Host)
+			}
+			srvdns.Unallow(k.Host, k.Client)
+			srvdns.Audit("remove", k, e, 0, by)
+		}
 	default:
-		out = fmt.Sprintf("error: unknown subcommand: %#v", cmd)
+		return fmt.Sprintf("error: unknown subcommand: %#v", cmd)
 	}
 
-	return out
+	return "okay"
+}
+
+// Write all overrides, with the client they apply to and the time until they
+// expire.
+func listOverrides(w io.Writer) {
+	list := cfg.Override.List()
+	keys := make([]cfg.OverrideKey, 0, len(list))
+	width, cwidth := 0, 0
+	for k := range list {
+		keys = append(keys, k)
+		if len(k.Host) > width {
+			width = len(k.Host)
+		}
+		if len(k.ClientName()) > cwidth {
+			cwidth = len(k.ClientName())
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Host == keys[j].Host {
+			return keys[i].Client < keys[j].Client
+		}
+		return keys[i].Host < keys[j].Host
+	})
+
+	now := time.Now()
+	for _, k := range keys {
+		exp := time.Unix(list[k].Expires, 0)
+		left := "expired"
+		if exp.After(now) {
+			left = "expires in " + exp.Sub(now).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%-*v  %-7v  %-*v  %v (%v)\n", width, k.Host, list[k].Scope(),
+			cwidth, k.ClientName(), left, exp.Format("2006-01-02 15:04:05"))
+	}
+}
+
+// Triage the reports of wrongly blocked hosts: "report list", "report promote
+// id...", "report dismiss id...", and "report export [id...]".
+//
+// Promoting a report removes the hosts that block it, and saves that to
+// cfg.AddedPath.
+func handleReport(cmd string, args []string, w io.Writer) string {
+	var reports []cfg.Report
+	for _, a := range args {
+		if a == "" {
+			continue
+		}
+		id, err := strconv.Atoi(a)
+		if err != nil {
+			return fmt.Sprintf("error: invalid ID: %#v", a)
+		}
+		r, ok := cfg.Reports.Get(id)
+		if !ok {
+			return fmt.Sprintf("error: no report %v", id)
+		}
+		reports = append(reports, r)
+	}
+
+	switch cmd {
+	case "list":
+		for _, r := range cfg.Reports.List() {
+			fmt.Fprintf(w, "%-4v %v  %v  blocked by %v", r.ID, r.Time.Local().Format("2006-01-02 15:04"), r.Host, r.Rule)
+			if r.List != "" {
+				fmt.Fprintf(w, " from %v", cfg.Origin{Source: r.List, Line: r.Line})
+			}
+			if r.Referer != "" {
+				fmt.Fprintf(w, " on %v", r.Referer)
+			}
+			fmt.Fprintln(w)
+		}
+		return ""
+	case "export":
+		if len(reports) == 0 {
+			reports = cfg.Reports.List()
+		}
+		cfg.ExportReports(w, reports)
+		return ""
+	case "promote", "dismiss":
+		if len(reports) == 0 {
+			return "error: need at least one ID"
+		}
+		for _, r := range reports {
+			if cmd == "promote" {
+				hosts := srvdns.BlockingHosts(r.Host)
+				if len(hosts) == 0 {
+					return fmt.Sprintf("error: report %v: %v isn't blocked by a host", r.ID, r.Host)
+				}
+				if err := editHosts(false, true, hosts...); err != nil {
+					return fmt.Sprintf("error: report %v: %v", r.ID, err)
+				}
+			}
+			if err := cfg.Reports.Remove(r.ID); err != nil {
+				return fmt.Sprintf("error: %v", err)
+			}
+		}
+	default:
+		return fmt.Sprintf("error: unknown subcommand: %#v", cmd)
+	}
+	return "okay"
+}
+
+// Pause blocking: "pause [--client=c] duration", or resume it: "resume
+// [--client=c]". Without --client this applies to all clients; resume without
+// --client also removes the pauses for single clients and client groups.
+func handlePause(cmd string, args []string) string {
+	client, all := "", true
+	if len(args) > 0 && strings.HasPrefix(args[0], "--client=") {
+		var err error
+		client, err = cfg.ParseOverrideClient(strings.TrimPrefix(args[0], "--client="))
+		if err != nil {
+			return fmt.Sprintf("error: %v", err)
+		}
+		args, all = args[1:], false
+	}
+	if len(args) == 1 && args[0] == "" {
+		args = nil
+	}
+
+	switch cmd {
+	case "pause":
+		if len(args) != 1 {
+			return "error: need a duration"
+		}
+		d, err := parseDuration(args[0])
+		if err != nil {
+			return fmt.Sprintf("error: %v", err)
+		}
+		srvdns.Pauses.Pause(client, time.Now().Add(d))
+	case "resume":
+		if len(args) != 0 {
+			return "error: resume takes no arguments"
+		}
+		if all {
+			srvdns.Pauses.Purge()
+		} else if !srvdns.Pauses.Resume(client) {
+			return fmt.Sprintf("error: not paused for %#v", client)
+		}
+	}
+	return "okay"
+}
+
+// Describe the pauses, as "no" or "all for 9m59s, 10.0.0.1 for 1h0m0s".
+func pauseSummary() string {
+	list := srvdns.Pauses.List()
+	if len(list) == 0 {
+		return "no"
+	}
+	clients := make([]string, 0, len(list))
+	for c := range list {
+		clients = append(clients, c)
+	}
+	sort.Strings(clients)
+
+	now := time.Now()
+	out := make([]string, 0, len(list))
+	for _, c := range clients {
+		left := time.Unix(list[c], 0).Sub(now).Round(time.Second)
+		if c == "" {
+			c = "all"
+		}
+		out = append(out, fmt.Sprintf("%v for %v", c, left))
+	}
+	return strings.Join(out, ", ")
+}
+
+// Write the audit log of the overrides.
+func overrideHistory(w io.Writer) string {
+	entries, err := srvdns.ReadAudit()
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	for _, e := range entries {
+		by := e.By.Client
+		if e.By.User != "" {
+			by = e.By.User
+		}
+		fmt.Fprintf(w, "%v  %-6v  %v  %v  for %v", e.Time.Local().Format("2006-01-02 15:04:05"),
+			e.Action, e.Host, e.Scope, e.For)
+		if e.Duration != "" {
+			fmt.Fprintf(w, "  %v", e.Duration)
+		}
+		fmt.Fprintf(w, "  by %v", by)
+		if e.By.UserAgent != "" {
+			fmt.Fprintf(w, "  %q", e.By.UserAgent)
+		}
+		if e.By.Referer != "" {
+			fmt.Fprintf(w, "  from %v", e.By.Referer)
+		}
+		fmt.Fprintln(w)
+	}
+	return ""
+}
+
+// Parse a duration; this accepts both the Go syntax ("1h30m") and the syntax
+// used on the blocked page ("1d", "2w"); see msg.DurationToSeconds().
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, errors.New("need a duration")
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		secs, err2 := msg.DurationToSeconds(s)
+		if err2 != nil {
+			return 0, fmt.Errorf("invalid duration: %#v", s)
+		}
+		d = time.Duration(secs) * time.Second
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid duration: %#v", s)
+	}
+	return d, nil
+}
+
+// Get the --persist flag from the arguments.
+func persistFlag(args []string) ([]string, bool) {
+	if len(args) > 0 && args[0] == "--persist" {
+		return args[1:], true
+	}
+	return args, false
+}
+
+// Add or remove hosts: "host add [--persist] host..." and "host rm [--persist]
+// host...".
+func handleHost(cmd string, args []string) (out string) {
+	args, persist := persistFlag(args)
+	if cmd != "add" && cmd != "rm" {
+		return fmt.Sprintf("error: unknown subcommand: %#v", cmd)
+	}
+	if err := editHosts(cmd == "add", persist, args...); err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	return "okay"
 }
 
-func handleStatus(cmd string, w dns.Writer) (out string) {
+// Add or remove regexps: "regexp add [--persist] regexp..." and "regexp rm
+// [--persist] regexp...".
+func handleRegexp(cmd string, args []string) (out string) {
+	args, persist := persistFlag(args)
+	if cmd != "add" && cmd != "rm" {
+		return fmt.Sprintf("error: unknown subcommand: %#v", cmd)
+	}
+	if err := editRegexps(cmd == "add", persist, args...); err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	return "okay"
+}
+
+// inputError is an error in the hosts or regexps passed to editHosts() or
+// editRegexps(); nothing was changed.
+type inputError string
+
+func (e inputError) Error() string { return string(e) }
+
+// Add or remove hosts, and optionally save the change.
+func editHosts(add, persist bool, args ...string) error {
+	if len(args) == 0 {
+		return inputError("need at least one host")
+	}
+
+	hosts := make([]string, 0, len(args))
+	for _, h := range args {
+		h = strings.ToLower(strings.TrimRight(h, "."))
+		if !cfg.ValidHost(h) {
+			return inputError(fmt.Sprintf("invalid host: %#v", h))
+		}
+		hosts = append(hosts, h)
+	}
+
+	o := cfg.Origin{Source: "control"}
+	if persist {
+		o.Source = cfg.AddedPath
+	}
+	if add {
+		cfg.Hosts.Add(hosts...)
+		cfg.Origins.Set(cfg.OriginHost, o, hosts...)
+	} else {
+		cfg.Hosts.Remove(hosts...)
+		cfg.Origins.Set(cfg.OriginUnhost, o, hosts...)
+	}
+	srvdns.Cache.DeleteDomain(hosts...)
+
+	if persist {
+		if err := cfg.SaveAdded(cfg.OriginHost, add, hosts...); err != nil {
+			return fmt.Errorf("applied, but not saved: %v", err)
+		}
+	}
+	return nil
+}
+
+// Add or remove regexps, and optionally save the change.
+func editRegexps(add, persist bool, regexps ...string) error {
+	if len(regexps) == 0 {
+		return inputError("need at least one regexp")
+	}
+	for _, re := range regexps {
+		if _, err := regexp.Compile(re); err != nil {
+			return inputError(fmt.Sprintf("invalid regexp: %v", err))
+		}
+	}
+
+	o := cfg.Origin{Source: "control"}
+	if persist {
+		o.Source = cfg.AddedPath
+	}
+	if add {
+		for _, re := range regexps {
+			if !cfg.Regexps.Has(re) {
+				cfg.Regexps.Add(re)
+			}
+		}
+		cfg.Origins.Set(cfg.OriginRegexp, o, regexps...)
+	} else {
+		cfg.Regexps.Remove(regexps...)
+		cfg.Origins.Set(cfg.OriginUnregexp, o, regexps...)
+	}
+	// No way to know which names a regexp matches.
+	srvdns.Cache.Purge()
+
+	if persist {
+		if err := cfg.SaveAdded(cfg.OriginRegexp, add, regexps...); err != nil {
+			return fmt.Errorf("applied, but not saved: %v", err)
+		}
+	}
+	return nil
+}
+
+// Explain why a name is blocked; the client is the address the command was sent
+// from if it's not given.
+func handleExplain(args []string, w io.Writer, client net.IP) (out string) {
+	if len(args) > 1 && args[1] != "" {
+		client = net.ParseIP(args[1])
+		if client == nil {
+			return fmt.Sprintf("error: invalid client IP: %#v", args[1])
+		}
+	}
+
+	srvdns.Explain(w, args[0], client)
+	return ""
+}
+
+// Resolve a name through the DNS server: "query name [type] [client]".
+func handleQuery(args []string, client net.IP) (out string) {
+	qtype := dns.TypeA
+	if len(args) > 1 && args[1] != "" {
+		var err error
+		qtype, err = srvdns.ParseType(args[1])
+		if err != nil {
+			return fmt.Sprintf("error: %v", err)
+		}
+	}
+
+	if len(args) > 2 && args[2] != "" {
+		client = net.ParseIP(args[2])
+		if client == nil {
+			return fmt.Sprintf("error: invalid client IP: %#v", args[2])
+		}
+	}
+
+	r, err := srvdns.QueryServer(args[0], qtype, client)
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	return strings.TrimSpace(r.String())
+}
+
+// Get the IP address of the client that sent the command; this is localhost
+// for the unix socket.
+func remoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UnixAddr:
+		return net.IPv4(127, 0, 0, 1)
+	}
+	return nil
+}
+
+func handleStatus(cmd string, args []string, w io.Writer) (out string) {
 	scs := spew.ConfigState{Indent: "\t"}
 
 	switch cmd {
@@ -154,7 +799,10 @@ func handleStatus(cmd string, w dns.Writer) (out string) {
 
 		fmt.Fprintf(w, "hosts:             %v\n", cfg.Hosts.Len())
 		fmt.Fprintf(w, "regexps:           %v\n", cfg.Regexps.Len())
+		fmt.Fprintf(w, "filters:           %v\n", cfg.Filters.Len())
+		fmt.Fprintf(w, "rpz triggers:      %v\n", cfg.RPZ.Len())
 		fmt.Fprintf(w, "cache items:       %v\n", srvdns.Cache.Len())
+		fmt.Fprintf(w, "paused:            %v\n", pauseSummary())
 		fmt.Fprintf(w, "memory allocated:  %vKb\n", stats.Sys/1024)
 	case "config":
 		scs.Fdump(w, cfg.Config)
@@ -165,8 +813,15 @@ func handleStatus(cmd string, w dns.Writer) (out string) {
 		cfg.Hosts.Dump(w)
 	case "regexps":
 		cfg.Regexps.Dump(w)
-	case "override":
-		cfg.Override.Dump(w)
+	case "filters":
+		cfg.Filters.Dump(w)
+	case "rpz":
+		cfg.RPZ.Dump(w)
+	case "override", "overrides":
+		if len(args) > 0 && args[0] == "--history" {
+			return overrideHistory(w)
+		}
+		listOverrides(w)
 	default:
 		out = fmt.Sprintf("error: unknown subcommand: %#v", cmd)
 	}
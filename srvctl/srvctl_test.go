@@ -1,11 +1,21 @@
 package srvctl
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/srvdns"
 	"arp242.net/trackwall/tt"
+
+	"github.com/miekg/dns"
 )
 
 func TestReadCommand(t *testing.T) {
@@ -33,3 +43,186 @@ func TestReadCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleHost(t *testing.T) {
+	defer cfg.Hosts.Purge()
+	defer cfg.Origins.Purge()
+	defer srvdns.Cache.Purge()
+
+	tt.Eq(t, "add", "okay", handleHost("add", []string{"Example.com."}))
+	_, ok := cfg.Hosts.Get("example.com")
+	tt.Eq(t, "added", true, ok)
+
+	srvdns.Configure("127.0.0.1:1", 3600, "127.0.0.53", 0)
+	srvdns.Query("www.example.com", dns.TypeA, nil)
+	tt.Eq(t, "cached", 1, srvdns.Cache.Len())
+	tt.Eq(t, "rm", "okay", handleHost("rm", []string{"example.com"}))
+	_, ok = cfg.Hosts.Get("example.com")
+	tt.Eq(t, "removed", false, ok)
+	tt.Eq(t, "cache invalidated", 0, srvdns.Cache.Len())
+
+	tt.Eq(t, "invalid", `error: invalid host: "127.0.0.1"`, handleHost("add", []string{"127.0.0.1"}))
+	tt.Eq(t, "no hosts", "error: need at least one host", handleHost("add", []string{"--persist"}))
+	tt.Eq(t, "subcommand", `error: unknown subcommand: "x"`, handleHost("x", []string{"example.com"}))
+}
+
+func TestHandleRegexp(t *testing.T) {
+	defer cfg.Regexps.Purge()
+	defer cfg.Origins.Purge()
+
+	tt.Eq(t, "add", "okay", handleRegexp("add", []string{`^ads?\.`}))
+	tt.Eq(t, "add again", "okay", handleRegexp("add", []string{`^ads?\.`}))
+	tt.Eq(t, "len", 1, cfg.Regexps.Len())
+	tt.Eq(t, "rm", "okay", handleRegexp("rm", []string{`^ads?\.`}))
+	tt.Eq(t, "len", 0, cfg.Regexps.Len())
+
+	out := handleRegexp("add", []string{`(`})
+	if !strings.HasPrefix(out, "error: invalid regexp") {
+		t.Errorf("wrong output: %v", out)
+	}
+}
+
+func TestHandleOverride(t *testing.T) {
+	defer cfg.Override.Purge()
+	defer srvdns.Cache.Purge()
+
+	srvdns.Configure("127.0.0.1:1", 3600, "127.0.0.53", 0)
+	srvdns.Query("www.example.com", dns.TypeA, nil)
+	tt.Eq(t, "cached", 1, srvdns.Cache.Len())
+
+	tt.Eq(t, "add", "okay", handleOverride("add", []string{"Example.com.", "1h"}, nil, srvdns.Requester{}))
+	exp, ok := cfg.Override.Get(cfg.OverrideKey{Host: "example.com"})
+	tt.Eq(t, "added", true, ok)
+	tt.Eq(t, "cache invalidated", 0, srvdns.Cache.Len())
+	tt.Eq(t, "subtree", true, exp.Subtree)
+	if d := time.Until(time.Unix(exp.Expires, 0)); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("wrong expiry: %v", d)
+	}
+
+	tt.Eq(t, "extend", "okay", handleOverride("extend", []string{"example.com", "1d"}, nil, srvdns.Requester{}))
+	exp2, _ := cfg.Override.Get(cfg.OverrideKey{Host: "example.com"})
+	tt.Eq(t, "extended", exp.Expires+86400, exp2.Expires)
+
+	var buf bytes.Buffer
+	tt.Eq(t, "list", "okay", handleOverride("list", nil, &buf, srvdns.Requester{}))
+	if !regexp.MustCompile(`^example\.com  subtree  all  expires in (24h59m|25h0m)`).MatchString(buf.String()) {
+		t.Errorf("wrong output: %q", buf.String())
+	}
+
+	tt.Eq(t, "rm", "okay", handleOverride("rm", []string{"example.com"}, nil, srvdns.Requester{}))
+	_, ok = cfg.Override.Get(cfg.OverrideKey{Host: "example.com"})
+	tt.Eq(t, "removed", false, ok)
+
+	tt.Eq(t, "add exact", "okay", handleOverride("add", []string{"--exact", "example.com", "1h"}, nil, srvdns.Requester{}))
+	tt.Eq(t, "extend exact", "okay", handleOverride("extend", []string{"example.com", "1h"}, nil, srvdns.Requester{}))
+	exp, _ = cfg.Override.Get(cfg.OverrideKey{Host: "example.com"})
+	tt.Eq(t, "exact", false, exp.Subtree)
+	tt.Eq(t, "flush", "okay", handleOverride("flush", nil, nil, srvdns.Requester{}))
+	tt.Eq(t, "flushed", 0, len(cfg.Override.List()))
+
+	tt.Eq(t, "add client", "okay", handleOverride("add", []string{"--exact", "--client=10.0.0.1", "example.com", "1h"}, nil, srvdns.Requester{}))
+	exp, ok = cfg.Override.Get(cfg.OverrideKey{Host: "example.com", Client: "10.0.0.1"})
+	tt.Eq(t, "client", true, ok)
+	tt.Eq(t, "client exact", false, exp.Subtree)
+	tt.Eq(t, "rm other client", `error: no override for "example.com"`, handleOverride("rm", []string{"example.com"}, nil, srvdns.Requester{}))
+	tt.Eq(t, "rm client", "okay", handleOverride("rm", []string{"--client=10.0.0.1", "example.com"}, nil, srvdns.Requester{}))
+	tt.Eq(t, "removed client", 0, len(cfg.Override.List()))
+
+	cases := []struct {
+		cmd      string
+		args     []string
+		expected string
+	}{
+		{"add", []string{"example.com"}, "error: need a host and a duration"},
+		{"add", []string{"1.2.3.4", "1h"}, `error: invalid host: "1.2.3.4"`},
+		{"add", []string{"example.com", "-1h"}, `error: invalid duration: "-1h"`},
+		{"add", []string{"example.com", "x"}, `error: invalid duration: "x"`},
+		{"extend", []string{"example.com", "1h"}, `error: no override for "example.com"`},
+		{"rm", []string{"example.com"}, `error: no override for "example.com"`},
+		{"rm", nil, "error: need at least one host"},
+		{"add", []string{"--client=kids", "example.com", "1h"}, `error: not an IP address or client group: "kids"`},
+		{"add", []string{"--nope", "example.com", "1h"}, `error: unknown flag: "--nope"`},
+		{"x", nil, `error: unknown subcommand: "x"`},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%v %v", tc.cmd, tc.args), func(t *testing.T) {
+			tt.Eq(t, "out", tc.expected, handleOverride(tc.cmd, tc.args, nil, srvdns.Requester{}))
+		})
+	}
+}
+
+func TestHandlePause(t *testing.T) {
+	defer srvdns.Pauses.Purge()
+
+	tt.Eq(t, "summary", "no", pauseSummary())
+	tt.Eq(t, "pause", "okay", handlePause("pause", []string{"10m"}))
+	tt.Eq(t, "pause client", "okay", handlePause("pause", []string{"--client=10.0.0.1", "1h"}))
+	if !regexp.MustCompile(`^all for (9m59s|10m0s), 10\.0\.0\.1 for (59m59s|1h0m0s)$`).MatchString(pauseSummary()) {
+		t.Errorf("wrong summary: %q", pauseSummary())
+	}
+	tt.Eq(t, "resume client", "okay", handlePause("resume", []string{"--client=10.0.0.1", ""}))
+	tt.Eq(t, "resume client again", `error: not paused for "10.0.0.1"`, handlePause("resume", []string{"--client=10.0.0.1"}))
+	tt.Eq(t, "pause client", "okay", handlePause("pause", []string{"--client=10.0.0.1", "1h"}))
+	tt.Eq(t, "resume all", "okay", handlePause("resume", []string{""}))
+	tt.Eq(t, "summary", "no", pauseSummary())
+
+	tt.Eq(t, "no duration", "error: need a duration", handlePause("pause", []string{""}))
+	tt.Eq(t, "bad duration", `error: invalid duration: "x"`, handlePause("pause", []string{"x"}))
+	tt.Eq(t, "bad client", `error: not an IP address or client group: "x"`, handlePause("pause", []string{"--client=x", "1h"}))
+}
+
+func TestOverrideHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackwall-audit")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	defer cfg.Override.Purge()
+	tt.Err(t, srvdns.OpenAudit(filepath.Join(dir, "overrides.log")))
+
+	by := srvdns.Requester{Client: "127.0.0.1", User: "uid 1000"}
+	tt.Eq(t, "add", "okay", handleOverride("add", []string{"--exact", "example.com", "1h"}, nil, by))
+	tt.Eq(t, "rm", "okay", handleOverride("rm", []string{"example.com"}, nil, by))
+
+	var buf bytes.Buffer
+	tt.Eq(t, "history", "", handleStatus("overrides", []string{"--history"}, &buf))
+	re := regexp.MustCompile(`^\S+ \S+  add     example\.com  exact  for all  1h0m0s  by uid 1000\n` +
+		`\S+ \S+  remove  example\.com  exact  for all  by uid 1000\n$`)
+	if !re.MatchString(buf.String()) {
+		t.Errorf("wrong output:\n%v", buf.String())
+	}
+}
+
+func TestHandleReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackwall-reports")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	tt.Err(t, cfg.Reports.Load(filepath.Join(dir, "reports.json")))
+	defer cfg.Reports.Load("") // nolint: errcheck
+
+	for _, h := range []string{"a.example.com", "b.example.com"} {
+		_, err := cfg.Reports.Add(cfg.Report{Host: h, Rule: "||" + h + "^", List: "/lists/ads.txt", Line: 4})
+		tt.Err(t, err)
+	}
+
+	var buf bytes.Buffer
+	tt.Eq(t, "list", "", handleReport("list", []string{""}, &buf))
+	re := regexp.MustCompile(`^1    \S+ \S+  a\.example\.com  blocked by \|\|a\.example\.com\^ from /lists/ads\.txt line 4\n` +
+		`2    \S+ \S+  b\.example\.com  blocked by \|\|b\.example\.com\^ from /lists/ads\.txt line 4\n$`)
+	if !re.MatchString(buf.String()) {
+		t.Errorf("wrong output:\n%v", buf.String())
+	}
+
+	buf.Reset()
+	tt.Eq(t, "export", "", handleReport("export", []string{"2"}, &buf))
+	tt.Eq(t, "export", "# False positives in /lists/ads.txt\n||b.example.com^  # line 4; blocks b.example.com\n", buf.String())
+
+	tt.Eq(t, "dismiss", "okay", handleReport("dismiss", []string{"1"}, nil))
+	tt.Eq(t, "dismiss again", "error: no report 1", handleReport("dismiss", []string{"1"}, nil))
+	tt.Eq(t, "no ID", "error: need at least one ID", handleReport("dismiss", []string{""}, nil))
+	tt.Eq(t, "bad ID", `error: invalid ID: "x"`, handleReport("promote", []string{"x"}, nil))
+	tt.Eq(t, "not a host", "error: report 2: b.example.com isn't blocked by a host", handleReport("promote", []string{"2"}, nil))
+	tt.Eq(t, "unknown", `error: unknown subcommand: "x"`, handleReport("x", nil, nil))
+
+	// Saved.
+	tt.Err(t, cfg.Reports.Load(filepath.Join(dir, "reports.json")))
+	tt.Eq(t, "left", 1, len(cfg.Reports.List()))
+}
@@ -1,8 +1,340 @@
 package srvctl
 
-const tplIndex = `
-<strong>status</strong>
-<ul>
-	<li><a href="/status/summary">summary</a></li>
-</ul>
+// The dashboard, served on / of the control listener. This uses the JSON API
+// for everything, and must not load anything from elsewhere.
+const tplDashboard = `<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>trackwall</title>
+	<style>
+		body         { font: 14px/1.4 sans-serif; margin: 0; color: #222; background: #f6f6f6; }
+		header       { background: #333; color: #fff; padding: .5em 1em; display: flex; align-items: center; }
+		header h1    { font-size: 1.2em; margin: 0 1em 0 0; }
+		header a     { color: #ddd; margin-right: 1em; text-decoration: none; }
+		header a.active { color: #fff; font-weight: bold; }
+		header .opts { margin-left: auto; font-size: .9em; }
+		main         { padding: 1em; }
+		section      { display: none; }
+		section.active { display: block; }
+		.cards       { display: flex; flex-wrap: wrap; gap: 1em; margin-bottom: 1em; }
+		.card        { background: #fff; border: 1px solid #ddd; padding: .5em 1em; min-width: 8em; }
+		.card b      { display: block; font-size: 1.6em; }
+		.cols        { display: flex; flex-wrap: wrap; gap: 1em; }
+		.cols > div  { flex: 1; min-width: 18em; }
+		table        { border-collapse: collapse; width: 100%; background: #fff; margin-bottom: 1em; }
+		th, td       { text-align: left; padding: .2em .5em; border-bottom: 1px solid #eee; }
+		td.n         { text-align: right; }
+		tr.blocked td.name { color: #b50; }
+		button       { font-size: .85em; cursor: pointer; }
+		pre          { background: #fff; border: 1px solid #ddd; padding: .5em; overflow: auto; }
+		#error       { display: none; background: #fdd; border: 1px solid #c88; padding: .5em 1em; margin-bottom: 1em; }
+		#login       { display: none; background: #fff; border: 1px solid #ddd; padding: 1em; margin-bottom: 1em; }
+	</style>
+</head>
+<body>
+<header>
+	<h1>trackwall</h1>
+	<a href="#overview">Overview</a>
+	<a href="#overrides">Overrides</a>
+	<a href="#hosts">Hosts</a>
+	<a href="#reports">Reports</a>
+	<a href="#lists">Lists</a>
+	<a href="#config">Config</a>
+	<span class="opts">
+		Allow for <select id="duration">
+			<option>10m</option><option selected>1h</option><option>24h</option><option>168h</option>
+		</select>
+		<label><input type="checkbox" id="persist"> Save blocks</label>
+		<button data-pause>Pause blocking</button>
+		<button data-resume>Resume</button>
+	</span>
+</header>
+<main>
+	<div id="error"></div>
+	<form id="login">
+		<p>This server requires a token (from <code>control-token-file</code>).</p>
+		<input type="password" id="token" size="40" autocomplete="off">
+		<button type="submit">Log in</button>
+	</form>
+
+	<section id="overview">
+		<div class="cards" id="cards"></div>
+		<div class="cols">
+			<div><h3>Top blocked</h3><table id="top-blocked"></table></div>
+			<div><h3>Top allowed</h3><table id="top-allowed"></table></div>
+			<div><h3>Top clients</h3><table id="top-clients"></table></div>
+		</div>
+		<h3>Recent queries</h3>
+		<table id="recent"></table>
+	</section>
+
+	<section id="overrides">
+		<form id="override-add">
+			<input id="override-host" placeholder="example.com" size="40">
+			<input id="override-client" placeholder="all clients" size="15">
+			<button type="submit">Allow</button>
+		</form>
+		<table id="override-list"></table>
+	</section>
+
+	<section id="hosts">
+		<form id="host-search">
+			<input id="host-q" placeholder="Search" size="40">
+			<button type="submit">Search</button>
+			<button type="button" id="host-add">Block</button>
+		</form>
+		<p id="host-total"></p>
+		<table id="host-list"></table>
+	</section>
+
+	<section id="reports">
+		<p><button data-export>Export</button></p>
+		<table id="report-list"></table>
+		<pre id="report-export"></pre>
+	</section>
+
+	<section id="lists">
+		<p>
+			<button data-list="refresh">Refresh all</button>
+			<button data-reload>Reload rules</button>
+			<button data-flush>Flush cache</button>
+		</p>
+		<table id="list-list"></table>
+	</section>
+
+	<section id="config">
+		<pre id="config-json"></pre>
+	</section>
+</main>
+
+<script>
+(function() {
+	'use strict';
+
+	var $ = function(id) { return document.getElementById(id); };
+
+	var esc = function(s) {
+		return String(s).replace(/[&<>"']/g, function(c) {
+			return {'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'}[c];
+		});
+	};
+
+	var button = function(action, arg, label) {
+		return '<button data-action="' + action + '" data-arg="' + esc(arg) + '">' + label + '</button>';
+	};
+
+	var fmtTime = function(t) { return new Date(t).toLocaleString(); };
+
+	var error = function(err) {
+		$('error').textContent = err ? String(err.message || err) : '';
+		$('error').style.display = err ? 'block' : 'none';
+	};
+
+	var api = function(method, path, body) {
+		var headers = {};
+		var token = sessionStorage.getItem('trackwall-token');
+		if (token)
+			headers['Authorization'] = 'Bearer ' + token;
+		if (body)
+			headers['Content-Type'] = 'application/json';
+
+		return fetch('/api/v1/' + path, {
+			method:      method,
+			headers:     headers,
+			body:        body ? JSON.stringify(body) : undefined,
+			credentials: 'omit',
+		}).then(function(r) {
+			if (r.status === 401) {
+				$('login').style.display = 'block';
+				throw new Error('authentication required');
+			}
+			if (r.status === 204)
+				return null;
+			if (r.ok && r.headers.get('Content-Type').indexOf('text/plain') === 0)
+				return r.text();
+			return r.json().then(function(j) {
+				if (!r.ok)
+					throw new Error(j.error);
+				return j;
+			});
+		});
+	};
+
+	var counts = function(id, list, action, label) {
+		$(id).innerHTML = (list || []).map(function(c) {
+			return '<tr><td class="name">' + esc(c.name) + '</td><td class="n">' + c.count + '</td>' +
+				(action ? '<td>' + button(action, c.name, label) + '</td>' : '') + '</tr>';
+		}).join('') || '<tr><td>Nothing yet</td></tr>';
+	};
+
+	var pages = {
+		overview: function() {
+			return Promise.all([api('GET', 'stats?n=25'), api('GET', 'status')]).then(function(r) {
+				var s = r[0], st = r[1];
+				var pct = s.queries ? Math.round(s.blocked / s.queries * 100) : 0;
+				$('cards').innerHTML = [
+					['Queries', s.queries],
+					['Blocked', s.blocked + ' (' + pct + '%)'],
+					['From cache', s.cached],
+					['Hosts', st.hosts],
+					['Regexps', st.regexps],
+					['Overrides', st.overrides],
+					['Paused', st.paused],
+					['Memory', Math.round(st.memory_kb / 1024) + 'M'],
+				].map(function(c) {
+					return '<div class="card">' + esc(c[0]) + '<b>' + esc(c[1]) + '</b></div>';
+				}).join('');
+
+				counts('top-blocked', s.top_blocked, 'allow', 'Allow');
+				counts('top-allowed', s.top_allowed, 'block', 'Block');
+				counts('top-clients', s.top_clients);
+
+				$('recent').innerHTML = '<tr><th>Time</th><th>Client</th><th>Type</th><th>Name</th><th>Response</th><th></th></tr>' +
+					s.recent.map(function(q) {
+						return '<tr class="' + (q.blocked ? 'blocked' : '') + '">' +
+							'<td>' + esc(fmtTime(q.time)) + '</td>' +
+							'<td>' + esc(q.client) + '</td>' +
+							'<td>' + esc(q.type) + '</td>' +
+							'<td class="name">' + esc(q.name) + '</td>' +
+							'<td>' + esc(q.response) + (q.cached ? ' (cached)' : '') + '</td>' +
+							'<td>' + (q.blocked ? button('allow', q.name, 'Allow') : button('block', q.name, 'Block')) + '</td>' +
+							'</tr>';
+					}).join('');
+			});
+		},
+
+		overrides: function() {
+			return api('GET', 'overrides').then(function(r) {
+				$('override-list').innerHTML = '<tr><th>Host</th><th>Scope</th><th>Client</th><th>Expires</th><th></th></tr>' +
+					r.overrides.map(function(o) {
+						var p = encodeURIComponent(o.host) + '?client=' + encodeURIComponent(o.client);
+						return '<tr><td>' + esc(o.host) + '</td><td>' + esc(o.scope) + '</td><td>' + esc(o.client) + '</td><td>' + esc(fmtTime(o.expires)) + '</td><td>' +
+							button('extend', p, 'Extend') + ' ' + button('unallow', p, 'Remove') + '</td></tr>';
+					}).join('');
+			});
+		},
+
+		hosts: function() {
+			return api('GET', 'hosts?per_page=100&q=' + encodeURIComponent($('host-q').value)).then(function(r) {
+				$('host-total').textContent = r.total + ' hosts' + (r.total > r.hosts.length ? ', showing the first ' + r.hosts.length : '');
+				$('host-list').innerHTML = r.hosts.map(function(h) {
+					return '<tr><td>' + esc(h) + '</td><td>' + button('unblock', h, 'Remove') + '</td></tr>';
+				}).join('');
+			});
+		},
+
+		reports: function() {
+			return api('GET', 'reports').then(function(r) {
+				$('report-list').innerHTML = '<tr><th>Time</th><th>Host</th><th>Rule</th><th>List</th><th>Page</th><th></th></tr>' +
+					r.reports.map(function(p) {
+						return '<tr><td>' + esc(fmtTime(p.time)) + '</td><td class="name">' + esc(p.host) + '</td><td>' + esc(p.rule) + '</td>' +
+							'<td>' + esc(p.list) + (p.line ? ':' + p.line : '') + '</td><td>' + esc(p.referer) + '</td><td>' +
+							button('promote', p.id, 'Always allow') + ' ' + button('dismiss', p.id, 'Dismiss') + '</td></tr>';
+					}).join('') || '<tr><td>Nothing yet</td></tr>';
+			});
+		},
+
+		lists: function() {
+			return api('GET', 'lists').then(function(r) {
+				$('list-list').innerHTML = '<tr><th>Kind</th><th>Format</th><th>URL</th><th>Modified</th><th>Size</th><th></th></tr>' +
+					(r.lists || []).map(function(l) {
+						return '<tr><td>' + esc(l.kind) + '</td><td>' + esc(l.format) + '</td><td>' + esc(l.url) + '</td>' +
+							'<td>' + (l.size ? esc(fmtTime(l.modified)) : '') + '</td><td class="n">' + l.size + '</td><td>' +
+							button('refresh', l.url, 'Refresh') + ' ' +
+							(l.enabled ? button('disable', l.url, 'Disable') : button('enable', l.url, 'Enable')) +
+							'</td></tr>';
+					}).join('');
+			});
+		},
+
+		config: function() {
+			return api('GET', 'config').then(function(r) {
+				$('config-json').textContent = JSON.stringify(r, null, 2);
+			});
+		},
+	};
+
+	var page = function() { return (location.hash || '#overview').substr(1); };
+
+	var show = function() {
+		var p = pages[page()] ? page() : 'overview';
+		Array.prototype.forEach.call(document.querySelectorAll('section'), function(s) {
+			s.className = s.id === p ? 'active' : '';
+		});
+		Array.prototype.forEach.call(document.querySelectorAll('header a'), function(a) {
+			a.className = a.getAttribute('href') === '#' + p ? 'active' : '';
+		});
+		return pages[p]().then(function() { error(); }, error);
+	};
+
+	var actions = {
+		allow:   function(h) { return api('POST', 'overrides', {host: h, client: $('override-client').value, duration: $('duration').value}); },
+		extend:  function(p) { return api('PUT', 'overrides/' + p, {duration: $('duration').value}); },
+		unallow: function(p) { return api('DELETE', 'overrides/' + p); },
+		block:   function(h) { return api('POST', 'hosts', {hosts: [h], persist: $('persist').checked}); },
+		unblock: function(h) { return api('DELETE', 'hosts/' + encodeURIComponent(h) + '?persist=' + $('persist').checked); },
+		promote: function(id) { return api('POST', 'reports/' + id + '/promote'); },
+		dismiss: function(id) { return api('DELETE', 'reports/' + id); },
+		refresh: function(u) { return api('POST', 'lists/refresh?url=' + encodeURIComponent(u)); },
+		enable:  function(u) { return api('POST', 'lists/enable?url=' + encodeURIComponent(u)); },
+		disable: function(u) { return api('POST', 'lists/disable?url=' + encodeURIComponent(u)); },
+	};
+
+	document.addEventListener('click', function(e) {
+		var t = e.target;
+		var run = null;
+		if (t.dataset.action)
+			run = actions[t.dataset.action](t.dataset.arg);
+		else if (t.dataset.list)
+			run = api('POST', 'lists/refresh');
+		else if (t.hasAttribute('data-reload'))
+			run = api('POST', 'reload');
+		else if (t.hasAttribute('data-flush'))
+			run = api('DELETE', 'cache');
+		else if (t.hasAttribute('data-pause'))
+			run = api('POST', 'pause', {duration: $('duration').value});
+		else if (t.hasAttribute('data-resume'))
+			run = api('DELETE', 'pause');
+		else if (t.hasAttribute('data-export'))
+			run = api('GET', 'reports/export').then(function(r) { $('report-export').textContent = r || 'Nothing to export'; });
+		if (!run)
+			return;
+
+		t.disabled = true;
+		run.then(show, error).then(function() { t.disabled = false; });
+	});
+
+	$('override-add').addEventListener('submit', function(e) {
+		e.preventDefault();
+		actions.allow($('override-host').value).then(function() { $('override-host').value = ''; }).then(show, error);
+	});
+
+	$('host-search').addEventListener('submit', function(e) {
+		e.preventDefault();
+		show();
+	});
+	$('host-add').addEventListener('click', function() {
+		actions.block($('host-q').value).then(show, error);
+	});
+
+	$('login').addEventListener('submit', function(e) {
+		e.preventDefault();
+		sessionStorage.setItem('trackwall-token', $('token').value);
+		$('token').value = '';
+		$('login').style.display = 'none';
+		show();
+	});
+
+	window.addEventListener('hashchange', show);
+	setInterval(function() {
+		if (page() === 'overview' && !document.hidden && $('login').style.display !== 'block')
+			show();
+	}, 5000);
+	show();
+})();
+</script>
+</body>
+</html>
 `
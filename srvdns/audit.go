@@ -0,0 +1,120 @@
+package srvdns
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/msg"
+)
+
+// AuditPath is the location in the chroot of the log with all changes to the
+// overrides.
+const AuditPath = "/overrides.log"
+
+// Requester is who made a change.
+type Requester struct {
+	Client    string `json:"client,omitempty"`     // IP address.
+	User      string `json:"user,omitempty"`       // Peer of the control connection.
+	UserAgent string `json:"user_agent,omitempty"` // From the blocked page.
+	Referer   string `json:"referer,omitempty"`    // From the blocked page.
+}
+
+// AuditEntry is a change to the overrides.
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"` // "add", "extend", or "remove".
+	Host     string    `json:"host"`
+	Scope    string    `json:"scope"`
+	For      string    `json:"for"` // Client the override applies to.
+	Duration string    `json:"duration,omitempty"`
+	By       Requester `json:"by"`
+}
+
+// The audit log; changes aren't logged if it's not opened.
+var audit struct {
+	sync.Mutex
+	path string
+	fp   *os.File
+}
+
+// OpenAudit opens the audit log at path; entries are appended.
+//
+// This is done before dropping privileges, and the file isn't readable by the
+// user we run as, so it's also read through this file.
+func OpenAudit(path string) error {
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	audit.Lock()
+	if audit.fp != nil {
+		_ = audit.fp.Close()
+	}
+	audit.path, audit.fp = path, fp
+	audit.Unlock()
+	return nil
+}
+
+// Audit records a change to the override for k, by the requester. The
+// duration is zero for removals.
+func Audit(action string, k cfg.OverrideKey, e cfg.OverrideEntry, d time.Duration, by Requester) {
+	entry := AuditEntry{
+		Time:   time.Now(),
+		Action: action,
+		Host:   k.Host,
+		Scope:  e.Scope(),
+		For:    k.ClientName(),
+		By:     by,
+	}
+	if d > 0 {
+		entry.Duration = d.Round(time.Second).String()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		msg.Warn(fmt.Errorf("audit: %v", err))
+		return
+	}
+
+	audit.Lock()
+	defer audit.Unlock()
+	if audit.fp == nil {
+		return
+	}
+	if _, err := audit.fp.Write(append(data, '\n')); err != nil {
+		msg.Warn(fmt.Errorf("audit: %v", err))
+	}
+}
+
+// ReadAudit reads all entries from the audit log, oldest first.
+func ReadAudit() ([]AuditEntry, error) {
+	audit.Lock()
+	defer audit.Unlock()
+	if audit.fp == nil {
+		return nil, nil
+	}
+
+	st, err := audit.fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Use ReadAt() through a SectionReader, so the offset for writing isn't
+	// changed.
+	var entries []AuditEntry
+	scan := bufio.NewScanner(io.NewSectionReader(audit.fp, 0, st.Size()))
+	for i := 1; scan.Scan(); i++ {
+		var e AuditEntry
+		if err := json.Unmarshal(scan.Bytes(), &e); err != nil {
+			return entries, fmt.Errorf("%v line %v: %v", audit.path, i, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scan.Err()
+}
@@ -0,0 +1,60 @@
+package srvdns
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/tt"
+)
+
+func TestAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackwall-audit")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	defer func() {
+		_ = audit.fp.Close()
+		audit.path, audit.fp = "", nil
+	}()
+
+	// Not logged if there's no log.
+	k := cfg.OverrideKey{Host: "example.com", Client: "10.0.0.1"}
+	Audit("add", k, cfg.OverrideEntry{}, time.Hour, Requester{})
+	entries, err := ReadAudit()
+	tt.Err(t, err)
+	tt.Eq(t, "entries", 0, len(entries))
+
+	path := filepath.Join(dir, "overrides.log")
+	tt.Err(t, OpenAudit(path))
+	by := Requester{Client: "10.0.0.2", UserAgent: "Mozilla/5.0", Referer: "http://example.com/"}
+	Audit("add", k, cfg.OverrideEntry{Subtree: true}, 90*time.Minute, by)
+	Audit("remove", cfg.OverrideKey{Host: "example.org"}, cfg.OverrideEntry{}, 0, Requester{Client: "127.0.0.1", User: "uid 1000"})
+
+	// Appended after reopening.
+	tt.Err(t, OpenAudit(path))
+	Audit("extend", k, cfg.OverrideEntry{Subtree: true}, time.Hour, by)
+
+	entries, err = ReadAudit()
+	tt.Err(t, err)
+	tt.Eq(t, "entries", 3, len(entries))
+	for i := range entries {
+		entries[i].Time = time.Time{}
+	}
+	tt.Eq(t, "entries", []AuditEntry{
+		{Action: "add", Host: "example.com", Scope: "subtree", For: "10.0.0.1", Duration: "1h30m0s", By: by},
+		{Action: "remove", Host: "example.org", Scope: "exact", For: "all", By: Requester{Client: "127.0.0.1", User: "uid 1000"}},
+		{Action: "extend", Host: "example.com", Scope: "subtree", For: "10.0.0.1", Duration: "1h0m0s", By: by},
+	}, entries)
+
+	// Read through the opened file, as the server can't open it again after
+	// dropping privileges; writing still appends after reading.
+	tt.Err(t, os.Remove(path))
+	Audit("remove", k, cfg.OverrideEntry{}, 0, by)
+	entries, err = ReadAudit()
+	tt.Err(t, err)
+	tt.Eq(t, "entries", 4, len(entries))
+	tt.Eq(t, "action", "remove", entries[3].Action)
+}
@@ -2,6 +2,8 @@ package srvdns
 
 import (
 	"io"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,6 +22,15 @@ type CacheEntry struct {
 	expires  int64
 }
 
+// CacheItem is a cache entry with the key split out, for display.
+type CacheItem struct {
+	Type     string    `json:"type"`
+	Name     string    `json:"name"`
+	Client   string    `json:"client"`
+	Response string    `json:"response"`
+	Expires  time.Time `json:"expires"`
+}
+
 // Cache of spoofing actions.
 //
 // We don't cache the actual DNS responses − that's the resolver's job. We just
@@ -63,6 +74,81 @@ func (l *CacheList) Delete(keys ...string) {
 	}
 }
 
+// DeleteName deletes the items for these names, for all query types and
+// clients.
+func (l *CacheList) DeleteName(names ...string) {
+	l.Lock()
+	defer l.Unlock()
+	for k := range l.m {
+		// "A example.com" or "A example.com 127.0.0.1"
+		f := strings.Split(k, " ")
+		if len(f) < 2 {
+			continue
+		}
+		for _, n := range names {
+			if f[1] == n {
+				delete(l.m, k)
+				break
+			}
+		}
+	}
+}
+
+// DeleteDomain deletes the items for these names and all their subdomains, for
+// all query types and clients.
+func (l *CacheList) DeleteDomain(names ...string) {
+	l.Lock()
+	defer l.Unlock()
+	for k := range l.m {
+		f := strings.Split(k, " ")
+		if len(f) < 2 {
+			continue
+		}
+		for _, n := range names {
+			if f[1] == n || strings.HasSuffix(f[1], "."+n) {
+				delete(l.m, k)
+				break
+			}
+		}
+	}
+}
+
+// Items gets the entries for name, or all entries if name is "", sorted by
+// name.
+func (l *CacheList) Items(name string) []CacheItem {
+	l.RLock()
+	items := []CacheItem{}
+	for k, v := range l.m {
+		// The client is only in the key if a filter depends on it.
+		f := strings.Split(k, " ")
+		if len(f) < 2 || (name != "" && f[1] != name) {
+			continue
+		}
+		item := CacheItem{
+			Type:     f[0],
+			Name:     f[1],
+			Response: responseNames[v.response],
+			Expires:  time.Unix(v.expires, 0),
+		}
+		if len(f) > 2 {
+			item.Client = f[2]
+		}
+		items = append(items, item)
+	}
+	l.RUnlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Name != items[j].Name {
+			return items[i].Name < items[j].Name
+		}
+		if items[i].Type != items[j].Type {
+			return items[i].Type < items[j].Type
+		}
+		return items[i].Client < items[j].Client
+	})
+	return items
+}
+
 // Purge the entire cache
 func (l *CacheList) Purge() {
 	l.Lock()
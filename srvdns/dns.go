@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"arp242.net/trackwall/cfg"
@@ -17,13 +18,19 @@ import (
 )
 
 const (
-	reponseForward = 1
-	reponseSpoof   = 2
-	reponseEmpty   = 3
+	reponseForward  = 1
+	reponseSpoof    = 2
+	reponseEmpty    = 3
+	reponseRewrite  = 4
+	reponseNXDomain = 5
+	reponseDrop     = 6
+	reponseTCPOnly  = 7
+	reponsePolicy   = 8
 )
 
 // From config
 var (
+	dnsListen  string
 	dnsForward string
 	dnsCache   int64
 	httpAddr   string
@@ -35,10 +42,8 @@ var (
 // TODO: Splitting out the binding of the socket and starting a server is not
 // easy with the dns API, so we don't for now.
 func Serve(addr string, fwd string, cache int64, http string, v int) (*dns.Server, *dns.Server) {
-	dnsForward = fwd
-	dnsCache = cache
-	httpAddr = http
-	verbose = v
+	Configure(fwd, cache, http, v)
+	dnsListen = addr
 	dns.HandleFunc(".", handleDNS)
 
 	dnsUDP := &dns.Server{Addr: addr, Net: "udp"}
@@ -53,34 +58,57 @@ func Serve(addr string, fwd string, cache int64, http string, v int) (*dns.Serve
 		msg.Fatal(err)
 	}()
 
-	// Remove old cache items every 5 minutes.
+	// Remove old cache items and overrides every 5 minutes.
 	go func() {
 		for {
 			time.Sleep(5 * time.Minute)
 			Cache.PurgeExpired(1000)
+			expireOverrides()
 		}
 	}()
 
 	return dnsUDP, dnsTCP
 }
 
+// Configure the DNS server without starting it; this is done by Serve(), and is
+// only needed to use Query() without a running server.
+func Configure(fwd string, cache int64, http string, v int) {
+	dnsForward = fwd
+	dnsCache = cache
+	httpAddr = http
+	verbose = v
+}
+
 // Handle a DNS request: either forward or spoof it.
 func handleDNS(w dns.ResponseWriter, req *dns.Msg) {
+	client := requestClient(w, req)
+	response, fromCache := resolve(w, req, client)
+	if response != 0 {
+		q := newLoggedQuery(strings.TrimRight(req.Question[0].Name, "."), req.Question[0].Qtype,
+			client, response, fromCache)
+		Stats.Record(q)
+		Events.Publish(Event{LoggedQuery: q, Kind: "dns"})
+	}
+}
+
+// Reply to a DNS request from client, and return the response* constant and if
+// it came from the cache. The response is 0 if the request isn't a regular
+// query.
+func resolve(w dns.ResponseWriter, req *dns.Msg, client net.IP) (response uint8, fromCache bool) {
 	// No or invalid question section? Just bail out.
 	if len(req.Question) == 0 {
 		dns.HandleFailed(w, req)
-		return
+		return 0, false
 	}
 
-	// We only need to spoof A and AAAA records; we can forward everything else.
-	t := dns.TypeToString[req.Question[0].Qtype]
-	if t != "A" && t != "AAAA" {
-		forward(dnsForward, w, req)
-		return
+	// Queries for the zone we serve.
+	if served != nil && served.handle(w, req) {
+		return 0, false
 	}
 
 	name := strings.TrimRight(req.Question[0].Name, ".")
-	response, fromCache := getResponse(name, t)
+	qtype := req.Question[0].Qtype
+	response, fromCache = getResponse(name, qtype, client)
 
 	switch response {
 	case reponseForward:
@@ -95,24 +123,117 @@ func handleDNS(w dns.ResponseWriter, req *dns.Msg) {
 		spoof(name, w, req)
 	case reponseEmpty:
 		spoofEmpty(w, req)
+	case reponseRewrite:
+		if !fromCache {
+			msg.Infoc(fmt.Sprintf("rewrite  %v", name), "orange", verbose)
+		}
+		rewrite(name, client, w, req)
+	case reponseNXDomain:
+		if !fromCache {
+			msg.Infoc(fmt.Sprintf("nxdomain %v", name), "orange", verbose)
+		}
+		sendSpoof([]dns.RR{}, dns.RcodeNameError, w, req)
+	case reponseDrop:
+		if !fromCache {
+			msg.Infoc(fmt.Sprintf("drop     %v", name), "orange", verbose)
+		}
+	case reponseTCPOnly:
+		if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+			forward(dnsForward, w, req)
+		} else {
+			truncate(w, req)
+		}
+	case reponsePolicy:
+		if !fromCache {
+			msg.Infoc(fmt.Sprintf("policy   %v", name), "orange", verbose)
+		}
+		policy(name, w, req)
 	}
+	return response, fromCache
+}
+
+// Get the client's IP address.
+func clientIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// Get the client that sent req. Queries sent from this host can set a different
+// client with the EDNS client subnet option, which is what "trackwall query
+// --client" does; the option is removed so it's not forwarded.
+func requestClient(w dns.ResponseWriter, req *dns.Msg) net.IP {
+	ip := clientIP(w.RemoteAddr())
+	opt := req.IsEdns0()
+	if opt == nil || !localIP(ip) {
+		return ip
+	}
+
+	for i, o := range opt.Option {
+		// Only a single address, and not a network.
+		s, ok := o.(*dns.EDNS0_SUBNET)
+		if !ok || !(s.Family == 1 && s.SourceNetmask == 32 || s.Family == 2 && s.SourceNetmask == 128) {
+			continue
+		}
+		opt.Option = append(opt.Option[:i], opt.Option[i+1:]...)
+		return s.Address
+	}
+	return ip
+}
+
+// The addresses of this host; they're looked up at most once a minute, rather
+// than on every query.
+var localAddrs struct {
+	sync.Mutex
+	addrs   []net.Addr
+	updated time.Time
+}
+
+// Check if ip is an address of this host.
+func localIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+
+	localAddrs.Lock()
+	defer localAddrs.Unlock()
+	if time.Since(localAddrs.updated) > time.Minute {
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			msg.Warn(fmt.Errorf("unable to get the interface addresses: %v", err))
+		}
+		localAddrs.addrs, localAddrs.updated = addrs, time.Now()
+	}
+	for _, a := range localAddrs.addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
 }
 
 // Get response from cache (if it exists and is not expired), or determine a new
 // response.
-func getResponse(name, t string) (response uint8, fromCache bool) {
-	if checkOverride(name) {
+func getResponse(name string, qtype uint16, client net.IP) (response uint8, fromCache bool) {
+	if Pauses.Paused(client) || checkOverride(name, client) {
 		return reponseForward, false
 	}
 
-	cachekey := t + " " + name
+	cachekey := cacheKey(name, qtype, client)
 
 	cache, haveCache := Cache.Get(cachekey)
 	if haveCache && cache.expires > time.Now().Unix() {
 		return cache.response, true
 	}
 
-	response = determineResponse(name, t)
+	response = determineResponse(name, qtype, client)
 	Cache.Store(cachekey, CacheEntry{
 		expires:  time.Now().Unix() + dnsCache,
 		response: response,
@@ -121,41 +242,70 @@ func getResponse(name, t string) (response uint8, fromCache bool) {
 	return response, false
 }
 
-// Determine what to do with the hostname name.
+// Get the cache key for name; the client is only added if a filter depends on
+// it, so that all clients share the same entry otherwise.
+func cacheKey(name string, qtype uint16, client net.IP) string {
+	k := dns.TypeToString[qtype] + " " + name
+	if cfg.Filters.ForClient() {
+		k += " " + client.String()
+	}
+	return k
+}
+
+// Determine what to do with the hostname name for a query of type qtype from
+// client.
 //
 // Returns a response* constant.
-func determineResponse(name, t string) uint8 {
-	var doSpoof bool
-
-	haveOverride := checkOverride(name)
-	if haveOverride {
-		doSpoof = false
-	}
-
-	if !haveOverride {
-		// Hosts
-		labels := strings.Split(name, ".")
-		c := ""
-		l := len(labels)
-		for i := 0; i < l; i++ {
-			if c == "" {
-				c = labels[l-i-1]
-			} else {
-				c = labels[l-i-1] + "." + c
-			}
-
-			_, doSpoof = cfg.Hosts.Get(c)
-			if doSpoof {
-				break
-			}
+func determineResponse(name string, qtype uint16, client net.IP) uint8 {
+	if Pauses.Paused(client) || checkOverride(name, client) {
+		return reponseForward
+	}
+
+	// Filters can apply to any type.
+	if f := cfg.Filters.Match(name, qtype, client); f != nil {
+		switch {
+		case f.Exception:
+			return reponseForward
+		case f.Rewrite != nil:
+			return reponseRewrite
+		case qtype == dns.TypeA:
+			return reponseSpoof
+		default:
+			return reponseEmpty
 		}
+	}
 
-		// Regexps
-		if !doSpoof {
-			doSpoof = cfg.Regexps.Match(name)
+	// As do response policy zones. PASSTHRU only means that the other zones
+	// don't apply; the hosts and regexps are still checked.
+	if p := cfg.RPZ.Match(name); p != nil {
+		switch p.Action() {
+		case cfg.PolicyPassthru:
+		case cfg.PolicyNXDomain:
+			return reponseNXDomain
+		case cfg.PolicyNoData:
+			return reponseEmpty
+		case cfg.PolicyDrop:
+			return reponseDrop
+		case cfg.PolicyTCPOnly:
+			return reponseTCPOnly
+		default:
+			return reponsePolicy
 		}
 	}
 
+	// We only need to spoof A and AAAA records; we can forward everything else.
+	if qtype != dns.TypeA && qtype != dns.TypeAAAA {
+		return reponseForward
+	}
+
+	// Hosts
+	_, doSpoof := matchHost(name)
+
+	// Regexps
+	if !doSpoof {
+		doSpoof = cfg.Regexps.Match(name)
+	}
+
 	// For now, we just pretend that AAAA records that we want to spoof don't
 	// exist (EMPTY).
 	// TODO: This could be better, but I'm not sure how to properly do this. We
@@ -163,7 +313,7 @@ func determineResponse(name, t string) uint8 {
 	// daemons, HTTP servers, etc. (/etc/resolv.conf doesn't support adding a
 	// port number). IPv6 only has one loopback address (::1) and nota /8 like
 	// IPv4...
-	if doSpoof && t == "AAAA" {
+	if doSpoof && qtype == dns.TypeAAAA {
 		return reponseEmpty
 	} else if doSpoof {
 		return reponseSpoof
@@ -172,35 +322,84 @@ func determineResponse(name, t string) uint8 {
 	}
 }
 
-func checkOverride(name string) bool {
-	expires, haveOverride := cfg.Override.Get(name)
-
-	if !haveOverride {
-		labels := strings.Split(name, ".")
-		c := ""
-		l := len(labels)
-		for i := 0; i < l; i++ {
-			if c == "" {
-				c = labels[l-i-1]
-			} else {
-				c = labels[l-i-1] + "." + c
-			}
-
-			expires, haveOverride = cfg.Override.Get(c)
-			if haveOverride {
-				break
-			}
+// Find the host in the hosts list that blocks name; this is either name itself
+// or one of its parent domains.
+func matchHost(name string) (string, bool) {
+	labels := strings.Split(name, ".")
+	c := ""
+	l := len(labels)
+	for i := 0; i < l; i++ {
+		if c == "" {
+			c = labels[l-i-1]
+		} else {
+			c = labels[l-i-1] + "." + c
+		}
+
+		if _, ok := cfg.Hosts.Get(c); ok {
+			return c, true
 		}
 	}
+	return "", false
+}
+
+// Check if there is an override for name from client that's not expired.
+func checkOverride(name string, client net.IP) bool {
+	_, e, ok := cfg.Override.Match(name, client)
+	if ok && time.Now().Unix() > e.Expires {
+		// There may be another override for a parent domain or for all
+		// clients.
+		expireOverrides()
+		_, _, ok = cfg.Override.Match(name, client)
+	}
+	return ok
+}
+
+// Remove expired overrides.
+func expireOverrides() {
+	Cache.DeleteDomain(cfg.Override.PurgeExpired()...)
+}
+
+// Allow name for client until exp, and invalidate the cached responses. The
+// client is an IP address, a client group, or "" for all clients. With subtree
+// all subdomains are allowed as well.
+func Allow(name, client string, exp time.Time, subtree bool) {
+	cfg.Override.Store(cfg.OverrideKey{Host: name, Client: client},
+		cfg.OverrideEntry{Expires: exp.Unix(), Subtree: subtree})
+	if subtree {
+		Cache.DeleteDomain(name)
+	} else {
+		Cache.DeleteName(name)
+	}
+}
 
-	// Make sure it's not expired
-	if haveOverride {
-		if time.Now().Unix() > expires {
-			cfg.Override.Delete(name)
-			haveOverride = false
+// Unallow removes the override for host and client, and invalidates the cached
+// responses.
+func Unallow(host, client string) {
+	cfg.Override.Delete(cfg.OverrideKey{Host: host, Client: client})
+	Cache.DeleteDomain(host)
+}
+
+// BlockingHost gets the host in the hosts list that blocks name, or name itself
+// if it's not blocked by a host.
+func BlockingHost(name string) string {
+	if host, ok := matchHost(name); ok {
+		return host
+	}
+	return name
+}
+
+// BlockingHosts gets all the hosts in the hosts list that block name: name
+// itself and its parent domains, from the least to the most specific.
+func BlockingHosts(name string) []string {
+	var hosts []string
+	labels := strings.Split(name, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		h := strings.Join(labels[i:], ".")
+		if _, ok := cfg.Hosts.Get(h); ok {
+			hosts = append(hosts, h)
 		}
 	}
-	return haveOverride
+	return hosts
 }
 
 // Spoof DNS response by replying with the address of our HTTP server.
@@ -210,19 +409,85 @@ func spoof(name string, w dns.ResponseWriter, req *dns.Msg) {
 	rr, err := dns.NewRR(spec)
 	msg.Fatal(err)
 
-	sendSpoof([]dns.RR{rr}, w, req)
+	sendSpoof([]dns.RR{rr}, dns.RcodeSuccess, w, req)
 }
 
 // Spoof DNS response by replying with an empty answer section.
 func spoofEmpty(w dns.ResponseWriter, req *dns.Msg) {
-	sendSpoof([]dns.RR{}, w, req)
+	sendSpoof([]dns.RR{}, dns.RcodeSuccess, w, req)
+}
+
+// Reply with the response from a $dnsrewrite filter.
+func rewrite(name string, client net.IP, w dns.ResponseWriter, req *dns.Msg) {
+	qtype := req.Question[0].Qtype
+
+	// The filters may have changed since the response was cached.
+	f := cfg.Filters.Match(name, qtype, client)
+	if f == nil || f.Rewrite == nil {
+		forward(dnsForward, w, req)
+		return
+	}
+
+	answer := []dns.RR{}
+	if rr := f.Rewrite.RR(name, qtype); rr != nil {
+		answer = append(answer, rr)
+	}
+	sendSpoof(resolveCNAME(answer, qtype), f.Rewrite.Rcode, w, req)
+}
+
+// Reply with the local data from a response policy zone.
+func policy(name string, w dns.ResponseWriter, req *dns.Msg) {
+	qtype := req.Question[0].Qtype
+
+	// The zone may have changed since the response was cached.
+	p := cfg.RPZ.Match(name)
+	if p == nil || p.Action() != cfg.PolicyLocal {
+		forward(dnsForward, w, req)
+		return
+	}
+
+	answer := append([]dns.RR{}, p.Answer(name, qtype)...)
+	sendSpoof(resolveCNAME(answer, qtype), dns.RcodeSuccess, w, req)
+}
+
+// Reply with an empty truncated response, so the client retries over TCP.
+func truncate(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Truncated = true
+	err := w.WriteMsg(m)
+	if err != nil {
+		msg.Warn(fmt.Errorf("unable to write DNS request for %v: %v", req.Question[0], err))
+	}
+}
+
+// Resolve the target if the answer is a CNAME with the forward server, and
+// append the records to the answer.
+func resolveCNAME(answer []dns.RR, qtype uint16) []dns.RR {
+	if len(answer) == 0 || qtype == dns.TypeCNAME {
+		return answer
+	}
+	cname, ok := answer[0].(*dns.CNAME)
+	if !ok {
+		return answer
+	}
+
+	target := new(dns.Msg)
+	target.SetQuestion(cname.Target, qtype)
+	resp, _, err := (&dns.Client{}).Exchange(target, dnsForward)
+	if err != nil {
+		msg.Warn(fmt.Errorf("unable to resolve CNAME target %v: %v", cname.Target, err))
+		return answer
+	}
+	return append(answer, resp.Answer...)
 }
 
 // Make a message with the answer and write it to the client
-func sendSpoof(answer []dns.RR, w dns.ResponseWriter, req *dns.Msg) {
+func sendSpoof(answer []dns.RR, rcode int, w dns.ResponseWriter, req *dns.Msg) {
 	var spoof dns.Msg
 	spoof.MsgHdr.Id = req.MsgHdr.Id
 	spoof.MsgHdr.Response = true
+	spoof.MsgHdr.Rcode = rcode
 	spoof.MsgHdr.RecursionDesired = true
 	spoof.MsgHdr.RecursionAvailable = true
 	spoof.Question = req.Question
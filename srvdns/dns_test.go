@@ -0,0 +1,155 @@
+package srvdns
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/tt"
+
+	"github.com/miekg/dns"
+)
+
+func TestOverride(t *testing.T) {
+	defer cfg.Hosts.Purge()
+	defer cfg.Override.Purge()
+	defer Cache.Purge()
+
+	Cache.Purge()
+	Configure("127.0.0.1:1", 3600, "127.0.0.53", 0)
+	cfg.Hosts.Add("tracker.example.com")
+	client := net.ParseIP("127.0.0.1")
+
+	tt.Eq(t, "spoof", "spoof", Query("a.b.tracker.example.com", dns.TypeA, client).Response)
+	tt.Eq(t, "spoof", "spoof", Query("c.tracker.example.com", dns.TypeA, client).Response)
+	tt.Eq(t, "cached", 2, Cache.Len())
+
+	// Allowing a subdomain stores the override against the host that blocked it,
+	// and invalidates the cache for all subdomains.
+	host := BlockingHost("a.b.tracker.example.com")
+	tt.Eq(t, "blocking host", "tracker.example.com", host)
+	Allow(host, "", time.Now().Add(time.Hour), true)
+	tt.Eq(t, "cache invalidated", 0, Cache.Len())
+	tt.Eq(t, "allowed", "forward", Query("a.b.tracker.example.com", dns.TypeA, client).Response)
+	tt.Eq(t, "allowed", "forward", Query("c.tracker.example.com", dns.TypeA, client).Response)
+	Unallow(host, "")
+
+	// Exact scope.
+	Allow("c.tracker.example.com", "", time.Now().Add(time.Hour), false)
+	tt.Eq(t, "exact", "forward", Query("c.tracker.example.com", dns.TypeA, client).Response)
+	tt.Eq(t, "exact", "spoof", Query("www.c.tracker.example.com", dns.TypeA, client).Response)
+
+	// The expired override is removed, but the one for the parent domain still
+	// applies.
+	Allow("tracker.example.com", "", time.Now().Add(time.Hour), true)
+	Allow("c.tracker.example.com", "", time.Now().Add(-time.Second), false)
+	tt.Eq(t, "expired", "forward", Query("c.tracker.example.com", dns.TypeA, client).Response)
+	_, ok := cfg.Override.Get(cfg.OverrideKey{Host: "c.tracker.example.com"})
+	tt.Eq(t, "expired removed", false, ok)
+	_, ok = cfg.Override.Get(cfg.OverrideKey{Host: "tracker.example.com"})
+	tt.Eq(t, "parent kept", true, ok)
+
+	Allow("tracker.example.com", "", time.Now().Add(-time.Second), true)
+	tt.Eq(t, "all expired", "spoof", Query("c.tracker.example.com", dns.TypeA, client).Response)
+	tt.Eq(t, "overrides", 0, len(cfg.Override.List()))
+
+	// Overrides for a single client or client group.
+	other := net.ParseIP("10.0.1.1")
+	_, n, _ := net.ParseCIDR("10.0.0.0/24")
+	cfg.Config.ClientGroups = map[string][]*net.IPNet{"kids": {n}}
+	defer func() { cfg.Config.ClientGroups = nil }()
+
+	Allow("tracker.example.com", "127.0.0.1", time.Now().Add(time.Hour), true)
+	tt.Eq(t, "client", "forward", Query("c.tracker.example.com", dns.TypeA, client).Response)
+	tt.Eq(t, "other client", "spoof", Query("c.tracker.example.com", dns.TypeA, other).Response)
+	Allow("tracker.example.com", "kids", time.Now().Add(time.Hour), true)
+	tt.Eq(t, "group", "forward", Query("c.tracker.example.com", dns.TypeA, net.ParseIP("10.0.0.2")).Response)
+	tt.Eq(t, "other client", "spoof", Query("c.tracker.example.com", dns.TypeA, other).Response)
+}
+
+func TestBlockingHosts(t *testing.T) {
+	defer cfg.Hosts.Purge()
+	cfg.Hosts.Add("example.com", "a.b.example.com")
+
+	cases := []struct {
+		name     string
+		expected []string
+	}{
+		{"example.com", []string{"example.com"}},
+		{"b.example.com", []string{"example.com"}},
+		{"x.a.b.example.com", []string{"example.com", "a.b.example.com"}},
+		{"example.net", nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tt.Eq(t, "hosts", tc.expected, BlockingHosts(tc.name))
+		})
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	defer cfg.Hosts.Purge()
+	defer cfg.Filters.Purge()
+	defer Cache.Purge()
+
+	Cache.Purge()
+	Configure("127.0.0.1:1", 3600, "127.0.0.53", 0)
+	cfg.Hosts.Add("tracker.example.com")
+	client, other := net.ParseIP("127.0.0.1"), net.ParseIP("10.0.0.1")
+
+	// All clients share the same entry if no filter depends on the client.
+	tt.Eq(t, "spoof", "spoof", Query("tracker.example.com", dns.TypeA, client).Response)
+	tt.Eq(t, "spoof", "spoof", Query("tracker.example.com", dns.TypeA, other).Response)
+	tt.Eq(t, "cached", 1, Cache.Len())
+	_, ok := Cache.Get("A tracker.example.com")
+	tt.Eq(t, "key", true, ok)
+
+	Cache.Purge()
+	_, n, _ := net.ParseCIDR("10.0.0.0/24")
+	cfg.Filters.Add(&cfg.Filter{
+		Text:      "@@||tracker.example.com^$client=10.0.0.0/24",
+		Name:      "tracker.example.com",
+		Exception: true,
+		Clients:   []*net.IPNet{n},
+	})
+	tt.Eq(t, "client", "spoof", Query("tracker.example.com", dns.TypeA, client).Response)
+	tt.Eq(t, "other client", "forward", Query("tracker.example.com", dns.TypeA, other).Response)
+	tt.Eq(t, "cached", 2, Cache.Len())
+	_, ok = Cache.Get("A tracker.example.com 10.0.0.1")
+	tt.Eq(t, "key", true, ok)
+
+	items := Cache.Items("tracker.example.com")
+	tt.Eq(t, "items", 2, len(items))
+	tt.Eq(t, "item client", "10.0.0.1", items[0].Client)
+
+	cfg.Filters.Purge()
+	tt.Eq(t, "purged", false, cfg.Filters.ForClient())
+}
+
+func TestRPZPassthru(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackwall-rpz")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	defer cfg.Hosts.Purge()
+	defer cfg.RPZ.Purge()
+
+	path := filepath.Join(dir, "zone")
+	tt.Err(t, ioutil.WriteFile(path, []byte(`$TTL 60
+@                  SOA   ns.example.com. admin.example.com. 1 3600 600 86400 60
+                   NS    ns.example.com.
+pass.example.com   CNAME rpz-passthru.
+ok.example.com     CNAME rpz-passthru.
+`), 0644))
+	cfg.RPZ.Purge()
+	cfg.RPZ.Load([]string{"rpz.test", "file://" + path})
+	cfg.Hosts.Add("pass.example.com")
+
+	// PASSTHRU doesn't override the hosts.
+	client := net.ParseIP("127.0.0.1")
+	tt.Eq(t, "hosts", "spoof", responseNames[determineResponse("pass.example.com", dns.TypeA, client)])
+	tt.Eq(t, "no hosts", "forward", responseNames[determineResponse("ok.example.com", dns.TypeA, client)])
+}
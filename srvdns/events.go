@@ -0,0 +1,147 @@
+package srvdns
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Event is a DNS decision or a request to the HTTP stub, for subscribers of
+// the event stream. For requests to the HTTP stub the Type is the HTTP method.
+type Event struct {
+	LoggedQuery
+	Kind string `json:"kind"`          // "dns" or "http".
+	URL  string `json:"url,omitempty"` // Only for "http".
+}
+
+// EventFilter selects events to send to a subscriber. The zero value selects
+// everything.
+type EventFilter struct {
+	Client *net.IPNet
+	Name   *regexp.Regexp
+
+	// Response names ("spoof", "forward", etc.), "blocked", "allowed", "dns",
+	// or "http".
+	Types map[string]bool
+}
+
+// EventList is the list of all subscribers.
+type EventList struct {
+	sync.Mutex
+	subs map[chan Event]EventFilter
+}
+
+// Size of the channel buffer for each subscriber; events are dropped if a
+// subscriber can't keep up.
+const eventBuffer = 256
+
+// Events sends the DNS decisions and HTTP requests to subscribers.
+var Events EventList
+
+func init() {
+	Events.subs = make(map[chan Event]EventFilter)
+}
+
+// ParseEventFilter parses the filters for the client (an IP address or CIDR
+// range), the name (a regexp), and the types (comma-separated). Empty strings
+// select everything.
+func ParseEventFilter(client, name, types string) (EventFilter, error) {
+	var f EventFilter
+	if client != "" {
+		if !strings.Contains(client, "/") {
+			if strings.Contains(client, ":") {
+				client += "/128"
+			} else {
+				client += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(client)
+		if err != nil {
+			return f, fmt.Errorf("invalid client: %v", err)
+		}
+		f.Client = n
+	}
+
+	if name != "" {
+		re, err := regexp.Compile(name)
+		if err != nil {
+			return f, fmt.Errorf("invalid name: %v", err)
+		}
+		f.Name = re
+	}
+
+	if types != "" {
+		f.Types = make(map[string]bool)
+		for _, t := range strings.Split(types, ",") {
+			if !validEventType(t) {
+				return f, fmt.Errorf("invalid type: %#v", t)
+			}
+			f.Types[t] = true
+		}
+	}
+	return f, nil
+}
+
+func validEventType(t string) bool {
+	switch t {
+	case "blocked", "allowed", "dns", "http", "surrogate", "stub", "allow":
+		return true
+	}
+	for _, r := range responseNames {
+		if r == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports if the filter selects the event.
+func (f EventFilter) Match(e Event) bool {
+	if f.Client != nil && !f.Client.Contains(net.ParseIP(e.Client)) {
+		return false
+	}
+	if f.Name != nil && !f.Name.MatchString(e.Name) {
+		return false
+	}
+	if f.Types != nil {
+		return f.Types[e.Response] || f.Types[e.Kind] ||
+			(e.Blocked && f.Types["blocked"]) || (!e.Blocked && f.Types["allowed"])
+	}
+	return true
+}
+
+// Subscribe to all events that match the filter. The channel is closed after
+// calling the cancel function.
+func (l *EventList) Subscribe(f EventFilter) (events <-chan Event, cancel func()) {
+	ch := make(chan Event, eventBuffer)
+	l.Lock()
+	l.subs[ch] = f
+	l.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			l.Lock()
+			delete(l.subs, ch)
+			close(ch)
+			l.Unlock()
+		})
+	}
+}
+
+// Publish an event to all subscribers.
+func (l *EventList) Publish(e Event) {
+	l.Lock()
+	defer l.Unlock()
+	for ch, f := range l.subs {
+		if !f.Match(e) {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
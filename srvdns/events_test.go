@@ -0,0 +1,76 @@
+package srvdns
+
+import (
+	"fmt"
+	"testing"
+
+	"arp242.net/trackwall/tt"
+)
+
+func TestEventFilter(t *testing.T) {
+	spoof := Event{LoggedQuery: LoggedQuery{Client: "10.0.0.1", Name: "ads.example.com", Response: "spoof", Blocked: true}, Kind: "dns"}
+	fwd := Event{LoggedQuery: LoggedQuery{Client: "10.0.1.1", Name: "example.com", Response: "forward"}, Kind: "dns"}
+	stub := Event{LoggedQuery: LoggedQuery{Client: "::1", Name: "ads.example.com", Response: "stub", Blocked: true}, Kind: "http"}
+
+	cases := []struct {
+		client, name, types string
+		expected            []bool
+		expectedErr         string
+	}{
+		{"", "", "", []bool{true, true, true}, ""},
+		{"10.0.0.1", "", "", []bool{true, false, false}, ""},
+		{"10.0.0.0/16", "", "", []bool{true, true, false}, ""},
+		{"::1", "", "", []bool{false, false, true}, ""},
+		{"", `^ads\.`, "", []bool{true, false, true}, ""},
+		{"", "", "blocked", []bool{true, false, true}, ""},
+		{"", "", "allowed", []bool{false, true, false}, ""},
+		{"", "", "forward,http", []bool{false, true, true}, ""},
+		{"", "", "dns", []bool{true, true, false}, ""},
+		{"10.0.0.0/8", "example", "blocked", []bool{true, false, false}, ""},
+
+		{"x", "", "", nil, "invalid client: invalid CIDR address: x/32"},
+		{"", "(", "", nil, "invalid name: error parsing regexp: missing closing ): `(`"},
+		{"", "", "spoof,nope", nil, `invalid type: "nope"`},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%v %v %v", tc.client, tc.name, tc.types), func(t *testing.T) {
+			f, err := ParseEventFilter(tc.client, tc.name, tc.types)
+			if tc.expectedErr != "" {
+				tt.Eq(t, "err", tc.expectedErr, fmt.Sprintf("%v", err))
+				return
+			}
+			tt.Err(t, err)
+
+			out := []bool{f.Match(spoof), f.Match(fwd), f.Match(stub)}
+			tt.Eq(t, "match", tc.expected, out)
+		})
+	}
+}
+
+func TestEvents(t *testing.T) {
+	l := &EventList{subs: make(map[chan Event]EventFilter)}
+
+	f, err := ParseEventFilter("", "", "blocked")
+	tt.Err(t, err)
+	events, cancel := l.Subscribe(f)
+
+	l.Publish(Event{LoggedQuery: LoggedQuery{Name: "example.com"}})
+	l.Publish(Event{LoggedQuery: LoggedQuery{Name: "ads.example.com", Blocked: true}})
+	tt.Eq(t, "event", "ads.example.com", (<-events).Name)
+
+	// Don't block on slow subscribers.
+	for i := 0; i < eventBuffer+10; i++ {
+		l.Publish(Event{LoggedQuery: LoggedQuery{Blocked: true}})
+	}
+	tt.Eq(t, "buffered", eventBuffer, len(events))
+
+	cancel()
+	cancel()
+	n := 0
+	for range events {
+		n++
+	}
+	tt.Eq(t, "drained", eventBuffer, n)
+	tt.Eq(t, "subscribers", 0, len(l.subs))
+}
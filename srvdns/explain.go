@@ -0,0 +1,157 @@
+package srvdns
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+
+	"github.com/miekg/dns"
+)
+
+// Names for the response* constants.
+var responseNames = map[uint8]string{
+	reponseForward:  "forward",
+	reponseSpoof:    "spoof",
+	reponseEmpty:    "empty",
+	reponseRewrite:  "rewrite",
+	reponseNXDomain: "nxdomain",
+	reponseDrop:     "drop",
+	reponseTCPOnly:  "tcp-only",
+	reponsePolicy:   "policy",
+}
+
+// Explain writes why name is (or isn't) blocked for client: every rule that
+// matches, where it was loaded from, the cached response, and the response
+// that would be sent for A and AAAA queries.
+func Explain(w io.Writer, name string, client net.IP) {
+	name = strings.ToLower(strings.TrimRight(name, "."))
+	now := time.Now()
+
+	fmt.Fprintf(w, "name:       %v\n", name)
+	fmt.Fprintf(w, "client:     %v\n", client)
+	if Pauses.Paused(client) {
+		fmt.Fprintf(w, "paused:     all queries are forwarded\n")
+	}
+
+	// Overrides
+	if k, e, ok := cfg.Override.Match(name, client); ok {
+		exp := time.Unix(e.Expires, 0)
+		if exp.Before(now) {
+			fmt.Fprintf(w, "override:   %v, %v, client %v (expired %v ago)\n", k.Host, e.Scope(), k.ClientName(), now.Sub(exp).Round(time.Second))
+		} else {
+			fmt.Fprintf(w, "override:   %v, %v, client %v (expires in %v)\n", k.Host, e.Scope(), k.ClientName(), exp.Sub(now).Round(time.Second))
+		}
+	} else {
+		fmt.Fprintf(w, "override:   -\n")
+	}
+
+	// Hosts
+	if host, ok := matchHost(name); ok {
+		fmt.Fprintf(w, "host:       %v%v\n", host, origin(cfg.OriginHost, host))
+	} else {
+		fmt.Fprintf(w, "host:       -\n")
+		if host, ok := matchOrigin(cfg.OriginUnhost, name); ok {
+			fmt.Fprintf(w, "unhost:     %v%v\n", host, origin(cfg.OriginUnhost, host))
+		}
+	}
+
+	// Regexps
+	if re, ok := cfg.Regexps.Find(name); ok {
+		fmt.Fprintf(w, "regexp:     %v%v\n", re, origin(cfg.OriginRegexp, re))
+	} else {
+		fmt.Fprintf(w, "regexp:     -\n")
+	}
+
+	// Response policy zones
+	if p := cfg.RPZ.Match(name); p != nil {
+		fmt.Fprintf(w, "rpz:        %v in %v\n", p.Trigger, p.Zone)
+	} else {
+		fmt.Fprintf(w, "rpz:        -\n")
+	}
+
+	// Surrogates
+	if script, ok := cfg.Surrogates.Find(name); ok {
+		if len(script) > 60 {
+			script = script[:60] + "…"
+		}
+		fmt.Fprintf(w, "surrogate:  %v\n", script)
+	} else {
+		fmt.Fprintf(w, "surrogate:  -\n")
+	}
+
+	// Filters, the cache, and the final response depend on the query type.
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		t := dns.TypeToString[qtype]
+		fmt.Fprintf(w, "\n%v\n", t)
+
+		if f := cfg.Filters.Match(name, qtype, client); f != nil {
+			fmt.Fprintf(w, "  filter:   %v%v\n", f.Text, originString(f.Origin))
+		} else {
+			fmt.Fprintf(w, "  filter:   -\n")
+		}
+
+		if c, ok := Cache.Get(cacheKey(name, qtype, client)); ok {
+			exp := time.Unix(c.expires, 0)
+			if exp.Before(now) {
+				fmt.Fprintf(w, "  cache:    %v (expired)\n", responseNames[c.response])
+			} else {
+				fmt.Fprintf(w, "  cache:    %v (expires in %v)\n", responseNames[c.response], exp.Sub(now).Round(time.Second))
+			}
+		} else {
+			fmt.Fprintf(w, "  cache:    -\n")
+		}
+
+		fmt.Fprintf(w, "  response: %v\n", responseNames[determineResponse(name, qtype, client)])
+	}
+}
+
+// BlockedBy finds the rule that blocks name for client, and where it was loaded
+// from. The rule is "" if name isn't blocked by a filter, response policy zone,
+// host, or regexp.
+func BlockedBy(name string, client net.IP) (string, cfg.Origin) {
+	if f := cfg.Filters.Match(name, dns.TypeA, client); f != nil && !f.Exception {
+		return f.Text, f.Origin
+	}
+	if p := cfg.RPZ.Match(name); p != nil && p.Action() != cfg.PolicyPassthru {
+		return p.Trigger, cfg.Origin{Source: p.Zone}
+	}
+	if host, ok := matchHost(name); ok {
+		o, _ := cfg.Origins.Get(cfg.OriginHost, host)
+		return host, o
+	}
+	if re, ok := cfg.Regexps.Find(name); ok {
+		o, _ := cfg.Origins.Get(cfg.OriginRegexp, re)
+		return re, o
+	}
+	return "", cfg.Origin{}
+}
+
+// Find the rule of kind for name or one of its parent domains.
+func matchOrigin(kind, name string) (string, bool) {
+	for {
+		if _, ok := cfg.Origins.Get(kind, name); ok {
+			return name, true
+		}
+		i := strings.IndexByte(name, '.')
+		if i == -1 {
+			return "", false
+		}
+		name = name[i+1:]
+	}
+}
+
+func origin(kind, rule string) string {
+	o, _ := cfg.Origins.Get(kind, rule)
+	return originString(o)
+}
+
+func originString(o cfg.Origin) string {
+	if o.Source == "" {
+		return ""
+	}
+	return " (from " + o.String() + ")"
+}
@@ -0,0 +1,86 @@
+package srvdns
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+)
+
+func TestExplain(t *testing.T) {
+	defer cfg.Hosts.Purge()
+	defer cfg.Regexps.Purge()
+	defer cfg.Origins.Purge()
+	defer cfg.Override.Purge()
+
+	cfg.Hosts.Add("example.com")
+	cfg.Origins.Set(cfg.OriginHost, cfg.Origin{Source: "file:///hosts", Line: 4}, "example.com")
+	cfg.Regexps.Add(`^ads\.`)
+	cfg.Origins.Set(cfg.OriginUnhost, cfg.Origin{Source: "config"}, "example.net")
+	cfg.Override.Store(cfg.OverrideKey{Host: "ok.example.org"}, cfg.OverrideEntry{Expires: time.Now().Add(time.Hour).Unix(), Subtree: true})
+
+	cases := []struct {
+		name     string
+		expected []string
+	}{
+		{"ads.example.com.", []string{
+			"name:       ads.example.com\n",
+			"host:       example.com (from file:///hosts line 4)\n",
+			"regexp:     ^ads\\.\n",
+			"  response: spoof\n",
+			"  response: empty\n",
+		}},
+		{"www.example.net", []string{
+			"host:       -\n",
+			"unhost:     example.net (from config)\n",
+			"  response: forward\n",
+		}},
+		{"ok.example.org", []string{
+			"override:   ok.example.org, subtree, client all (expires in",
+			"  response: forward\n",
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			Explain(&buf, tc.name, net.ParseIP("127.0.0.1"))
+			out := buf.String()
+			for _, e := range tc.expected {
+				if !strings.Contains(out, e) {
+					t.Errorf("%q not in output:\n%v", e, out)
+				}
+			}
+		})
+	}
+}
+
+func TestBlockedBy(t *testing.T) {
+	defer cfg.Hosts.Purge()
+	defer cfg.Regexps.Purge()
+	defer cfg.Origins.Purge()
+
+	cfg.Hosts.Add("example.com")
+	cfg.Origins.Set(cfg.OriginHost, cfg.Origin{Source: "file:///hosts", Line: 4}, "example.com")
+	cfg.Regexps.Add(`^ads\.`)
+
+	cases := []struct {
+		name, rule string
+		origin     cfg.Origin
+	}{
+		{"www.example.com", "example.com", cfg.Origin{Source: "file:///hosts", Line: 4}},
+		{"ads.example.net", `^ads\.`, cfg.Origin{}},
+		{"example.net", "", cfg.Origin{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, origin := BlockedBy(tc.name, nil)
+			if rule != tc.rule || origin != tc.origin {
+				t.Errorf("\nout:      %q %v\nexpected: %q %v", rule, origin, tc.rule, tc.origin)
+			}
+		})
+	}
+}
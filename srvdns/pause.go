@@ -0,0 +1,89 @@
+package srvdns
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+)
+
+// PauseList are the clients for which blocking is paused, with the Unix time
+// until which it's paused. The client is an IP address, a client group, or ""
+// for all clients.
+type PauseList struct {
+	sync.RWMutex
+	m map[string]int64
+}
+
+// Pauses of the blocking.
+var Pauses PauseList
+
+func init() {
+	Pauses.Purge()
+}
+
+// Pause blocking for client until the given time.
+func (l *PauseList) Pause(client string, until time.Time) {
+	l.Lock()
+	l.m[client] = until.Unix()
+	l.Unlock()
+}
+
+// Resume blocking for client; it returns false if it wasn't paused.
+func (l *PauseList) Resume(client string) bool {
+	l.Lock()
+	defer l.Unlock()
+	exp, ok := l.m[client]
+	delete(l.m, client)
+	return ok && exp >= time.Now().Unix()
+}
+
+// Paused reports if blocking is paused for the client with this address.
+func (l *PauseList) Paused(ip net.IP) bool {
+	l.RLock()
+	defer l.RUnlock()
+	if len(l.m) == 0 {
+		return false
+	}
+
+	now := time.Now().Unix()
+	if l.m[""] >= now {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	if l.m[ip.String()] >= now {
+		return true
+	}
+	for _, g := range cfg.Config.ClientGroupsOf(ip) {
+		if l.m[g] >= now {
+			return true
+		}
+	}
+	return false
+}
+
+// List all pauses that didn't expire yet.
+func (l *PauseList) List() map[string]int64 {
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now().Unix()
+	m := make(map[string]int64, len(l.m))
+	for k, v := range l.m {
+		if v < now {
+			delete(l.m, k)
+			continue
+		}
+		m[k] = v
+	}
+	return m
+}
+
+// Purge all pauses.
+func (l *PauseList) Purge() {
+	l.Lock()
+	l.m = make(map[string]int64)
+	l.Unlock()
+}
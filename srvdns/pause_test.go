@@ -0,0 +1,44 @@
+package srvdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/tt"
+
+	"github.com/miekg/dns"
+)
+
+func TestPause(t *testing.T) {
+	defer cfg.Hosts.Purge()
+	defer Pauses.Purge()
+	defer Cache.Purge()
+	defer func() { cfg.Config.ClientGroups = nil }()
+
+	Cache.Purge()
+	Configure("127.0.0.1:1", 3600, "127.0.0.53", 0)
+	cfg.Hosts.Add("tracker.example.com")
+	_, n, _ := net.ParseCIDR("10.0.0.0/24")
+	cfg.Config.ClientGroups = map[string][]*net.IPNet{"kids": {n}}
+
+	a := net.ParseIP("10.0.0.1")
+	b := net.ParseIP("10.0.1.1")
+	tt.Eq(t, "blocked", "spoof", Query("tracker.example.com", dns.TypeA, a).Response)
+
+	Pauses.Pause("", time.Now().Add(time.Hour))
+	tt.Eq(t, "paused", "forward", Query("tracker.example.com", dns.TypeA, a).Response)
+	tt.Eq(t, "paused", "forward", Query("tracker.example.com", dns.TypeA, b).Response)
+	tt.Eq(t, "resume", true, Pauses.Resume(""))
+	tt.Eq(t, "resumed", "spoof", Query("tracker.example.com", dns.TypeA, a).Response)
+	tt.Eq(t, "resume again", false, Pauses.Resume(""))
+
+	Pauses.Pause("kids", time.Now().Add(time.Hour))
+	tt.Eq(t, "group", "forward", Query("tracker.example.com", dns.TypeA, a).Response)
+	tt.Eq(t, "other", "spoof", Query("tracker.example.com", dns.TypeA, b).Response)
+
+	Pauses.Pause("10.0.1.1", time.Now().Add(-time.Second))
+	tt.Eq(t, "expired", "spoof", Query("tracker.example.com", dns.TypeA, b).Response)
+	tt.Eq(t, "list", 1, len(Pauses.List()))
+}
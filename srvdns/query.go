@@ -0,0 +1,165 @@
+package srvdns
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// QueryResult is the reply to a query sent with Query(), and how it was
+// handled.
+type QueryResult struct {
+	Reply     *dns.Msg // nil if the query was dropped.
+	Response  string   // forward, spoof, empty, etc.
+	FromCache bool
+	Duration  time.Duration
+}
+
+// Query resolves name in this process as if it was sent by client, exactly
+// like a query sent to the DNS server.
+func Query(name string, qtype uint16, client net.IP) QueryResult {
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(strings.ToLower(name)), qtype)
+
+	w := &queryWriter{client: client}
+	start := time.Now()
+	response, fromCache := resolve(w, req, client)
+
+	return QueryResult{
+		Reply:     w.reply,
+		Response:  responseNames[response],
+		FromCache: fromCache,
+		Duration:  time.Since(start),
+	}
+}
+
+// QueryServer sends a query for name to the DNS server started with Serve(), as
+// if it was sent by client.
+//
+// The client is sent with the EDNS client subnet option, and how the query was
+// handled is taken from the event stream as it's not in the reply.
+func QueryServer(name string, qtype uint16, client net.IP) (QueryResult, error) {
+	if client == nil {
+		client = net.IPv4(127, 0, 0, 1)
+	}
+	name = strings.ToLower(strings.TrimRight(name, "."))
+
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(name), qtype)
+	subnet := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 32, Address: client.To4()}
+	if subnet.Address == nil {
+		subnet.Family, subnet.SourceNetmask, subnet.Address = 2, 128, client
+	}
+	req.SetEdns0(dns.DefaultMsgSize, false)
+	opt := req.IsEdns0()
+	opt.Option = append(opt.Option, subnet)
+
+	events, cancel := Events.Subscribe(EventFilter{Types: map[string]bool{"dns": true}})
+	defer cancel()
+
+	start := time.Now()
+	reply, _, err := new(dns.Client).Exchange(req, queryAddr(dnsListen))
+	r := QueryResult{Reply: reply, Duration: time.Since(start)}
+
+	// The event is sent after the reply, and there is no event for the zone
+	// served with rpz-serve.
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Name == name && e.Type == dns.TypeToString[qtype] && e.Client == client.String() {
+				r.Response, r.FromCache = e.Response, e.Cached
+				// No reply is expected if the query was dropped.
+				if e.Response == responseNames[reponseDrop] {
+					err = nil
+				}
+				return r, err
+			}
+		case <-timeout:
+			return r, err
+		}
+	}
+}
+
+// Get the address to send queries to for the DNS server listening on addr.
+func queryAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	ip := net.ParseIP(host)
+	switch {
+	case host == "":
+		host = "127.0.0.1"
+	case ip != nil && ip.IsUnspecified() && ip.To4() != nil:
+		host = "127.0.0.1"
+	case ip != nil && ip.IsUnspecified():
+		host = "::1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// ParseType parses a query type such as "AAAA", "mx", or "TYPE65".
+func ParseType(s string) (uint16, error) {
+	s = strings.ToUpper(s)
+	if t, ok := dns.StringToType[s]; ok {
+		return t, nil
+	}
+	if strings.HasPrefix(s, "TYPE") {
+		if t, err := strconv.ParseUint(s[4:], 10, 16); err == nil {
+			return uint16(t), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown query type: %#v", s)
+}
+
+// String formats the result like dig.
+func (r QueryResult) String() string {
+	var b strings.Builder
+	if r.Reply == nil {
+		b.WriteString(";; no reply\n")
+	} else {
+		b.WriteString(r.Reply.String())
+	}
+
+	response := r.Response
+	if response == "" {
+		response = "-"
+	}
+	if r.FromCache {
+		response += " (from cache)"
+	}
+	fmt.Fprintf(&b, "\n;; trackwall: %v\n", response)
+	fmt.Fprintf(&b, ";; Query time: %v\n", r.Duration.Round(time.Microsecond))
+	return b.String()
+}
+
+// queryWriter is a dns.ResponseWriter that stores the reply.
+type queryWriter struct {
+	client net.IP
+	reply  *dns.Msg
+}
+
+func (w *queryWriter) LocalAddr() net.Addr  { return &net.UDPAddr{} }
+func (w *queryWriter) RemoteAddr() net.Addr { return &net.UDPAddr{IP: w.client} }
+func (w *queryWriter) WriteMsg(m *dns.Msg) error {
+	w.reply = m
+	return nil
+}
+func (w *queryWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	w.reply = m
+	return len(b), nil
+}
+func (w *queryWriter) Close() error        { return nil }
+func (w *queryWriter) TsigStatus() error   { return nil }
+func (w *queryWriter) TsigTimersOnly(bool) {}
+func (w *queryWriter) Hijack()             {}
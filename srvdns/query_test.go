@@ -0,0 +1,134 @@
+package srvdns
+
+import (
+	"net"
+	"testing"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/tt"
+
+	"github.com/miekg/dns"
+)
+
+func TestQuery(t *testing.T) {
+	defer cfg.Hosts.Purge()
+	defer Cache.Purge()
+
+	Configure("127.0.0.1:1", 3600, "127.0.0.53", 0)
+	cfg.Hosts.Add("example.com")
+	client := net.ParseIP("127.0.0.1")
+
+	r := Query("ads.example.com", dns.TypeA, client)
+	tt.Eq(t, "response", "spoof", r.Response)
+	tt.Eq(t, "from cache", false, r.FromCache)
+	if len(r.Reply.Answer) != 1 || r.Reply.Answer[0].(*dns.A).A.String() != "127.0.0.53" {
+		t.Errorf("wrong answer: %v", r.Reply.Answer)
+	}
+
+	r = Query("ads.example.com", dns.TypeA, client)
+	tt.Eq(t, "response", "spoof", r.Response)
+	tt.Eq(t, "from cache", true, r.FromCache)
+
+	r = Query("ads.example.com", dns.TypeAAAA, client)
+	tt.Eq(t, "response", "empty", r.Response)
+	tt.Eq(t, "answer", 0, len(r.Reply.Answer))
+}
+
+func TestQueryServer(t *testing.T) {
+	defer cfg.Hosts.Purge()
+	defer cfg.Filters.Purge()
+	defer Cache.Purge()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	tt.Err(t, err)
+	started := make(chan struct{})
+	srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(handleDNS),
+		NotifyStartedFunc: func() { close(started) }}
+	go srv.ActivateAndServe() // nolint: errcheck
+	defer srv.Shutdown()      // nolint: errcheck
+	<-started
+
+	Configure("127.0.0.1:1", 3600, "127.0.0.53", 0)
+	dnsListen = pc.LocalAddr().String()
+	defer func() { dnsListen = "" }()
+	cfg.Hosts.Add("example.com")
+	_, n, _ := net.ParseCIDR("10.0.0.0/24")
+	cfg.Filters.Add(&cfg.Filter{Text: "||client.example.net^$client=10.0.0.0/24",
+		Name: "client.example.net", Clients: []*net.IPNet{n}})
+
+	r, err := QueryServer("ads.example.com", dns.TypeA, nil)
+	tt.Err(t, err)
+	tt.Eq(t, "response", "spoof", r.Response)
+	tt.Eq(t, "from cache", false, r.FromCache)
+	if len(r.Reply.Answer) != 1 || r.Reply.Answer[0].(*dns.A).A.String() != "127.0.0.53" {
+		t.Errorf("wrong answer: %v", r.Reply.Answer)
+	}
+
+	r, err = QueryServer("ads.example.com", dns.TypeA, nil)
+	tt.Err(t, err)
+	tt.Eq(t, "from cache", true, r.FromCache)
+
+	// The client is sent with the EDNS client subnet option.
+	r, err = QueryServer("client.example.net", dns.TypeA, net.ParseIP("10.0.0.1"))
+	tt.Err(t, err)
+	tt.Eq(t, "client", "spoof", r.Response)
+	r, _ = QueryServer("client.example.net", dns.TypeA, net.ParseIP("10.0.1.1"))
+	tt.Eq(t, "other client", "forward", r.Response)
+}
+
+func TestRequestClient(t *testing.T) {
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	req.SetEdns0(dns.DefaultMsgSize, false)
+	opt := req.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET,
+		Family: 1, SourceNetmask: 32, Address: net.ParseIP("10.0.0.1").To4()})
+
+	// Not from this host.
+	tt.Eq(t, "remote", "192.0.2.1", requestClient(&queryWriter{client: net.ParseIP("192.0.2.1")}, req).String())
+	tt.Eq(t, "options", 1, len(opt.Option))
+
+	tt.Eq(t, "local", "10.0.0.1", requestClient(&queryWriter{client: net.ParseIP("127.0.0.1")}, req).String())
+	tt.Eq(t, "removed", 0, len(opt.Option))
+}
+
+func TestQueryAddr(t *testing.T) {
+	cases := []struct {
+		in, expected string
+	}{
+		{"127.0.0.53:53", "127.0.0.53:53"},
+		{"0.0.0.0:53", "127.0.0.1:53"},
+		{":53", "127.0.0.1:53"},
+		{"[::]:53", "[::1]:53"},
+		{"[::1]:5353", "[::1]:5353"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			tt.Eq(t, "addr", tc.expected, queryAddr(tc.in))
+		})
+	}
+}
+
+func TestParseType(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected uint16
+		err      bool
+	}{
+		{"A", dns.TypeA, false},
+		{"aaaa", dns.TypeAAAA, false},
+		{"TYPE65", 65, false},
+		{"type65", 65, false},
+		{"TYPE70000", 0, true},
+		{"XXX", 0, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			out, err := ParseType(tc.in)
+			tt.Eq(t, "type", tc.expected, out)
+			tt.Eq(t, "err", tc.err, err != nil)
+		})
+	}
+}
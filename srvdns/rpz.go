@@ -0,0 +1,326 @@
+package srvdns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/msg"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// Number of changes to keep for IXFR; clients with an older serial get the
+	// full zone.
+	zoneHistory = 10
+
+	// Maximum number of records per message in a transfer.
+	zoneChunk = 500
+)
+
+// A zone with the blocklist as response policy zone triggers.
+type zone struct {
+	sync.Mutex
+	origin   string
+	notify   []string
+	allow    []*net.IPNet
+	serial   uint32
+	gen      uint64
+	list     []cfg.Trigger
+	triggers map[cfg.Trigger]struct{}
+	history  []zoneDiff
+}
+
+// The triggers that were deleted and added between two serials.
+type zoneDiff struct {
+	from, to uint32
+	del, add []cfg.Trigger
+}
+
+// The zone we serve, if any.
+var served *zone
+
+// ServeRPZ serves the blocklist as a response policy zone with the given origin
+// over AXFR/IXFR.
+//
+// The zone is checked for changes every minute; the serial is incremented on
+// every change and a NOTIFY is sent to the notify addresses (which are also
+// allowed to transfer the zone).
+func ServeRPZ(origin string, notify []string, allow []*net.IPNet) {
+	z := &zone{
+		origin: dns.Fqdn(origin),
+		notify: notify,
+		allow:  allow,
+		serial: uint32(time.Now().Unix()),
+	}
+	for _, addr := range notify {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			z.allow = append(z.allow, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+		}
+	}
+	served = z
+
+	go func() {
+		for {
+			time.Sleep(1 * time.Minute)
+			z.update()
+		}
+	}()
+}
+
+// Handle queries for the zone; returns false if req isn't for the zone.
+func (z *zone) handle(w dns.ResponseWriter, req *dns.Msg) bool {
+	q := req.Question[0]
+	if !strings.EqualFold(dns.Fqdn(q.Name), z.origin) {
+		return false
+	}
+
+	client := clientIP(w.RemoteAddr())
+	_, tcp := w.RemoteAddr().(*net.TCPAddr)
+	z.update()
+
+	switch q.Qtype {
+	case dns.TypeSOA:
+		z.reply(w, req, dns.RcodeSuccess, z.soa())
+	case dns.TypeAXFR, dns.TypeIXFR:
+		if !z.allowed(client) {
+			msg.Warn(fmt.Errorf("refused %v for %v from %v", dns.TypeToString[q.Qtype], z.origin, client))
+			z.reply(w, req, dns.RcodeRefused)
+			return true
+		}
+
+		var rrs []dns.RR
+		if q.Qtype == dns.TypeIXFR {
+			var from uint32
+			if len(req.Ns) > 0 {
+				if soa, ok := req.Ns[0].(*dns.SOA); ok {
+					from = soa.Serial
+				}
+			}
+			rrs = z.ixfr(from)
+		} else {
+			rrs = z.axfr()
+		}
+
+		// Only IXFR can be done over UDP; reply with just the SOA if it
+		// doesn't fit, so the client retries over TCP.
+		if !tcp {
+			if q.Qtype == dns.TypeAXFR {
+				z.reply(w, req, dns.RcodeRefused)
+				return true
+			}
+			if len(rrs) > 1 {
+				rrs = rrs[:1]
+			}
+		}
+
+		msg.Info(fmt.Sprintf("%v %v to %v (%d records)",
+			dns.TypeToString[q.Qtype], z.origin, client, len(rrs)), verbose)
+		z.transfer(w, req, rrs)
+	default:
+		z.reply(w, req, dns.RcodeSuccess)
+	}
+	return true
+}
+
+// Check if the client can transfer the zone.
+func (z *zone) allowed(client net.IP) bool {
+	if client == nil {
+		return false
+	}
+	if client.IsLoopback() {
+		return true
+	}
+	for _, n := range z.allow {
+		if n.Contains(client) {
+			return true
+		}
+	}
+	return false
+}
+
+// Update the triggers if the lists changed since the last update, and increment
+// the serial and send a NOTIFY if the triggers are different.
+func (z *zone) update() {
+	gen := cfg.Hosts.Generation() + cfg.Regexps.Generation()
+
+	z.Lock()
+	defer z.Unlock()
+	if z.triggers != nil && gen == z.gen {
+		return
+	}
+	z.gen = gen
+
+	list, skipped := cfg.Triggers()
+	triggers := make(map[cfg.Trigger]struct{}, len(list))
+	for _, t := range list {
+		triggers[t] = struct{}{}
+	}
+
+	d := zoneDiff{from: z.serial, to: z.serial + 1}
+	for _, t := range z.list {
+		if _, has := triggers[t]; !has {
+			d.del = append(d.del, t)
+		}
+	}
+	for _, t := range list {
+		if _, has := z.triggers[t]; !has {
+			d.add = append(d.add, t)
+		}
+	}
+	if z.triggers != nil && len(d.del) == 0 && len(d.add) == 0 {
+		return
+	}
+
+	z.list = list
+	z.triggers = triggers
+	z.serial = d.to
+	z.history = append(z.history, d)
+	if len(z.history) > zoneHistory {
+		z.history = z.history[len(z.history)-zoneHistory:]
+	}
+
+	msg.Info(fmt.Sprintf("serving %v with serial %d: %d triggers (%d regexps can't be expressed)",
+		z.origin, z.serial, len(triggers), skipped), verbose)
+	go z.sendNotify(z.serial)
+}
+
+// Send a NOTIFY to the secondaries.
+func (z *zone) sendNotify(serial uint32) {
+	for _, addr := range z.notify {
+		m := new(dns.Msg)
+		m.SetNotify(z.origin)
+		m.Answer = []dns.RR{z.soaSerial(serial)}
+
+		_, _, err := (&dns.Client{}).Exchange(m, addr)
+		if err != nil {
+			msg.Warn(fmt.Errorf("unable to send NOTIFY for %v to %v: %v", z.origin, addr, err))
+		}
+	}
+}
+
+// Get all records for an AXFR.
+func (z *zone) axfr() []dns.RR {
+	z.Lock()
+	defer z.Unlock()
+
+	soa := z.soaSerial(z.serial)
+	rrs := make([]dns.RR, 0, len(z.list)+3)
+	rrs = append(rrs, soa, &dns.NS{
+		Hdr: dns.RR_Header{Name: z.origin, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60},
+		Ns:  "localhost.",
+	})
+	for _, t := range z.list {
+		rrs = append(rrs, z.rr(t))
+	}
+	return append(rrs, soa)
+}
+
+// Get the records for an IXFR from the serial from; this is the full zone if
+// we don't have the history.
+func (z *zone) ixfr(from uint32) []dns.RR {
+	z.Lock()
+	start := -1
+	for i, d := range z.history {
+		if d.from == from {
+			start = i
+			break
+		}
+	}
+	if from != z.serial && start == -1 {
+		z.Unlock()
+		return z.axfr()
+	}
+	defer z.Unlock()
+
+	soa := z.soaSerial(z.serial)
+	rrs := []dns.RR{soa}
+	if from == z.serial {
+		return rrs
+	}
+	for _, d := range z.history[start:] {
+		rrs = append(rrs, z.soaSerial(d.from))
+		for _, t := range d.del {
+			rrs = append(rrs, z.rr(t))
+		}
+		rrs = append(rrs, z.soaSerial(d.to))
+		for _, t := range d.add {
+			rrs = append(rrs, z.rr(t))
+		}
+	}
+	return append(rrs, soa)
+}
+
+// Send the records in one or more messages.
+func (z *zone) transfer(w dns.ResponseWriter, req *dns.Msg, rrs []dns.RR) {
+	ch := make(chan *dns.Envelope)
+	go func() {
+		for len(rrs) > 0 {
+			n := zoneChunk
+			if n > len(rrs) {
+				n = len(rrs)
+			}
+			ch <- &dns.Envelope{RR: rrs[:n]}
+			rrs = rrs[n:]
+		}
+		close(ch)
+	}()
+
+	err := (&dns.Transfer{}).Out(w, req, ch)
+	if err != nil {
+		msg.Warn(fmt.Errorf("unable to transfer %v: %v", z.origin, err))
+		for range ch {
+		}
+	}
+}
+
+// Reply with the answer.
+func (z *zone) reply(w dns.ResponseWriter, req *dns.Msg, rcode int, answer ...dns.RR) {
+	m := new(dns.Msg)
+	m.SetRcode(req, rcode)
+	m.Authoritative = rcode == dns.RcodeSuccess
+	m.Answer = answer
+	err := w.WriteMsg(m)
+	if err != nil {
+		msg.Warn(fmt.Errorf("unable to write DNS request for %v: %v", req.Question[0], err))
+	}
+}
+
+func (z *zone) soa() dns.RR {
+	z.Lock()
+	defer z.Unlock()
+	return z.soaSerial(z.serial)
+}
+
+func (z *zone) soaSerial(serial uint32) dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: z.origin, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+		Ns:      "localhost.",
+		Mbox:    "hostmaster." + z.origin,
+		Serial:  serial,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  604800,
+		Minttl:  60,
+	}
+}
+
+// The record for a trigger.
+func (z *zone) rr(trigger cfg.Trigger) dns.RR {
+	target := "."
+	if trigger.Passthru {
+		target = "rpz-passthru."
+	}
+	return &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: trigger.Name + "." + z.origin, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+		Target: target,
+	}
+}
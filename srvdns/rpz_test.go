@@ -0,0 +1,89 @@
+package srvdns
+
+import (
+	"net"
+	"testing"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/tt"
+	"github.com/miekg/dns"
+)
+
+func names(rrs []dns.RR) []string {
+	var out []string
+	for _, rr := range rrs {
+		switch r := rr.(type) {
+		case *dns.SOA:
+			out = append(out, "SOA")
+		case *dns.NS:
+			out = append(out, "NS")
+		default:
+			out = append(out, r.Header().Name)
+		}
+	}
+	return out
+}
+
+func TestZone(t *testing.T) {
+	defer cfg.Hosts.Purge()
+	cfg.Hosts.Purge()
+
+	z := &zone{origin: "rpz.test.", serial: 1}
+	cfg.Hosts.Add("example.com")
+	z.update()
+	tt.Eq(t, "serial", uint32(2), z.serial)
+	tt.Eq(t, "axfr", []string{"SOA", "NS", "*.example.com.rpz.test.", "example.com.rpz.test.", "SOA"},
+		names(z.axfr()))
+
+	// No changes.
+	z.update()
+	tt.Eq(t, "serial", uint32(2), z.serial)
+	cfg.Hosts.Add("ads.example.com")
+	z.update()
+	tt.Eq(t, "serial", uint32(2), z.serial)
+
+	cfg.Hosts.Remove("example.com")
+	z.update()
+	tt.Eq(t, "serial", uint32(3), z.serial)
+
+	tt.Eq(t, "ixfr current", []string{"SOA"}, names(z.ixfr(3)))
+	tt.Eq(t, "ixfr", []string{"SOA",
+		"SOA", "*.example.com.rpz.test.", "example.com.rpz.test.",
+		"SOA", "*.ads.example.com.rpz.test.", "ads.example.com.rpz.test.",
+		"SOA"}, names(z.ixfr(2)))
+	tt.Eq(t, "ixfr unknown", []string{"SOA", "NS", "*.ads.example.com.rpz.test.", "ads.example.com.rpz.test.", "SOA"},
+		names(z.ixfr(42)))
+}
+
+func TestZonePassthru(t *testing.T) {
+	defer cfg.Hosts.Purge()
+	defer cfg.Filters.Purge()
+
+	z := &zone{origin: "rpz.test.", serial: 1}
+	cfg.Hosts.Add("example.com")
+	z.update()
+
+	// Adding the exception changes the filters but not the generation; it's
+	// picked up on the next change to the hosts.
+	f := &cfg.Filter{Text: "@@|good.example.com^", Name: "good.example.com", Exact: true, Exception: true}
+	cfg.Filters.Add(f)
+	cfg.Hosts.Add("ads.example.com")
+	z.update()
+	tt.Eq(t, "serial", uint32(3), z.serial)
+
+	rrs := z.axfr()
+	tt.Eq(t, "axfr", []string{"SOA", "NS", "*.example.com.rpz.test.", "example.com.rpz.test.",
+		"good.example.com.rpz.test.", "SOA"}, names(rrs))
+	tt.Eq(t, "passthru", "rpz-passthru.", rrs[4].(*dns.CNAME).Target)
+	tt.Eq(t, "ixfr", []string{"SOA", "SOA", "SOA", "good.example.com.rpz.test.", "SOA"}, names(z.ixfr(2)))
+}
+
+func TestZoneAllowed(t *testing.T) {
+	_, n, _ := net.ParseCIDR("192.168.1.0/24")
+	z := &zone{allow: []*net.IPNet{n}}
+
+	tt.Eq(t, "loopback", true, z.allowed(net.ParseIP("127.0.0.1")))
+	tt.Eq(t, "allow", true, z.allowed(net.ParseIP("192.168.1.42")))
+	tt.Eq(t, "deny", false, z.allowed(net.ParseIP("192.168.2.42")))
+	tt.Eq(t, "nil", false, z.allowed(nil))
+}
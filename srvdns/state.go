@@ -0,0 +1,186 @@
+package srvdns
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/msg"
+)
+
+// StatePath is the location in the chroot where the overrides, and optionally
+// the cache, are saved so they're kept after a restart.
+const StatePath = "/state.json"
+
+const stateVersion = 3
+
+type stateT struct {
+	Version   int                   `json:"version"`
+	Overrides []stateOverride       `json:"overrides"`
+	Cache     map[string]stateCache `json:"cache,omitempty"`
+	Rules     string                `json:"rules,omitempty"` // cfg.Config.RulesHash() for the cache.
+}
+
+type stateOverride struct {
+	Host   string `json:"host"`
+	Client string `json:"client,omitempty"`
+	cfg.OverrideEntry
+}
+
+type stateCache struct {
+	Response uint8 `json:"response"`
+	Expires  int64 `json:"expires"`
+}
+
+// The cache loaded by LoadState(), and the hash of the rules it was saved with.
+// It's not used until RestoreCache() is called after the rules are read.
+var savedCache struct {
+	sync.Mutex
+	rules string
+	cache map[string]stateCache
+}
+
+// SaveState saves the overrides, and the cache if withCache is set, to path.
+func SaveState(path string, withCache bool) error {
+	st := stateT{Version: stateVersion, Overrides: []stateOverride{}}
+	for k, e := range cfg.Override.List() {
+		st.Overrides = append(st.Overrides, stateOverride{k.Host, k.Client, e})
+	}
+	if withCache {
+		st.Rules = cfg.Config.RulesHash()
+		Cache.RLock()
+		st.Cache = make(map[string]stateCache, len(Cache.m))
+		for k, v := range Cache.m {
+			st.Cache[k] = stateCache{v.response, v.expires}
+		}
+		Cache.RUnlock()
+	}
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return cfg.WriteAtomic(path, data)
+}
+
+// LoadState loads the overrides and cache from path, if it exists. Entries
+// that expired are skipped. The cache isn't used until RestoreCache() is
+// called.
+func LoadState(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var st stateT
+	var version struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &version); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	switch version.Version {
+	case 1:
+		// Version 1 had only the expiry, and all overrides applied to
+		// subdomains.
+		var v1 struct {
+			Overrides map[string]int64      `json:"overrides"`
+			Cache     map[string]stateCache `json:"cache"`
+		}
+		if err := json.Unmarshal(data, &v1); err != nil {
+			return fmt.Errorf("%v: %v", path, err)
+		}
+		for host, exp := range v1.Overrides {
+			st.Overrides = append(st.Overrides, stateOverride{Host: host,
+				OverrideEntry: cfg.OverrideEntry{Expires: exp, Subtree: true}})
+		}
+		st.Cache = v1.Cache
+	case 2:
+		// Version 2 had no clients; all overrides applied to all clients.
+		var v2 struct {
+			Overrides map[string]cfg.OverrideEntry `json:"overrides"`
+			Cache     map[string]stateCache        `json:"cache"`
+		}
+		if err := json.Unmarshal(data, &v2); err != nil {
+			return fmt.Errorf("%v: %v", path, err)
+		}
+		for host, e := range v2.Overrides {
+			st.Overrides = append(st.Overrides, stateOverride{Host: host, OverrideEntry: e})
+		}
+		st.Cache = v2.Cache
+	case stateVersion:
+		if err := json.Unmarshal(data, &st); err != nil {
+			return fmt.Errorf("%v: %v", path, err)
+		}
+	default:
+		return fmt.Errorf("%v: unknown version %v", path, version.Version)
+	}
+
+	now := time.Now().Unix()
+	for _, o := range st.Overrides {
+		if o.Expires > now {
+			cfg.Override.Store(cfg.OverrideKey{Host: o.Host, Client: o.Client}, o.OverrideEntry)
+		}
+	}
+	savedCache.Lock()
+	savedCache.rules, savedCache.cache = st.Rules, st.Cache
+	savedCache.Unlock()
+	return nil
+}
+
+// RestoreCache adds the cache loaded by LoadState(), if the rules didn't change
+// since it was saved; the cached responses may be wrong otherwise. This must be
+// called after the rules are read.
+func RestoreCache() {
+	savedCache.Lock()
+	rules, cache := savedCache.rules, savedCache.cache
+	savedCache.rules, savedCache.cache = "", nil
+	savedCache.Unlock()
+
+	if len(cache) == 0 {
+		return
+	}
+	if rules == "" || rules != cfg.Config.RulesHash() {
+		msg.Info("not restoring the saved cache as the rules changed", cfg.Config.Verbose)
+		return
+	}
+
+	now := time.Now().Unix()
+	for k, v := range cache {
+		if v.Expires > now {
+			Cache.Store(k, CacheEntry{response: v.Response, expires: v.Expires})
+		}
+	}
+}
+
+// KeepState saves the state to path whenever the overrides changed, and the
+// cache every 5 minutes if withCache is set. This never returns.
+func KeepState(path string, withCache bool) {
+	saved := cfg.Override.List()
+	last := time.Now()
+	for {
+		time.Sleep(10 * time.Second)
+
+		cacheDue := withCache && time.Since(last) > 5*time.Minute
+		overrides := cfg.Override.List()
+		if !cacheDue && reflect.DeepEqual(overrides, saved) {
+			continue
+		}
+
+		err := SaveState(path, withCache)
+		if err != nil {
+			msg.Warn(fmt.Errorf("unable to save state: %v", err))
+			continue
+		}
+		saved = overrides
+		last = time.Now()
+	}
+}
@@ -0,0 +1,86 @@
+package srvdns
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/tt"
+)
+
+func TestState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackwall-state")
+	tt.Err(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	defer cfg.Override.Purge()
+	defer Cache.Purge()
+
+	path := filepath.Join(dir, "state.json")
+	tt.Err(t, LoadState(path))
+
+	now := time.Now().Unix()
+	cfg.Override.Store(cfg.OverrideKey{Host: "example.com"}, cfg.OverrideEntry{Expires: now + 3600, Subtree: true})
+	cfg.Override.Store(cfg.OverrideKey{Host: "example.com", Client: "10.0.0.1"}, cfg.OverrideEntry{Expires: now + 60})
+	cfg.Override.Store(cfg.OverrideKey{Host: "expired.example.com"}, cfg.OverrideEntry{Expires: now - 1})
+	Cache.Store("A example.net 127.0.0.1", CacheEntry{reponseSpoof, now + 3600})
+	Cache.Store("A expired.example.net 127.0.0.1", CacheEntry{reponseSpoof, now - 1})
+
+	// Without the cache.
+	tt.Err(t, SaveState(path, false))
+	cfg.Override.Purge()
+	Cache.Purge()
+	tt.Err(t, LoadState(path))
+	tt.Eq(t, "overrides", map[cfg.OverrideKey]cfg.OverrideEntry{
+		{Host: "example.com"}:                     {Expires: now + 3600, Subtree: true},
+		{Host: "example.com", Client: "10.0.0.1"}: {Expires: now + 60},
+	}, cfg.Override.List())
+	tt.Eq(t, "cache", 0, Cache.Len())
+
+	// With the cache.
+	Cache.Store("A example.net 127.0.0.1", CacheEntry{reponseSpoof, now + 3600})
+	Cache.Store("A expired.example.net 127.0.0.1", CacheEntry{reponseSpoof, now - 1})
+	tt.Err(t, SaveState(path, true))
+	cfg.Override.Purge()
+	Cache.Purge()
+	tt.Err(t, LoadState(path))
+	tt.Eq(t, "overrides", 2, len(cfg.Override.List()))
+	tt.Eq(t, "cache", 0, Cache.Len())
+	RestoreCache()
+	tt.Eq(t, "cache", 1, Cache.Len())
+	e, _ := Cache.Get("A example.net 127.0.0.1")
+	tt.Eq(t, "cache entry", CacheEntry{reponseSpoof, now + 3600}, e)
+
+	// Not restored if the rules changed.
+	tt.Err(t, SaveState(path, true))
+	Cache.Purge()
+	tt.Err(t, LoadState(path))
+	cfg.Config.Hosts = []string{"example.net"}
+	RestoreCache()
+	cfg.Config.Hosts = nil
+	tt.Eq(t, "cache", 0, Cache.Len())
+
+	_, err = os.Stat(path + ".tmp")
+	tt.Eq(t, "tmp removed", true, os.IsNotExist(err))
+
+	// Version 1 only had the expiry.
+	tt.Err(t, ioutil.WriteFile(path, []byte(fmt.Sprintf(`{"version": 1, "overrides": {"example.org": %v}}`, now+60)), 0644))
+	cfg.Override.Purge()
+	tt.Err(t, LoadState(path))
+	tt.Eq(t, "overrides", map[cfg.OverrideKey]cfg.OverrideEntry{{Host: "example.org"}: {Expires: now + 60, Subtree: true}}, cfg.Override.List())
+
+	// Version 2 had no clients.
+	tt.Err(t, ioutil.WriteFile(path, []byte(fmt.Sprintf(`{"version": 2, "overrides": {"example.org": {"expires": %v}}}`, now+60)), 0644))
+	cfg.Override.Purge()
+	tt.Err(t, LoadState(path))
+	tt.Eq(t, "overrides", map[cfg.OverrideKey]cfg.OverrideEntry{{Host: "example.org"}: {Expires: now + 60}}, cfg.Override.List())
+
+	// Corrupt file.
+	tt.Err(t, ioutil.WriteFile(path, []byte(`{"version": 9}`), 0644))
+	if err := LoadState(path); err == nil {
+		t.Error("no error for unknown version")
+	}
+}
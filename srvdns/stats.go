@@ -0,0 +1,190 @@
+package srvdns
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// Number of recent queries to keep.
+	recentQueries = 200
+
+	// Maximum number of names or clients to count; when there are more the
+	// counts are halved and the ones that drop to 0 are removed.
+	maxCounted = 10000
+)
+
+// StatsT are statistics about the DNS queries since the server started.
+type StatsT struct {
+	sync.Mutex
+	start     time.Time
+	queries   int64
+	blocked   int64
+	cached    int64
+	responses map[string]int64
+	recent    []LoggedQuery
+	next      int
+
+	blockedNames map[string]int64
+	allowedNames map[string]int64
+	clients      map[string]int64
+}
+
+// LoggedQuery is a DNS query we answered.
+type LoggedQuery struct {
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Response string    `json:"response"`
+	Blocked  bool      `json:"blocked"`
+	Cached   bool      `json:"cached"`
+}
+
+// Count is the number of queries for a name or from a client.
+type Count struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// StatsSummary is a copy of the statistics.
+type StatsSummary struct {
+	Since      time.Time        `json:"since"`
+	Queries    int64            `json:"queries"`
+	Blocked    int64            `json:"blocked"`
+	Cached     int64            `json:"cached"`
+	Responses  map[string]int64 `json:"responses"`
+	Recent     []LoggedQuery    `json:"recent"`
+	TopBlocked []Count          `json:"top_blocked"`
+	TopAllowed []Count          `json:"top_allowed"`
+	TopClients []Count          `json:"top_clients"`
+}
+
+// Stats for all the queries to the DNS server.
+var Stats StatsT
+
+func init() {
+	Stats.Reset()
+}
+
+// Reset all statistics.
+func (s *StatsT) Reset() {
+	s.Lock()
+	defer s.Unlock()
+	s.start = time.Now()
+	s.queries, s.blocked, s.cached = 0, 0, 0
+	s.responses = make(map[string]int64)
+	s.recent = make([]LoggedQuery, 0, recentQueries)
+	s.next = 0
+	s.blockedNames = make(map[string]int64)
+	s.allowedNames = make(map[string]int64)
+	s.clients = make(map[string]int64)
+}
+
+// Make a LoggedQuery for the response to a query for name from client.
+func newLoggedQuery(name string, qtype uint16, client net.IP, response uint8, fromCache bool) LoggedQuery {
+	return LoggedQuery{
+		Time:     time.Now(),
+		Client:   client.String(),
+		Name:     name,
+		Type:     dns.TypeToString[qtype],
+		Response: responseNames[response],
+		Blocked:  isBlocked(response),
+		Cached:   fromCache,
+	}
+}
+
+// Record a query.
+func (s *StatsT) Record(q LoggedQuery) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.queries++
+	s.responses[q.Response]++
+	if q.Cached {
+		s.cached++
+	}
+	if q.Blocked {
+		s.blocked++
+		count(s.blockedNames, q.Name)
+	} else {
+		count(s.allowedNames, q.Name)
+	}
+	count(s.clients, q.Client)
+
+	if len(s.recent) < recentQueries {
+		s.recent = append(s.recent, q)
+	} else {
+		s.recent[s.next] = q
+	}
+	s.next = (s.next + 1) % recentQueries
+}
+
+// Summary gets the statistics, with the n most recent queries and the top n
+// names and clients.
+func (s *StatsT) Summary(n int) StatsSummary {
+	s.Lock()
+	defer s.Unlock()
+
+	sum := StatsSummary{
+		Since:      s.start,
+		Queries:    s.queries,
+		Blocked:    s.blocked,
+		Cached:     s.cached,
+		Responses:  make(map[string]int64, len(s.responses)),
+		Recent:     make([]LoggedQuery, 0, n),
+		TopBlocked: top(s.blockedNames, n),
+		TopAllowed: top(s.allowedNames, n),
+		TopClients: top(s.clients, n),
+	}
+	for k, v := range s.responses {
+		sum.Responses[k] = v
+	}
+
+	// Newest first.
+	for i := 1; i <= len(s.recent) && i <= n; i++ {
+		sum.Recent = append(sum.Recent, s.recent[(s.next-i+len(s.recent))%len(s.recent)])
+	}
+	return sum
+}
+
+// Check if the response blocks the name.
+func isBlocked(response uint8) bool {
+	return response != reponseForward && response != reponseTCPOnly
+}
+
+func count(m map[string]int64, k string) {
+	m[k]++
+	if len(m) <= maxCounted {
+		return
+	}
+	for k, v := range m {
+		if v/2 == 0 {
+			delete(m, k)
+		} else {
+			m[k] = v / 2
+		}
+	}
+}
+
+// Get the n highest counts.
+func top(m map[string]int64, n int) []Count {
+	counts := make([]Count, 0, len(m))
+	for k, v := range m {
+		counts = append(counts, Count{k, v})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count == counts[j].Count {
+			return counts[i].Name < counts[j].Name
+		}
+		return counts[i].Count > counts[j].Count
+	})
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
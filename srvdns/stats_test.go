@@ -0,0 +1,45 @@
+package srvdns
+
+import (
+	"net"
+	"testing"
+
+	"arp242.net/trackwall/tt"
+
+	"github.com/miekg/dns"
+)
+
+func TestStats(t *testing.T) {
+	s := &StatsT{}
+	s.Reset()
+
+	a := net.ParseIP("10.0.0.1")
+	b := net.ParseIP("10.0.0.2")
+	s.Record(newLoggedQuery("ads.example.com", dns.TypeA, a, reponseSpoof, false))
+	s.Record(newLoggedQuery("ads.example.com", dns.TypeAAAA, a, reponseEmpty, true))
+	s.Record(newLoggedQuery("example.com", dns.TypeA, b, reponseForward, false))
+	s.Record(newLoggedQuery("tracker.example.net", dns.TypeA, b, reponseNXDomain, false))
+
+	sum := s.Summary(2)
+	tt.Eq(t, "queries", int64(4), sum.Queries)
+	tt.Eq(t, "blocked", int64(3), sum.Blocked)
+	tt.Eq(t, "cached", int64(1), sum.Cached)
+	tt.Eq(t, "responses", map[string]int64{"spoof": 1, "empty": 1, "forward": 1, "nxdomain": 1}, sum.Responses)
+	tt.Eq(t, "top blocked", []Count{{"ads.example.com", 2}, {"tracker.example.net", 1}}, sum.TopBlocked)
+	tt.Eq(t, "top allowed", []Count{{"example.com", 1}}, sum.TopAllowed)
+	tt.Eq(t, "top clients", []Count{{"10.0.0.1", 2}, {"10.0.0.2", 2}}, sum.TopClients)
+
+	tt.Eq(t, "recent", 2, len(sum.Recent))
+	tt.Eq(t, "newest", "tracker.example.net", sum.Recent[0].Name)
+	tt.Eq(t, "newest", "example.com", sum.Recent[1].Name)
+
+	// Wrap around.
+	for i := 0; i < recentQueries+10; i++ {
+		s.Record(newLoggedQuery("example.org", dns.TypeA, a, reponseForward, false))
+	}
+	s.Record(newLoggedQuery("last.example.org", dns.TypeA, a, reponseForward, false))
+	sum = s.Summary(recentQueries + 10)
+	tt.Eq(t, "recent", recentQueries, len(sum.Recent))
+	tt.Eq(t, "newest", "last.example.org", sum.Recent[0].Name)
+	tt.Eq(t, "oldest", "example.org", sum.Recent[recentQueries-1].Name)
+}
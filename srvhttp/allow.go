@@ -0,0 +1,110 @@
+package srvhttp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How long the allow links on a blocked page are valid.
+const allowTokenValid = time.Hour
+
+// Key to sign the allow links with; this is new for every run, so links from
+// before a restart no longer work.
+var allowKey = make([]byte, 32)
+
+func init() {
+	if _, err := rand.Read(allowKey); err != nil {
+		panic(fmt.Sprintf("unable to generate allow key: %v", err))
+	}
+}
+
+// Tokens that were used, so they can't be used again. The value is the expiry
+// of the token.
+var usedTokens = struct {
+	sync.Mutex
+	m map[string]int64
+}{m: make(map[string]int64)}
+
+// Errors for rejected allow requests.
+var (
+	errAllowMethod  = errors.New("the request was not sent with POST")
+	errAllowOrigin  = errors.New("the request was sent from a different site")
+	errAllowToken   = errors.New("the request has no valid token")
+	errAllowExpired = errors.New("the link on the blocked page expired")
+	errAllowUsed    = errors.New("the link on the blocked page was already used")
+)
+
+// Sign host, the action, and the expiry of the token. The action is what the
+// button does (see allowAction()), so a token can't be used for anything else.
+func allowToken(host, action string, exp int64) string {
+	mac := hmac.New(sha256.New, allowKey)
+	fmt.Fprintf(mac, "%s\n%s\n%d", host, action, exp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Get the action for an allow link: the duration, the scope ("" or "exact"),
+// and who it's for ("" or "all").
+func allowAction(duration, scope, forWhom string) string {
+	return fmt.Sprintf("allow %s scope=%s for=%s", duration, scope, forWhom)
+}
+
+// Check that a request to do action for host was sent from the blocked page: it
+// must be a POST from the same origin, with a valid token that wasn't used yet.
+func checkAllow(r *http.Request, host, action string) error {
+	if r.Method != http.MethodPost {
+		return errAllowMethod
+	}
+	if !sameOrigin(r) {
+		return errAllowOrigin
+	}
+
+	token := r.PostFormValue("token")
+	exp, err := strconv.ParseInt(r.PostFormValue("expires"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(token), []byte(allowToken(host, action, exp))) {
+		return errAllowToken
+	}
+
+	now := time.Now().Unix()
+	if exp < now {
+		return errAllowExpired
+	}
+
+	usedTokens.Lock()
+	defer usedTokens.Unlock()
+	for k, e := range usedTokens.m {
+		if e < now {
+			delete(usedTokens.m, k)
+		}
+	}
+	if _, ok := usedTokens.m[token]; ok {
+		return errAllowUsed
+	}
+	usedTokens.m[token] = exp
+	return nil
+}
+
+// Check if the Origin header, or the Referer if there is no Origin, is the host
+// of the request. Requests with neither are rejected.
+func sameOrigin(r *http.Request) bool {
+	ref := r.Header.Get("Origin")
+	if ref == "" || ref == "null" {
+		ref = r.Header.Get("Referer")
+	}
+	if ref == "" {
+		return false
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host == r.Host
+}
@@ -0,0 +1,135 @@
+package srvhttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/srvdns"
+	"arp242.net/trackwall/tt"
+)
+
+func TestCheckAllow(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	expired := time.Now().Add(-time.Second).Unix()
+	act := allowAction("1h", "", "")
+
+	cases := []struct {
+		method, origin, referer, token string
+		exp                            int64
+		expected                       error
+	}{
+		{"POST", "http://ads.example.com", "", allowToken("ads.example.com", act, exp), exp, nil},
+		{"POST", "", "https://ads.example.com/x.js", allowToken("ads.example.com", act, exp+1), exp + 1, nil},
+		{"POST", "null", "http://ads.example.com/", allowToken("ads.example.com", act, exp+2), exp + 2, nil},
+
+		{"GET", "http://ads.example.com", "", allowToken("ads.example.com", act, exp), exp, errAllowMethod},
+		{"POST", "http://evil.example.com", "", allowToken("ads.example.com", act, exp), exp, errAllowOrigin},
+		{"POST", "", "http://evil.example.com/ads.example.com", allowToken("ads.example.com", act, exp), exp, errAllowOrigin},
+		{"POST", "", "", allowToken("ads.example.com", act, exp), exp, errAllowOrigin},
+		{"POST", "http://ads.example.com", "", "", exp, errAllowToken},
+		{"POST", "http://ads.example.com", "", allowToken("other.example.com", act, exp), exp, errAllowToken},
+		{"POST", "http://ads.example.com", "", allowToken("ads.example.com", act, exp), exp + 3, errAllowToken},
+		{"POST", "http://ads.example.com", "", allowToken("ads.example.com", allowAction("10y", "", ""), exp), exp, errAllowToken},
+		{"POST", "http://ads.example.com", "", allowToken("ads.example.com", allowAction("1h", "", "all"), exp), exp, errAllowToken},
+		{"POST", "http://ads.example.com", "", allowToken("ads.example.com", "report", exp), exp, errAllowToken},
+		{"POST", "http://ads.example.com", "", allowToken("ads.example.com", act, expired), expired, errAllowExpired},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%v", i), func(t *testing.T) {
+			r := allowRequest(tc.method, tc.token, tc.exp)
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if tc.referer != "" {
+				r.Header.Set("Referer", tc.referer)
+			}
+			tt.Eq(t, "err", tc.expected, checkAllow(r, "ads.example.com", act))
+		})
+	}
+}
+
+func TestCheckAllowReplay(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	token := allowToken("replay.example.com", "report", exp)
+	for _, expected := range []error{nil, errAllowUsed} {
+		r := allowRequest("POST", token, exp)
+		r.Host = "replay.example.com"
+		r.Header.Set("Origin", "http://replay.example.com")
+		tt.Eq(t, "err", expected, checkAllow(r, "replay.example.com", "report"))
+	}
+}
+
+func TestAllowRejected(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://ads.example.com/$@_allow/10y/", nil)
+	w := httptest.NewRecorder()
+	(&handleHTTP{}).ServeHTTP(w, r)
+	tt.Eq(t, "status", http.StatusForbidden, w.Code)
+	if !strings.Contains(w.Body.String(), "refused to unblock") {
+		t.Errorf("wrong body: %v", w.Body.String())
+	}
+}
+
+func TestAllowBadDuration(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix() + 10
+	act := allowAction("xx", "", "")
+	form := url.Values{"token": {allowToken("ads.example.com", act, exp)}, "expires": {fmt.Sprintf("%d", exp)}}
+	r := httptest.NewRequest("POST", "http://ads.example.com/$@_allow/xx/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Origin", "http://ads.example.com")
+	w := httptest.NewRecorder()
+	(&handleHTTP{}).ServeHTTP(w, r)
+	tt.Eq(t, "status", http.StatusBadRequest, w.Code)
+	if !strings.Contains(w.Body.String(), "refused to unblock") {
+		t.Errorf("wrong body: %v", w.Body.String())
+	}
+
+	// The token wasn't used.
+	r = allowRequest("POST", form.Get("token"), exp)
+	r.Header.Set("Origin", "http://ads.example.com")
+	tt.Eq(t, "err", nil, checkAllow(r, "ads.example.com", act))
+}
+
+func allowRequest(method, token string, exp int64) *http.Request {
+	form := url.Values{"token": {token}, "expires": {fmt.Sprintf("%d", exp)}}
+	r := httptest.NewRequest(method, "http://ads.example.com/$@_allow/1h/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestAlwaysAllowLink(t *testing.T) {
+	defer cfg.Hosts.Purge()
+	cfg.Hosts.Add("tracker.example.com", "ads.example.net", "x.ads.example.net")
+
+	cases := []struct {
+		name     string
+		expected *alwaysLink
+	}{
+		{"example.com", nil},
+		{"tracker.example.com", &alwaysLink{Host: "tracker.example.com", URL: "/$@_always/host/x.js", action: "always host"}},
+		{"a.tracker.example.com", &alwaysLink{Host: "tracker.example.com", Parent: true, URL: "/$@_always/parent/x.js", action: "always parent"}},
+		{"x.ads.example.net", &alwaysLink{Host: "ads.example.net", Parent: true, URL: "/$@_always/parent/x.js", action: "always parent"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tt.Eq(t, "link", tc.expected, alwaysAllowLink(tc.name, "x.js"))
+		})
+	}
+
+	// Can't allow the host if a parent domain blocks it.
+	exp := time.Now().Add(time.Hour).Unix()
+	form := url.Values{"token": {allowToken("a.tracker.example.com", "always host", exp)}, "expires": {fmt.Sprintf("%d", exp)}}
+	r := httptest.NewRequest("POST", "http://a.tracker.example.com/$@_always/host/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Origin", "http://a.tracker.example.com")
+	w := httptest.NewRecorder()
+	(&handleHTTP{}).ServeHTTP(w, r)
+	tt.Eq(t, "status", http.StatusBadRequest, w.Code)
+	tt.Eq(t, "still blocked", true, len(srvdns.BlockingHosts("a.tracker.example.com")) == 1)
+}
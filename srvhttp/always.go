@@ -0,0 +1,114 @@
+package srvhttp
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/msg"
+	"arp242.net/trackwall/srvdns"
+)
+
+// Handle "$@_always/host/redirect" and "$@_always/parent/redirect" to always
+// allow a host by adding unhost entries to cfg.AddedPath, and
+// "$@_undo/redirect" to undo that.
+func (f *handleHTTP) always(w http.ResponseWriter, r *http.Request, host, url string) {
+	params := strings.Split(url, "/")
+	undo := params[0] == "$@_undo"
+	redirect := strings.Join(params[1:], "/")
+	if !undo {
+		if len(params) < 2 {
+			http.Error(w, "need host or parent", http.StatusBadRequest)
+			return
+		}
+		redirect = strings.Join(params[2:], "/")
+	}
+
+	// The undo token also signs the hosts to add back.
+	name := hostname(r)
+	action := "always " + params[1]
+	if undo {
+		action = "undo " + r.PostFormValue("hosts")
+	}
+	if err := checkAllow(r, name, action); err != nil {
+		msg.Warn(fmt.Errorf("rejected always allow for %v from %v: %v", name, clientAddr(r), err))
+		rejected(w, http.StatusForbidden, host, redirect, err)
+		return
+	}
+
+	if undo {
+		hosts := strings.Split(r.PostFormValue("hosts"), ",")
+		if err := undoAlwaysAllow(hosts...); err != nil {
+			msg.Warn(fmt.Errorf("undo always allow: %v", err))
+		}
+		w.Header().Set("Location", "/"+redirect)
+		w.WriteHeader(http.StatusSeeOther)
+		return
+	}
+
+	hosts := srvdns.BlockingHosts(name)
+	switch {
+	case params[1] == "host" && len(hosts) == 1 && hosts[0] == name:
+	case params[1] == "parent" && len(hosts) > 0 && hosts[0] != name:
+	default:
+		http.Error(w, fmt.Sprintf("can't always allow %v for %v", params[1], name), http.StatusBadRequest)
+		return
+	}
+	if err := alwaysAllow(hosts...); err != nil {
+		msg.Warn(fmt.Errorf("always allow: %v", err))
+	}
+	publish(r, "allow")
+
+	// Use the redirect from the path as is, as the template escapes it.
+	path := ""
+	if p := strings.SplitN(strings.TrimLeft(r.URL.Path, "/"), "/", 3); len(p) == 3 {
+		path = p[2]
+	}
+	exp := time.Now().Add(allowTokenValid).Unix()
+	render(w, http.StatusOK, "allowed.html", pageData{
+		Host:    name,
+		Path:    path,
+		Client:  clientAddr(r),
+		Referer: r.Referer(),
+		Token:   allowToken(name, "undo "+strings.Join(hosts, ","), exp),
+		Expires: exp,
+		Allowed: hosts,
+		Undo:    specialURL("$@_undo/"+path, ""),
+	})
+}
+
+// Get the "always allow" link for the blocked page. The host itself can only be
+// allowed if it's in the hosts list, and not blocked by a parent domain as well.
+func alwaysAllowLink(name, path string) *alwaysLink {
+	hosts := srvdns.BlockingHosts(name)
+	switch {
+	case len(hosts) == 0:
+		return nil
+	case hosts[0] == name:
+		return &alwaysLink{Host: name, URL: specialURL("$@_always/host/"+path, ""), action: "always host"}
+	default:
+		return &alwaysLink{Host: hosts[0], Parent: true, URL: specialURL("$@_always/parent/"+path, ""), action: "always parent"}
+	}
+}
+
+// Remove the hosts from the hosts list, and save this in cfg.AddedPath.
+func alwaysAllow(hosts ...string) error {
+	cfg.Hosts.Remove(hosts...)
+	cfg.Origins.Set(cfg.OriginUnhost, cfg.Origin{Source: cfg.AddedPath}, hosts...)
+	srvdns.Cache.DeleteDomain(hosts...)
+	return cfg.SaveAdded(cfg.OriginHost, false, hosts...)
+}
+
+// Add the hosts back after alwaysAllow().
+func undoAlwaysAllow(hosts ...string) error {
+	for _, h := range hosts {
+		if !cfg.ValidHost(h) {
+			return fmt.Errorf("invalid host: %#v", h)
+		}
+	}
+	cfg.Hosts.Add(hosts...)
+	srvdns.Cache.DeleteDomain(hosts...)
+	return cfg.UndoAdded(cfg.OriginHost, false, hosts...)
+}
@@ -0,0 +1,112 @@
+package srvhttp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+)
+
+// ControlTLS gets the TLS configuration for the control listener. The server
+// certificate is issued by the root CA, and clients must present a certificate
+// issued by the root CA as well (see IssueClientCert()).
+//
+// The name is used if the client doesn't send a server name, which is the
+// case if it connects to an IP address.
+func ControlTLS(name string) (*tls.Config, error) {
+	root, _, err := loadRoot(cfg.Config.RootCert, cfg.Config.RootKey)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(root)
+
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName == "" {
+				return certFor(name)
+			}
+			return certFor(hello.ServerName)
+		},
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// ClientTLS gets the TLS configuration to connect to the control listener
+// with a short-lived client certificate. The paths are for the root
+// certificate and key, which must be readable.
+func ClientTLS(rootCert, rootKey, serverName string) (*tls.Config, error) {
+	certPEM, keyPEM, err := IssueClientCert(rootCert, rootKey, "trackwall", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	root, _, err := loadRoot(rootCert, rootKey)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(root)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// IssueClientCert issues a client certificate for the control listener with
+// the root CA, and returns the PEM-encoded certificate and key.
+func IssueClientCert(rootCert, rootKey, name string, validity time.Duration) (certPEM, keyPEM []byte, err error) {
+	root, rootkey, err := loadRoot(rootCert, rootKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   name,
+			Organization: []string{"trackwall"},
+		},
+		NotBefore:             time.Now().Add(-5 * time.Minute),
+		NotAfter:              time.Now().Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &template, root, &key.PublicKey, rootkey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
@@ -0,0 +1,47 @@
+package srvhttp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/msg"
+	"arp242.net/trackwall/srvdns"
+)
+
+// Handle "$@_report/redirect" to report a host as wrongly blocked.
+func (f *handleHTTP) report(w http.ResponseWriter, r *http.Request, host, url string) {
+	redirect := strings.TrimPrefix(url, "$@_report/")
+	name := hostname(r)
+	if err := checkAllow(r, name, "report"); err != nil {
+		msg.Warn(fmt.Errorf("rejected report for %v from %v: %v", name, clientAddr(r), err))
+		rejected(w, http.StatusForbidden, host, redirect, err)
+		return
+	}
+
+	// The referer of the blocked page, rather than this request.
+	referer := r.PostFormValue("referer")
+	if len(referer) > 1024 {
+		referer = referer[:1024]
+	}
+
+	rule, o := srvdns.BlockedBy(name, net.ParseIP(clientAddr(r)))
+	rep, err := cfg.Reports.Add(cfg.Report{
+		Time:    time.Now(),
+		Host:    name,
+		Rule:    rule,
+		List:    o.Source,
+		Line:    o.Line,
+		Referer: referer,
+		Client:  clientAddr(r),
+	})
+	if err != nil {
+		msg.Warn(fmt.Errorf("unable to save report: %v", err))
+	}
+	msg.Info(fmt.Sprintf("report %v: %v is wrongly blocked by %v", rep.ID, name, rule), cfg.Config.Verbose)
+
+	render(w, http.StatusOK, "reported.html", pageData{Host: host, Path: redirect})
+}
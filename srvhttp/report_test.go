@@ -0,0 +1,49 @@
+package srvhttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/tt"
+)
+
+func TestReport(t *testing.T) {
+	defer cfg.Hosts.Purge()
+	defer cfg.Origins.Purge()
+	defer cfg.Reports.Load("") // nolint: errcheck
+
+	cfg.Hosts.Add("tracker.example.com")
+	cfg.Origins.Set(cfg.OriginHost, cfg.Origin{Source: "file:///hosts", Line: 2}, "tracker.example.com")
+
+	exp := time.Now().Add(time.Hour).Unix()
+	form := url.Values{
+		"token":   {allowToken("tracker.example.com", "report", exp)},
+		"expires": {fmt.Sprintf("%d", exp)},
+		"referer": {"https://shop.example.net/cart"},
+	}
+	r := httptest.NewRequest("POST", "http://tracker.example.com/$@_report/x.js", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Origin", "http://tracker.example.com")
+	w := httptest.NewRecorder()
+	(&handleHTTP{}).ServeHTTP(w, r)
+	tt.Eq(t, "status", http.StatusOK, w.Code)
+
+	reports := cfg.Reports.List()
+	tt.Eq(t, "reports", 1, len(reports))
+	reports[0].Time = time.Time{}
+	tt.Eq(t, "report", cfg.Report{
+		ID:      1,
+		Host:    "tracker.example.com",
+		Rule:    "tracker.example.com",
+		List:    "file:///hosts",
+		Line:    2,
+		Referer: "https://shop.example.net/cart",
+		Client:  "192.0.2.1",
+	}, reports[0])
+}
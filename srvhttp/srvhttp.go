@@ -93,21 +93,23 @@ func (f *handleHTTP) spoof(w http.ResponseWriter, r *http.Request, host, url str
 	// TODO: Do something sane with the Content-Type header
 	sur, success := cfg.Surrogates.Find(host)
 	if success {
+		publish(r, "surrogate")
 		w.Header().Set("Content-Type", "application/javascript")
 		fmt.Fprintf(w, sur)
 		return
 	}
+	publish(r, "stub")
 
-	// Default blocked text
+	// Serve a stub for scripts and stylesheets, so they won't give parse
+	// errors.
 	// TODO: Not reliable enough...
-	if strings.HasSuffix(url, ".js") {
-		// Add a comment so it won't give parse errors
-		// TODO: Make this a text message, rather than HTML
-		w.Header().Set("Content-Type", "application/javascript")
-		fmt.Fprintf(w, fmt.Sprintf("/*"+tplBlocked+"*/", host, url))
-	} else {
-		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprintf(w, fmt.Sprintf("/*"+tplBlocked+"*/", host, url))
+	switch {
+	case strings.HasSuffix(url, ".js"):
+		render(w, http.StatusOK, "blocked.js", blockedData(r))
+	case strings.HasSuffix(url, ".css"):
+		render(w, http.StatusOK, "blocked.css", blockedData(r))
+	default:
+		render(w, http.StatusOK, "blocked.html", blockedData(r))
 	}
 }
 
@@ -116,17 +118,49 @@ func (f *handleHTTP) special(w http.ResponseWriter, r *http.Request, host, url s
 	// $@_allow/duration/redirect
 	if strings.HasPrefix(url, "$@_allow") {
 		params := strings.Split(url, "/")
-		//fmt.Println(params)
+		if len(params) < 2 {
+			http.Error(w, "need a duration", http.StatusBadRequest)
+			return
+		}
+
+		// Check the duration first, so the token isn't used up.
+		name := hostname(r)
 		secs, err := msg.DurationToSeconds(params[1])
-		_ = secs
 		if err != nil {
-			msg.Warn(err)
+			rejected(w, http.StatusBadRequest, host, strings.Join(params[2:], "/"), err)
 			return
 		}
 
-		// TODO: Always add the shortest entry from the hosts here
-		cfg.Override.Store(host, time.Now().Add(time.Duration(secs)*time.Second).Unix())
-		srvdns.Cache.Delete("A "+host, "AAAA "+host)
+		// Only allow requests from the blocked page, so other sites can't
+		// unblock anything.
+		action := allowAction(params[1], r.FormValue("scope"), r.FormValue("for"))
+		if err := checkAllow(r, name, action); err != nil {
+			msg.Warn(fmt.Errorf("rejected allow for %v from %v: %v", name, clientAddr(r), err))
+			rejected(w, http.StatusForbidden, host, strings.Join(params[2:], "/"), err)
+			return
+		}
+
+		// Allow the host from the hosts list that blocked this, so that all its
+		// subdomains are allowed as well, unless ?scope=exact is used. This
+		// only applies to the client that made the request, unless ?for=all is
+		// used.
+		exp := time.Now().Add(time.Duration(secs) * time.Second)
+		client := clientAddr(r)
+		if r.FormValue("for") == "all" {
+			client = ""
+		}
+		k := cfg.OverrideKey{Host: srvdns.BlockingHost(name), Client: client}
+		if r.FormValue("scope") == "exact" {
+			k.Host = name
+		}
+		srvdns.Allow(k.Host, k.Client, exp, r.FormValue("scope") != "exact")
+		e, _ := cfg.Override.Get(k)
+		srvdns.Audit("add", k, e, time.Duration(secs)*time.Second, srvdns.Requester{
+			Client:    clientAddr(r),
+			UserAgent: r.UserAgent(),
+			Referer:   r.Referer(),
+		})
+		publish(r, "allow")
 
 		// Redirect back to where the user came from
 		// TODO: Also add query parameters and such!
@@ -155,11 +189,52 @@ func (f *handleHTTP) special(w http.ResponseWriter, r *http.Request, host, url s
 			srvdns.Cache.Dump()
 		}
 		*/
+	} else if strings.HasPrefix(url, "$@_always/") || strings.HasPrefix(url, "$@_undo/") {
+		f.always(w, r, host, url)
+	} else if strings.HasPrefix(url, "$@_report/") {
+		f.report(w, r, host, url)
 	} else {
 		fmt.Fprintf(w, "unknown command: %v", url)
 	}
 }
 
+// Get the hostname of the request, without the port.
+func hostname(r *http.Request) string {
+	name := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(name); err == nil {
+		name = h
+	}
+	return strings.TrimRight(name, ".")
+}
+
+// Get the IP address of the client that made the request.
+func clientAddr(r *http.Request) string {
+	client := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(client); err == nil {
+		client = h
+	}
+	if ip := net.ParseIP(client); ip != nil {
+		return ip.String()
+	}
+	return client
+}
+
+// Send the request to subscribers of the event stream.
+func publish(r *http.Request, response string) {
+	srvdns.Events.Publish(srvdns.Event{
+		LoggedQuery: srvdns.LoggedQuery{
+			Time:     time.Now(),
+			Client:   clientAddr(r),
+			Name:     hostname(r),
+			Type:     r.Method,
+			Response: response,
+			Blocked:  response != "allow",
+		},
+		Kind: "http",
+		URL:  r.URL.String(),
+	})
+}
+
 // The MIT License (MIT)
 //
 // Copyright © 2016-2017 Martin Tournoij
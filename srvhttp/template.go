@@ -0,0 +1,282 @@
+package srvhttp
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+	"unicode/utf8"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/msg"
+	"arp242.net/trackwall/srvdns"
+)
+
+// pageData is passed to the templates.
+type pageData struct {
+	Host    string // Name that was blocked.
+	Path    string // Path of the request, without the leading /.
+	Rule    string // Rule that blocked the host; "" if not known.
+	List    string // Where the rule was loaded from.
+	Line    int    // Line number in the list; 0 if not known.
+	Client  string // IP address.
+	Referer string // Page that loaded the host.
+
+	// The allow links need to be sent as a POST with their token and expires
+	// as form values. Every link has its own token, which is only valid for
+	// that link.
+	Token       string // Only for allowed.html: the token for Undo.
+	Expires     int64
+	Allow       []allowLink // Unblock the domain and its subdomains for the client.
+	AllowExact  []allowLink // Unblock only Host for the client.
+	AllowAll    []allowLink // Unblock the domain and its subdomains for everyone.
+	Always      *alwaysLink // nil if it can't be always allowed.
+	Report      string      // Report the host as wrongly blocked.
+	ReportToken string
+
+	// Only for allowed.html: the hosts that are now always allowed, and the
+	// link to undo that.
+	Allowed []string
+	Undo    string
+
+	// Only for rejected.html: why the request was rejected.
+	Error string
+}
+
+type allowLink struct {
+	Duration string // "1h", "10y", etc.
+	Label    string // "an hour", "permanently", etc.
+	URL      string
+	Token    string
+}
+
+type alwaysLink struct {
+	Host   string
+	Parent bool // Host is a parent domain; all subdomains are allowed too.
+	URL    string
+	Token  string
+
+	action string
+}
+
+// Durations for the allow links.
+var allowDurations = []struct{ duration, label string }{
+	{"10s", "ten seconds"},
+	{"1h", "an hour"},
+	{"1d", "a day"},
+	{"10y", "permanently"},
+}
+
+// A html/template or text/template.
+type pageTemplate interface {
+	Execute(io.Writer, interface{}) error
+}
+
+// The built-in templates, by the name of the file in template-dir that
+// replaces them.
+var builtinTemplates = map[string]pageTemplate{
+	"blocked.html":  parseTemplate("blocked.html", tplBlocked),
+	"blocked.js":    parseTemplate("blocked.js", tplBlockedJS),
+	"blocked.css":   parseTemplate("blocked.css", tplBlockedCSS),
+	"allowed.html":  parseTemplate("allowed.html", tplAllowed),
+	"rejected.html": parseTemplate("rejected.html", tplRejected),
+	"reported.html": parseTemplate("reported.html", tplReported),
+}
+
+var (
+	templateFuncs = template.FuncMap{"join": strings.Join}
+
+	// html/template would escape the scripts and stylesheets as HTML, so these
+	// are text templates with functions to escape values in JavaScript and CSS.
+	textTemplateFuncs = texttemplate.FuncMap{"join": strings.Join, "js": escapeJS, "css": escapeCSS}
+)
+
+// The templates loaded from template-dir, and the mtime of their file.
+var templates = struct {
+	sync.Mutex
+	t     map[string]pageTemplate
+	mtime map[string]time.Time
+}{t: make(map[string]pageTemplate), mtime: make(map[string]time.Time)}
+
+// LoadTemplates parses all templates in template-dir that were modified since
+// they were last loaded. This is also done when a page is rendered, so changes
+// are picked up without restart.
+func LoadTemplates() error {
+	names := make([]string, 0, len(builtinTemplates))
+	for n := range builtinTemplates {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var errs []string
+	for _, n := range names {
+		if _, err := loadTemplate(n); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Get the template for name from template-dir, or the built-in one if it
+// doesn't exist or can't be parsed. The error is only returned when the file
+// is loaded, and not again until it's modified.
+func loadTemplate(name string) (pageTemplate, error) {
+	builtin := builtinTemplates[name]
+	if cfg.Config.TemplateDir == "" {
+		return builtin, nil
+	}
+
+	path := filepath.Join(cfg.Config.TemplateDir, name)
+	st, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return builtin, nil
+	}
+	if err != nil {
+		return builtin, err
+	}
+
+	templates.Lock()
+	defer templates.Unlock()
+	if t, ok := templates.t[name]; ok && templates.mtime[name].Equal(st.ModTime()) {
+		return t, nil
+	}
+
+	templates.t[name], templates.mtime[name] = builtin, st.ModTime()
+	var t pageTemplate
+	if textTemplate(name) {
+		t, err = texttemplate.New(name).Funcs(textTemplateFuncs).ParseFiles(path)
+	} else {
+		t, err = template.New(name).Funcs(templateFuncs).ParseFiles(path)
+	}
+	if err != nil {
+		return builtin, err
+	}
+	templates.t[name] = t
+	return t, nil
+}
+
+func parseTemplate(name, tpl string) pageTemplate {
+	if textTemplate(name) {
+		return texttemplate.Must(texttemplate.New(name).Funcs(textTemplateFuncs).Parse(tpl))
+	}
+	return template.Must(template.New(name).Funcs(templateFuncs).Parse(tpl))
+}
+
+// Report if the template name is a text template.
+func textTemplate(name string) bool {
+	ext := filepath.Ext(name)
+	return ext == ".js" || ext == ".css"
+}
+
+// Escape s for use in a JavaScript string or comment; unlike the js function
+// from text/template this also escapes "/", so that "*/" can't end a comment.
+func escapeJS(s string) string {
+	return strings.Replace(texttemplate.JSEscapeString(s), "/", `\/`, -1)
+}
+
+// Escape s for use in a CSS string, identifier, or comment.
+func escapeCSS(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '-', r == '_', r == '.', r >= utf8.RuneSelf:
+			b.WriteRune(r)
+		default:
+			fmt.Fprintf(&b, "\\%x ", r)
+		}
+	}
+	return b.String()
+}
+
+// Render the template name; the built-in template is used if the one from
+// template-dir fails.
+func render(w http.ResponseWriter, status int, name string, data pageData) {
+	t, err := loadTemplate(name)
+	if err != nil {
+		msg.Warn(fmt.Errorf("template %v: %v", name, err))
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		msg.Warn(fmt.Errorf("template %v: %v", name, err))
+		buf.Reset()
+		msg.Warn(builtinTemplates[name].Execute(&buf, data))
+	}
+
+	switch filepath.Ext(name) {
+	case ".js":
+		w.Header().Set("Content-Type", "application/javascript")
+	case ".css":
+		w.Header().Set("Content-Type", "text/css")
+	default:
+		w.Header().Set("Content-Type", "text/html")
+	}
+	w.WriteHeader(status)
+	_, _ = buf.WriteTo(w)
+}
+
+// Render the page for a rejected request for host; path is the page to go back
+// to.
+func rejected(w http.ResponseWriter, status int, host, path string, err error) {
+	render(w, status, "rejected.html", pageData{Host: host, Path: path, Error: err.Error()})
+}
+
+// Get the data for the blocked page.
+func blockedData(r *http.Request) pageData {
+	name := hostname(r)
+	path := strings.TrimLeft(r.URL.Path, "/")
+	exp := time.Now().Add(allowTokenValid).Unix()
+	rule, o := srvdns.BlockedBy(name, net.ParseIP(clientAddr(r)))
+
+	data := pageData{
+		Host:        name,
+		Path:        path,
+		Rule:        rule,
+		List:        o.Source,
+		Line:        o.Line,
+		Client:      clientAddr(r),
+		Referer:     r.Referer(),
+		Expires:     exp,
+		Always:      alwaysAllowLink(name, path),
+		Report:      specialURL("$@_report/"+path, ""),
+		ReportToken: allowToken(name, "report", exp),
+	}
+	if data.Always != nil {
+		data.Always.Token = allowToken(name, data.Always.action, exp)
+	}
+	for _, d := range allowDurations {
+		p := "$@_allow/" + d.duration + "/" + path
+		link := func(scope, forWhom, query string) allowLink {
+			return allowLink{d.duration, d.label, specialURL(p, query),
+				allowToken(name, allowAction(d.duration, scope, forWhom), exp)}
+		}
+		data.Allow = append(data.Allow, link("", "", ""))
+		if d.duration == "1h" || d.duration == "1d" {
+			data.AllowExact = append(data.AllowExact, link("exact", "", "scope=exact"))
+		}
+		if d.duration != "10s" {
+			data.AllowAll = append(data.AllowAll, link("", "all", "for=all"))
+		}
+	}
+	return data
+}
+
+// Get the URL for one of the $@_ paths.
+func specialURL(path, query string) string {
+	u := url.URL{Path: "/" + path, RawQuery: query}
+	return u.String()
+}
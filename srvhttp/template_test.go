@@ -0,0 +1,104 @@
+package srvhttp
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"arp242.net/trackwall/cfg"
+	"arp242.net/trackwall/tt"
+)
+
+func TestTemplates(t *testing.T) {
+	defer cfg.Hosts.Purge()
+	cfg.Hosts.Add("tracker.example.com")
+
+	get := func(path string) (string, string) {
+		w := httptest.NewRecorder()
+		(&handleHTTP{}).ServeHTTP(w, httptest.NewRequest("GET", "http://tracker.example.com/"+path, nil))
+		return w.Header().Get("Content-Type"), w.Body.String()
+	}
+
+	t.Run("builtin", func(t *testing.T) {
+		ct, body := get("ad.html")
+		tt.Eq(t, "content-type", "text/html", ct)
+		for _, e := range []string{
+			`blocked access to <code>tracker.example.com</code> because of
+<code>tracker.example.com</code>.`,
+			`<button formaction="/$@_allow/1h/ad.html?scope=exact" name="token" value="`,
+			`<button formaction="/$@_always/host/ad.html" name="token" value="`,
+			`">always allow tracker.example.com</button>`,
+		} {
+			if !strings.Contains(body, e) {
+				t.Errorf("%q not in body:\n%v", e, body)
+			}
+		}
+
+		ct, body = get("x/ad.js")
+		tt.Eq(t, "content-type", "application/javascript", ct)
+		tt.Eq(t, "body", "/* trackwall blocked tracker.example.com */\n", body)
+		ct, _ = get("ad.css")
+		tt.Eq(t, "content-type", "text/css", ct)
+	})
+
+	t.Run("template-dir", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "trackwall-templates")
+		tt.Err(t, err)
+		defer os.RemoveAll(dir) // nolint: errcheck
+		cfg.Config.TemplateDir = dir
+		defer func() { cfg.Config.TemplateDir = "" }()
+
+		path := filepath.Join(dir, "blocked.html")
+		write := func(tpl string, mtime time.Time) {
+			tt.Err(t, ioutil.WriteFile(path, []byte(tpl), 0600))
+			tt.Err(t, os.Chtimes(path, mtime, mtime))
+		}
+
+		write(`<a href="https://help.example.com/?host={{.Host}}&rule={{.Rule}}">Helpdesk</a>`, time.Now())
+		tt.Err(t, LoadTemplates())
+		_, body := get("ad.html")
+		tt.Eq(t, "body", `<a href="https://help.example.com/?host=tracker.example.com&rule=tracker.example.com">Helpdesk</a>`, body)
+
+		// Reloaded when modified.
+		write(`{{.Client}}`, time.Now().Add(time.Minute))
+		_, body = get("ad.html")
+		tt.Eq(t, "body", "192.0.2.1", body)
+
+		// Use the builtin template if it can't be parsed.
+		write(`{{.Host`, time.Now().Add(2*time.Minute))
+		if err := LoadTemplates(); err == nil {
+			t.Error("no error")
+		}
+		_, body = get("ad.html")
+		if !strings.Contains(body, "Report as broken") {
+			t.Errorf("not the builtin template:\n%v", body)
+		}
+
+		// Only replaces blocked.html.
+		_, body = get("ad.js")
+		tt.Eq(t, "body", "/* trackwall blocked tracker.example.com */\n", body)
+
+		// Scripts aren't escaped as HTML.
+		path = filepath.Join(dir, "blocked.js")
+		write(`var host = "{{js .Host}}", path = "{{.Path}}";`, time.Now())
+		_, body = get("a&b.js")
+		tt.Eq(t, "body", `var host = "tracker.example.com", path = "a&b.js";`, body)
+
+		// The rejected page.
+		path = filepath.Join(dir, "rejected.html")
+		write(`{{.Host}}: {{.Error}}`, time.Now())
+		w := httptest.NewRecorder()
+		(&handleHTTP{}).ServeHTTP(w, httptest.NewRequest("GET", "http://tracker.example.com/$@_allow/1h/", nil))
+		tt.Eq(t, "status", 403, w.Code)
+		tt.Eq(t, "body", "tracker.example.com: the request was not sent with POST", w.Body.String())
+	})
+}
+
+func TestEscape(t *testing.T) {
+	tt.Eq(t, "js", `x*\/\u003C\u003C\/script\u003E\"`, escapeJS(`x*/<</script>"`))
+	tt.Eq(t, "css", `a.b-c\2a \2f \7d é`, escapeCSS(`a.b-c*/}é`))
+}
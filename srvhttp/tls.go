@@ -28,7 +28,11 @@ var certValidity = time.Hour * 24 * 30 * 38
 // TODO: This can be a lot more efficient.
 // TODO: certs written out are world-readable
 func getCert(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-	name := clientHello.ServerName
+	return certFor(clientHello.ServerName)
+}
+
+// Get the certificate for name, making it if it doesn't exist yet.
+func certFor(name string) (*tls.Certificate, error) {
 	if name == "" {
 		return nil, fmt.Errorf("no ServerName")
 	}
@@ -59,44 +63,7 @@ func getCert(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
 func makeCert(name, certfile string) error {
 	msg.Debug("    Making a cert for "+name, cfg.Config.Verbose)
 
-	// Load root CA
-	fp, err := os.Open(cfg.Config.RootCert)
-	if err != nil {
-		return err
-	}
-	data, err := ioutil.ReadAll(fp)
-	if err != nil {
-		return err
-	}
-	rootpem, _ := pem.Decode(data)
-	err = fp.Close()
-	if err != nil {
-		return err
-	}
-
-	rootcerts, err := x509.ParseCertificates(rootpem.Bytes)
-	if err != nil {
-		msg.Warn(err)
-		return err
-	}
-	rootcert := *rootcerts[0]
-
-	// Load root key
-	fp, err = os.Open(cfg.Config.RootKey)
-	if err != nil {
-		return err
-	}
-	data, err = ioutil.ReadAll(fp)
-	if err != nil {
-		return err
-	}
-	rootpem, _ = pem.Decode(data)
-	err = fp.Close()
-	if err != nil {
-		return err
-	}
-
-	rootkey, err := x509.ParsePKCS1PrivateKey(rootpem.Bytes)
+	rootcert, rootkey, err := loadRoot(cfg.Config.RootCert, cfg.Config.RootKey)
 	if err != nil {
 		msg.Warn(err)
 		return err
@@ -126,12 +93,12 @@ func makeCert(name, certfile string) error {
 		template.DNSNames = append(template.DNSNames, name)
 	}
 
-	cert, err := x509.CreateCertificate(rand.Reader, &template, &rootcert, &rootkey.PublicKey, rootkey)
+	cert, err := x509.CreateCertificate(rand.Reader, &template, rootcert, &rootkey.PublicKey, rootkey)
 	if err != nil {
 		msg.Warn(err)
 		return err
 	}
-	fp, err = os.Create(certfile)
+	fp, err := os.Create(certfile)
 	if err != nil {
 		return err
 	}
@@ -144,6 +111,37 @@ func makeCert(name, certfile string) error {
 	return fp.Close()
 }
 
+// Load the root certificate and key.
+func loadRoot(certPath, keyPath string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	rootpem, _ := pem.Decode(data)
+	if rootpem == nil {
+		return nil, nil, fmt.Errorf("%v: no PEM data", certPath)
+	}
+	rootcerts, err := x509.ParseCertificates(rootpem.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err = ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	rootpem, _ = pem.Decode(data)
+	if rootpem == nil {
+		return nil, nil, fmt.Errorf("%v: no PEM data", keyPath)
+	}
+	rootkey, err := x509.ParsePKCS1PrivateKey(rootpem.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rootcerts[0], rootkey, nil
+}
+
 // MakeRootKey makes a new root key.
 // NOTE: Assumes that it is run *BEFORE* chroot(). See chroot() in main.go
 func MakeRootKey() error {
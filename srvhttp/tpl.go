@@ -1,12 +1,55 @@
 package srvhttp
 
 const (
-	tplBlocked = `<html><head><title> trackwall %[1]s</title></head><body>
-<p>trackwall blocked access to <code>%[1]s</code>. Unblock this domain for:</p>
-<ul><li><a href="/$@_allow/10s/%[2]s">ten seconds</a></li>
-<li><a href="/$@_allow/1h/%[2]s">an hour</a></li>
-<li><a href="/$@_allow/1d/%[2]s">a day</a></li>
-<li><a href="/$@_allow/10y/%[2]s">until restart</a></li></ul></body></html>`
+	// The templates can be replaced by files in template-dir; see pageData for
+	// the data. The .js and .css templates are text templates, and need to
+	// escape with the js and css functions.
+	tplBlocked = `<html><head><title> trackwall {{.Host}}</title></head><body>
+<form method="post">
+<input type="hidden" name="expires" value="{{.Expires}}">
+<input type="hidden" name="referer" value="{{.Referer}}">
+<p>trackwall blocked access to <code>{{.Host}}</code>{{with .Rule}} because of
+<code>{{.}}</code>{{end}}. Unblock this domain for:
+{{range .Allow}}<button formaction="{{.URL}}" name="token" value="{{.Token}}">{{.Label}}</button>
+{{end}}</p>
+<p>This also unblocks all subdomains of the blocked domain. To unblock only
+<code>{{.Host}}</code> for:
+{{range .AllowExact}}<button formaction="{{.URL}}" name="token" value="{{.Token}}">{{.Label}}</button>
+{{end}}</p>
+<p>This only unblocks it for this device. To unblock it for everyone:
+{{range .AllowAll}}<button formaction="{{.URL}}" name="token" value="{{.Token}}">{{.Label}}</button>
+{{end}}</p>
+{{with .Always}}<p>Or <button formaction="{{.URL}}" name="token" value="{{.Token}}">always allow {{.Host}}{{if .Parent}} and all its subdomains{{end}}</button></p>
+{{end}}<p>Is this wrongly blocked?
+<button formaction="{{.Report}}" name="token" value="{{.ReportToken}}">Report as broken</button></p>
+</form></body></html>`
+
+	tplBlockedJS = `/* trackwall blocked {{js .Host}} */
+`
+
+	tplBlockedCSS = `/* trackwall blocked {{css .Host}} */
+`
+
+	tplReported = `<html><head><title> trackwall {{.Host}}</title></head><body>
+<p>Thanks; <code>{{.Host}}</code> was reported as wrongly blocked.</p>
+<p><a href="/{{.Path}}">Go back</a> to unblock it.</p>
+</body></html>`
+
+	tplAllowed = `<html><head><title> trackwall {{.Host}}</title></head><body>
+<form method="post" action="{{.Undo}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<input type="hidden" name="expires" value="{{.Expires}}">
+<input type="hidden" name="hosts" value="{{join .Allowed ","}}">
+<p>trackwall will always allow <code>{{join .Allowed ", "}}</code>; this is saved
+in <code>config.managed</code>.</p>
+<p><a href="/{{.Path}}">Continue to the page</a>, or <button>undo</button>.</p>
+</form></body></html>`
+
+	tplRejected = `<html><head><title> trackwall {{.Host}}</title></head><body>
+<p>trackwall refused to unblock <code>{{.Host}}</code>: {{.Error}}.</p>
+<p>Only the buttons on the blocked page can unblock a domain, and every page can
+be used once. <a href="/{{.Path}}">Load the page again</a> to get a new blocked page.</p>
+</body></html>`
 
 	// nolint: megacheck,varcheck
 	tplList = `<html><head><title>trackwall</title></head><body><ul>